@@ -4,11 +4,16 @@ package huml
 
 import (
 	"bytes"
+	"encoding"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // dataType represents the type of a HUML document structure.
@@ -27,23 +32,483 @@ const (
 // Decoder reads and decodes HUML values from an input stream.
 type Decoder struct {
 	parser *streamParser
+	state  decodeState
+	events *eventReader // Created by the first call to Token.
 }
 
-// NewDecoder returns a new decoder that reads from r.
-func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{
+// decodeState holds the options that control how parsed values are set
+// into Go values during a Decode call.
+type decodeState struct {
+	specialFloatStrings bool // Accept "inf", "-inf" and "nan" strings for floats.
+	lenient             bool // Coerce between strings and scalar types.
+	appendSlices        bool // Append to existing slices instead of replacing them.
+
+	comments map[uintptr]string  // Dict comments recorded by the parser.
+	literals map[valueRef]string // Float texts recorded by the parser.
+	literal  string              // Text of the number being set, if recorded.
+
+	unions map[reflect.Type]union                    // Registered unions, by interface type.
+	enums  map[reflect.Type]map[string]reflect.Value // Registered enum values, by type and name.
+
+	defaulter func(fieldPath string) (any, bool) // Values for absent struct fields.
+	path      []string                           // Keys and indices leading to the value being set.
+}
+
+// NewDecoder returns a new decoder that reads from r, configured with opts.
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	dec := &Decoder{
 		parser: newStreamParser(newLexer(r)),
 	}
+	for _, opt := range opts {
+		opt(dec)
+	}
+	return dec
+}
+
+// Option configures a Decoder. Options can be passed to NewDecoder and
+// Unmarshal, and each one corresponds to the Decoder method of the same name.
+type Option func(*Decoder)
+
+// RequireIndentMultiple returns an Option that calls
+// Decoder.RequireIndentMultiple with n.
+func RequireIndentMultiple(n int) Option {
+	return func(dec *Decoder) { dec.RequireIndentMultiple(n) }
+}
+
+// BaseIndent returns an Option that calls Decoder.BaseIndent with n.
+func BaseIndent(n int) Option {
+	return func(dec *Decoder) { dec.BaseIndent(n) }
+}
+
+// MaxDepth returns an Option that calls Decoder.MaxDepth with n.
+func MaxDepth(n int) Option {
+	return func(dec *Decoder) { dec.MaxDepth(n) }
+}
+
+// MaxElements returns an Option that calls Decoder.MaxElements with n.
+func MaxElements(n int) Option {
+	return func(dec *Decoder) { dec.MaxElements(n) }
+}
+
+// MaxLines returns an Option that calls Decoder.MaxLines with n.
+func MaxLines(n int) Option {
+	return func(dec *Decoder) { dec.MaxLines(n) }
+}
+
+// AllowTrailingSpaces returns an Option that calls Decoder.AllowTrailingSpaces.
+func AllowTrailingSpaces() Option {
+	return (*Decoder).AllowTrailingSpaces
+}
+
+// StrictBlankLines returns an Option that calls Decoder.StrictBlankLines.
+func StrictBlankLines() Option {
+	return (*Decoder).StrictBlankLines
+}
+
+// DisallowInlineVectors returns an Option that calls
+// Decoder.DisallowInlineVectors.
+func DisallowInlineVectors() Option {
+	return (*Decoder).DisallowInlineVectors
+}
+
+// AllowBareStrings returns an Option that calls Decoder.AllowBareStrings.
+func AllowBareStrings() Option {
+	return (*Decoder).AllowBareStrings
+}
+
+// AllowLeadingDecimalPoint returns an Option that calls
+// Decoder.AllowLeadingDecimalPoint.
+func AllowLeadingDecimalPoint() Option {
+	return (*Decoder).AllowLeadingDecimalPoint
+}
+
+// LenientEscapes returns an Option that calls Decoder.LenientEscapes.
+func LenientEscapes() Option {
+	return (*Decoder).LenientEscapes
+}
+
+// AllowLineContinuations returns an Option that calls
+// Decoder.AllowLineContinuations.
+func AllowLineContinuations() Option {
+	return (*Decoder).AllowLineContinuations
+}
+
+// ExpandDottedKeys returns an Option that calls Decoder.ExpandDottedKeys.
+func ExpandDottedKeys() Option {
+	return (*Decoder).ExpandDottedKeys
+}
+
+// AllowUnicodeKeys returns an Option that calls Decoder.AllowUnicodeKeys.
+func AllowUnicodeKeys() Option {
+	return (*Decoder).AllowUnicodeKeys
+}
+
+// AllowedRootKeys returns an Option that calls Decoder.AllowedRootKeys with keys.
+func AllowedRootKeys(keys ...string) Option {
+	return func(dec *Decoder) { dec.AllowedRootKeys(keys...) }
+}
+
+// DefaultEmptyVector returns an Option that calls Decoder.DefaultEmptyVector with v.
+func DefaultEmptyVector(v EmptyVector) Option {
+	return func(dec *Decoder) { dec.DefaultEmptyVector(v) }
+}
+
+// SetDefaulter returns an Option that calls Decoder.SetDefaulter with fn.
+func SetDefaulter(fn func(fieldPath string) (any, bool)) Option {
+	return func(dec *Decoder) { dec.SetDefaulter(fn) }
+}
+
+// Lenient returns an Option that calls Decoder.Lenient.
+func Lenient() Option {
+	return (*Decoder).Lenient
+}
+
+// AppendToSlices returns an Option that calls Decoder.AppendToSlices.
+func AppendToSlices() Option {
+	return (*Decoder).AppendToSlices
+}
+
+// SpecialFloatStrings returns an Option that calls Decoder.SpecialFloatStrings.
+func SpecialFloatStrings() Option {
+	return (*Decoder).SpecialFloatStrings
+}
+
+// RegisterEnum returns an Option that calls Decoder.RegisterEnum with values.
+func RegisterEnum(values ...fmt.Stringer) Option {
+	return func(dec *Decoder) { dec.RegisterEnum(values...) }
+}
+
+// RegisterUnion returns an Option that calls Decoder.RegisterUnion with the
+// given arguments.
+func RegisterUnion(iface reflect.Type, discriminator string, variants map[string]func() any) Option {
+	return func(dec *Decoder) { dec.RegisterUnion(iface, discriminator, variants) }
 }
 
 // Decode reads the HUML document from the input stream and stores the result in the pointer v.
+// The input is read incrementally, a line at a time, rather than buffered whole.
+//
+// Decoding is best effort. If the document is malformed part of the way
+// through, the entries of multi-line vectors read before the error are still
+// stored in v. Likewise, a value that cannot be stored in its field, list
+// element or map entry doesn't stop the others from being stored. The field,
+// element or entry holds as much of the value as could be decoded: a struct,
+// slice or map holds its fields and items that could be stored, and a scalar
+// is left unset, which for an element or entry means the zero value. Only an
+// entry whose key cannot be converted to the map's key type is left out. In
+// every case, the first error is returned.
 func (dec *Decoder) Decode(v any) error {
 	out, err := dec.parser.parse()
 	if err != nil {
+		if out != nil {
+			dec.state.comments = dec.parser.comments
+			dec.state.literals = dec.parser.literals
+			dec.state.setValue(v, out)
+		}
 		return err
 	}
 
-	return setValue(v, out)
+	dec.state.comments = dec.parser.comments
+	dec.state.literals = dec.parser.literals
+	return dec.state.setValue(v, out)
+}
+
+// Version returns the version given by the %HUML directive at the start of
+// the document read by Decode, such as "v0.2.0", and whether the document
+// has a directive at all. A directive without a version returns "" and true.
+func (dec *Decoder) Version() (string, bool) {
+	return dec.parser.lexer.version, dec.parser.lexer.hasDirective
+}
+
+// Header returns the lines of the comment block at the start of the document
+// read by Decode, after any %HUML directive and before the first content, such
+// as a license notice, with the leading "# " of each line removed. The block
+// ends at the first blank line or content line. It returns nil if the
+// document doesn't start with a comment.
+func (dec *Decoder) Header() []string {
+	return dec.parser.lexer.header
+}
+
+// BaseIndent causes the Decoder to read a document whose root level is
+// indented by n spaces, as written by an Encoder with SetBaseIndent, by
+// removing n spaces from the start of every line. Every line that isn't blank
+// must start with at least n spaces. Columns in errors are counted after the
+// base indentation. A value of n <= 0, the default, reads documents whose root
+// is not indented.
+func (dec *Decoder) BaseIndent(n int) {
+	dec.parser.lexer.baseIndent = max(n, 0)
+}
+
+// RequireIndentMultiple causes the Decoder to reject any content line whose
+// indentation is not a multiple of n spaces, reporting the offending line.
+// The default is 2, as mandated by the spec. A value of n <= 0, or 1, disables
+// the check, leaving only the structural indentation checks of the parser,
+// which report odd indentation as a bad indent instead. As every nested level
+// is indented exactly 2 spaces deeper than its parent, no document could meet
+// a greater n, so decoding with one returns an error.
+func (dec *Decoder) RequireIndentMultiple(n int) {
+	if n > 2 {
+		dec.parser.lexer.err = fmt.Errorf("huml: indentation multiple of %d is not supported, nested levels are 2 spaces deeper than their parent", n)
+		return
+	}
+	dec.parser.lexer.indentMultiple = max(n, 0)
+}
+
+// MaxDepth sets the maximum nesting depth of vectors below the root that the
+// Decoder accepts, returning an error for deeper documents instead of
+// recursing further. The default is 1000. A value of n <= 0 removes the limit.
+func (dec *Decoder) MaxDepth(n int) {
+	dec.parser.maxDepth = max(n, 0)
+}
+
+// MaxElements sets the maximum number of dict keys and list items, counted
+// across the whole document, that the Decoder accepts, returning an error as
+// soon as a document has more. Together with MaxDepth, this bounds the size of
+// the values built from untrusted input. By default, there is no limit. A
+// value of n <= 0 removes the limit.
+func (dec *Decoder) MaxElements(n int) {
+	dec.parser.maxElements = max(n, 0)
+}
+
+// MaxLines sets the maximum number of lines, blank and comment lines
+// included, that the Decoder reads, returning an error as soon as a document
+// has more. It bounds the work spent on untrusted input made of many short
+// lines, which MaxElements alone doesn't catch. By default, there is no
+// limit. A value of n <= 0 removes the limit.
+func (dec *Decoder) MaxLines(n int) {
+	dec.parser.lexer.maxLines = max(n, 0)
+}
+
+// AllowTrailingSpaces causes the Decoder to ignore trailing spaces at the end
+// of lines, for documents produced by tools that pad their output. By default,
+// trailing spaces are a syntax error. Trailing spaces within the content of a
+// multi-line string are part of the string in either mode.
+func (dec *Decoder) AllowTrailingSpaces() {
+	dec.parser.lexer.trimTrailing = true
+}
+
+// RegisterEnum causes the Decoder to decode a string into the type of values,
+// such as an iota enum, by selecting the value whose String method returns
+// it, as written by an Encoder with SetEnumNames. This also applies to map
+// keys. Numbers still decode into the type as usual, and an unknown name is an
+// error. All values must be of the same integer type, and RegisterEnum panics
+// otherwise.
+func (dec *Decoder) RegisterEnum(values ...fmt.Stringer) {
+	if len(values) == 0 {
+		return
+	}
+	t := reflect.TypeOf(values[0])
+	if !isIntegerKind(t.Kind()) {
+		panic(fmt.Sprintf("huml: RegisterEnum of non-integer type %s", t))
+	}
+	if dec.state.enums == nil {
+		dec.state.enums = make(map[reflect.Type]map[string]reflect.Value)
+	}
+	names := make(map[string]reflect.Value, len(values))
+	for _, v := range values {
+		if reflect.TypeOf(v) != t {
+			panic(fmt.Sprintf("huml: RegisterEnum of mixed types %s and %T", t, v))
+		}
+		names[v.String()] = reflect.ValueOf(v)
+	}
+	dec.state.enums[t] = names
+}
+
+// RegisterUnion causes the Decoder to decode dicts into the interface type
+// iface, such as reflect.TypeFor[Shape](), by selecting a concrete type with
+// the discriminator field of each dict, as UnmarshalUnion does for a whole
+// document. This applies wherever iface appears in the destination,
+// including as the element type of a slice such as []Shape.
+//
+// Each factory in variants must return a pointer to a new value, which must
+// implement iface. The decoded pointer is stored in the interface. Decoding
+// into an interface type with methods that has no registered union is an
+// error. RegisterUnion panics if iface is not an interface type.
+func (dec *Decoder) RegisterUnion(iface reflect.Type, discriminator string, variants map[string]func() any) {
+	if iface.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("huml: RegisterUnion of non-interface type %s", iface))
+	}
+	if dec.state.unions == nil {
+		dec.state.unions = make(map[reflect.Type]union)
+	}
+	dec.state.unions[iface] = union{discriminator, variants}
+}
+
+// StrictBlankLines causes the Decoder to reject blank lines at the start or
+// end of the document, and runs of more than one blank line anywhere, to
+// enforce a house style. By default, blank lines are ignored. Blank lines
+// within multi-line strings are content and are always allowed.
+func (dec *Decoder) StrictBlankLines() {
+	dec.parser.lexer.strictBlanks = true
+}
+
+// DisallowInlineVectors causes the Decoder to reject inline lists and dicts,
+// such as `ports:: 80, 443` or `point:: x: 1, y: 2`, including at the root,
+// to enforce the multi-line form. The empty vectors [] and {} are still
+// allowed, as they have no multi-line form.
+func (dec *Decoder) DisallowInlineVectors() {
+	dec.parser.noInline = true
+}
+
+// AllowBareStrings causes the Decoder to read an unquoted word that is not a
+// keyword, such as the value in `mode: fast`, as a string, to ease migrating
+// documents from formats that allow this. A word is made of letters, digits,
+// underscores and hyphens, and starts with a letter, so values with spaces or
+// other punctuation must still be quoted.
+//
+// This is not part of the HUML spec, and the type of such a value depends on
+// its text: `answer: no` is the string "no", but `answer: true` is a bool and
+// `answer: null` is null. Documents relying on it are best converted to
+// quoted strings. By default, unquoted strings are rejected.
+func (dec *Decoder) AllowBareStrings() {
+	dec.parser.lexer.bareStrings = true
+}
+
+// AllowLineContinuations causes the Decoder to accept a '\' at the end of a
+// line inside a double-quoted string as a line continuation: the '\' is
+// dropped and the string goes on at the start of the next line, without a
+// newline, as in
+//
+//	description: "a long text that \
+//	goes on"
+//
+// Indentation on the next line is part of the string. This is not part of the
+// HUML spec. By default, a '\' at the end of a line is an incomplete escape.
+func (dec *Decoder) AllowLineContinuations() {
+	dec.parser.lexer.continuations = true
+}
+
+// LenientEscapes causes the Decoder to keep an unknown escape sequence in a
+// double-quoted string or key, such as \x or \d, as the two characters
+// written, rather than reject it, for ingesting documents produced by tools
+// that don't escape backslashes, such as Windows paths or regular expressions.
+// Known escapes are decoded as usual, and a malformed \u escape is still an
+// error. This is not part of the HUML spec, and it makes the meaning of a
+// backslash depend on the character after it: "C:\temp" holds a tab, while
+// "C:\data" holds a backslash, and a string written for a later version of
+// HUML with new escapes would silently decode differently. Values checked
+// for safety, such as paths, should not rely on it. By default, an unknown
+// escape is a syntax error.
+func (dec *Decoder) LenientEscapes() {
+	dec.parser.lexer.lenientEscapes = true
+}
+
+// AllowLeadingDecimalPoint causes the Decoder to accept floats written
+// without a zero before the decimal point, such as .5, -.5 and +.5, as in
+// many programming languages. They decode as the same floats as 0.5, -0.5 and
+// 0.5. This is not part of the HUML spec. By default, and for a point that
+// isn't followed by a digit, a leading '.' is a syntax error.
+func (dec *Decoder) AllowLeadingDecimalPoint() {
+	dec.parser.lexer.leadingDot = true
+}
+
+// ExpandDottedKeys causes the Decoder to read a bare key made of words joined
+// by dots, such as a.b.c, as a chain of nested dicts, so that `a.b.c: 1` is
+// the same as
+//
+//	a::
+//	  b::
+//	    c: 1
+//
+// Dotted keys with a common prefix, such as a.b and a.c, share the dict of
+// the prefix, but can't add to a dict written out in full. A quoted key such
+// as "a.b" is never split. This is not part of the HUML spec. Token returns
+// dotted keys as they are, but rejects the same conflicting keys as Decode.
+// By default, dots are not allowed in bare keys. An Encoder with
+// SetDottedKeys writes such keys.
+func (dec *Decoder) ExpandDottedKeys() {
+	dec.parser.lexer.dottedKeys = true
+}
+
+// AllowUnicodeKeys causes the Decoder to accept Unicode letters in bare
+// keys, as in `café: 1` or `名前: "x"`, which must otherwise be quoted. Digits,
+// underscores and hyphens are still ASCII, and a key must start with a letter.
+// Such documents are not portable to parsers without this extension. See
+// Encoder.SetUnicodeKeys for writing them.
+func (dec *Decoder) AllowUnicodeKeys() {
+	dec.parser.lexer.unicodeKeys = true
+}
+
+// AllowedRootKeys causes the Decoder to reject a document whose root is a
+// dict with a key other than the given ones, reporting the first unknown key
+// and its line. This applies whatever the destination type, including
+// map[string]any. Calling it with no keys rejects any key in a root dict.
+func (dec *Decoder) AllowedRootKeys(keys ...string) {
+	allowed := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		allowed[key] = struct{}{}
+	}
+	dec.parser.allowedRootKeys = allowed
+}
+
+// EmptyVector selects how a Decoder treats a '::' indicator followed by
+// nothing, such as a key with no indented content below it.
+type EmptyVector int
+
+const (
+	// EmptyVectorError rejects the document, as the spec requires [] or {}
+	// for an empty vector. This is the default.
+	EmptyVectorError EmptyVector = iota
+
+	// EmptyVectorDict treats the vector as an empty dict.
+	EmptyVectorDict
+
+	// EmptyVectorList treats the vector as an empty list.
+	EmptyVectorList
+)
+
+// DefaultEmptyVector sets how the Decoder treats an empty vector written
+// without [] or {}, for lenient ingestion of documents that rely on it.
+func (dec *Decoder) DefaultEmptyVector(v EmptyVector) {
+	dec.parser.emptyVector = v
+}
+
+// SetDefaulter sets a function that supplies values for struct fields whose
+// key is absent from the document, such as from environment variables. It
+// is called with the path of the field, made of the keys leading to it and
+// the indices of list items joined by ".", as in "servers.0.port". If it
+// returns true, the value it returns is decoded into the field as if it had
+// been in the document, so it may be of any type that Unmarshal produces.
+// Fields for which it returns false are left alone.
+func (dec *Decoder) SetDefaulter(fn func(fieldPath string) (any, bool)) {
+	dec.state.defaulter = fn
+}
+
+// Lenient causes the Decoder to coerce scalar values into the destination type
+// where a human would consider the value equivalent, for configs written by
+// hand. By default, types must match exactly. The coercions allowed are:
+//   - a string into an integer type, if it is a valid HUML integer literal
+//     (decimal, or with a 0x, 0o or 0b prefix, with underscores only between
+//     digits), so that "010" is 10
+//   - a string into a float type, if it is a valid HUML number literal; the
+//     words inf and nan are only accepted with SpecialFloatStrings
+//   - the strings "true" and "1" into true, and "false" and "0" into false,
+//     for bool types
+//   - the numbers 1 and 0, as integers or floats, into true and false for bool
+//     types; other numbers are rejected
+//   - integers, floats and bools into a string type, using their canonical
+//     HUML representation
+//
+// Out-of-range values are rejected as they are in strict mode.
+func (dec *Decoder) Lenient() {
+	dec.state.lenient = true
+}
+
+// AppendToSlices causes the Decoder to append the items of a list to the
+// slice it is decoded into, rather than replacing the contents of the slice,
+// so that several documents can be decoded into the same value to accumulate
+// their lists. It applies to every slice, including slice fields of an
+// existing struct. Arrays are still overwritten from the first element. By
+// default, a slice holds only the items of the list after decoding.
+func (dec *Decoder) AppendToSlices() {
+	dec.state.appendSlices = true
+}
+
+// SpecialFloatStrings causes the Decoder to accept the quoted strings "inf",
+// "+inf", "-inf" and "nan" as the corresponding special values when the
+// destination is a float type. By default, a quoted string cannot be
+// decoded into a float.
+func (dec *Decoder) SpecialFloatStrings() {
+	dec.state.specialFloatStrings = true
 }
 
 // Unmarshal parses HUML data and stores the result in the value pointed to by v.
@@ -56,23 +521,163 @@ func (dec *Decoder) Decode(v any) error {
 //   - float64 for floating point numbers
 //   - bool for true/false
 //   - nil for null
-//   - math.NaN() for nan
+//   - math.NaN() for nan, which has no sign: +nan and -nan are errors
 //   - math.Inf() for inf/+inf/-inf
 //   - HUML vectors (key:: value) become []any for lists and map[string]any for dicts.
 //   - HUML documents can become any of the above types, including nil.
 //
-// If the data contains a syntax error, a parser error is returned with line number.
-func Unmarshal(data []byte, v any) error {
+// These are the types stored in a value of type any, such as a struct field,
+// at any depth of the []any and map[string]any values it holds. In particular,
+// an integer always becomes an int64, not an int, however small it is, and a
+// number written with a fraction or an exponent, such as 1.0 or 1e3, becomes a
+// float64 even if it is whole, so the distinction survives decoding.
+//
+// A document must have content: an empty one, or one holding just comments
+// or a %HUML version directive, is an error rather than an empty value, as
+// the spec leaves it undefined.
+//
+// A null sets the destination to its zero value. A pointer becomes nil and
+// a struct has all of its fields zeroed, so a document can clear a value that
+// v held before the call.
+//
+// A destination implementing encoding.TextUnmarshaler, such as a net.IP or
+// time.Time, accepts a string, which is passed to its UnmarshalText method.
+// One that isn't itself a number type, such as a decimal type, also accepts a
+// number, and is passed the number as written, so that 10.10 keeps its
+// trailing zero and 1.5e3 its exponent. Numbers written with underscores
+// are passed in their shortest form, as are integers.
+//
+// A big.Rat destination accepts an integer, a float, taken as its shortest
+// decimal representation so that 0.1 is exactly 1/10, or a string in any
+// form accepted by big.Rat.SetString, such as "3/4".
+//
+// A string struct field tagged `huml:",comment"` receives the block of comment
+// lines directly above the first entry of the multi-line dict decoded into the
+// struct, with the leading "# " of each line removed and lines joined by "\n".
+// A blank line between the comments and the entry detaches them. Such fields
+// are ignored when marshalling.
+//
+// A field can list former names in a humlalt tag, so that documents written
+// before a key was renamed still decode. With `huml:"listen" humlalt:"bind,addr"`,
+// the value of listen is used if present, and otherwise that of bind, then
+// addr. Only the name in the huml tag is written when marshalling.
+//
+// A number field tagged with a unit, such as `huml:"timeout,unit=s"`, reads
+// a number in that unit, so that `timeout: 30` sets a time.Duration to 30
+// seconds and `size: 1.5` with `unit=MB` sets an integer to 1500000 bytes. See
+// Marshal for the supported units. With Lenient, a string holding a number,
+// such as "30", is read in the unit as well.
+//
+// A time.Time field tagged with a layout, such as
+// `huml:"created,timeformat=2006-01-02"`, reads a string in that layout.
+//
+// A struct whose fields are tagged with positions, such as `huml:",pos=0"`,
+// also decodes from a list, the item at each index setting the field with
+// that position, so that `[1, "a", true]` fills a tuple-like struct. The
+// list must have exactly one item per positional field. Such a struct still
+// decodes from a dict, and Marshal writes it as one.
+//
+// A struct with a field tagged `huml:",scalar"`, such as a wrapper type Port
+// whose only field V holds the port number, also decodes from any value other
+// than a dict, which sets that field, so that `port: 8080` fills a Port. A
+// struct may have only one such field.
+//
+// A struct field tagged `huml:",document"` receives the whole dict decoded
+// into the struct, in addition to the fields it sets, which for the root
+// struct is the entire document. It is typically a map[string]any or a
+// RawMessage. A struct may have only one such field, and it is ignored when
+// marshalling.
+//
+// Options such as Lenient configure decoding as they would a Decoder.
+//
+// If the data contains a syntax error, a *SyntaxError is returned with the
+// line number. A value that doesn't fit its destination gives a *TypeError,
+// a union dict without its discriminator a *MissingFieldError, and a root key
+// rejected by AllowedRootKeys an *UnknownFieldError. They may be wrapped, so
+// use errors.As to retrieve them.
+func Unmarshal(data []byte, v any, opts ...Option) error {
 	if len(data) == 0 {
 		return errors.New("empty document is undefined")
 	}
 
-	dec := NewDecoder(bytes.NewReader(data))
+	dec := NewDecoder(bytes.NewReader(data), opts...)
 	return dec.Decode(v)
 }
 
+// UnmarshalIndented is like Unmarshal, but reads a fragment of a document
+// whose root level is indented by baseIndent spaces, such as a block cut out
+// of a larger document. See Decoder.BaseIndent.
+func UnmarshalIndented(data []byte, baseIndent int, v any, opts ...Option) error {
+	return Unmarshal(data, v, append([]Option{BaseIndent(baseIndent)}, opts...)...)
+}
+
+// UnmarshalEach reads a HUML document whose root is a list from r and calls
+// fn with the encoding of each item, which can be decoded with Unmarshal. The
+// items of a multi-line list are read one at a time, so that a long list of
+// records can be processed without holding all of it in memory. An error from
+// fn stops reading and is returned.
+func UnmarshalEach(r io.Reader, fn func(raw []byte) error, opts ...Option) error {
+	dec := NewDecoder(r, opts...)
+	return dec.parser.parseEach(func(item any) error {
+		raw, err := encodeRaw(item)
+		if err != nil {
+			return err
+		}
+		return fn(raw)
+	})
+}
+
+// UnmarshalUnion decodes a HUML document whose concrete type is selected by a
+// discriminator field at the root, such as `kind: "click"`. The discriminator
+// value is looked up in variants, and the factory found there must return a
+// pointer to decode the full document into. That pointer is returned.
+//
+// An error is returned if the document is not a dict, if the discriminator
+// field is missing or not a string, or if it names no known variant.
+func UnmarshalUnion(data []byte, discriminator string, variants map[string]func() any) (any, error) {
+	var root map[string]any
+	if err := Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	newVariant, err := union{discriminator, variants}.variant(root)
+	if err != nil {
+		return nil, err
+	}
+
+	v := newVariant()
+	if err := Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// union selects the concrete type of a dict by its discriminator field.
+type union struct {
+	discriminator string
+	variants      map[string]func() any
+}
+
+// variant returns the factory of the variant named by the discriminator
+// field of m.
+func (u union) variant(m map[string]any) (func() any, error) {
+	val, ok := m[u.discriminator]
+	if !ok {
+		return nil, &MissingFieldError{Field: u.discriminator}
+	}
+	kind, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("discriminator field %q must be a string, not %T", u.discriminator, val)
+	}
+	newVariant, ok := u.variants[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown %s %q", u.discriminator, kind)
+	}
+	return newVariant, nil
+}
+
 // setValue sets the destination value from the parsed source value.
-func setValue(dst, src any) error {
+func (d *decodeState) setValue(dst, src any) error {
 	if dst == nil {
 		return errors.New("cannot unmarshal into a nil value")
 	}
@@ -85,19 +690,72 @@ func setValue(dst, src any) error {
 		return errors.New("destination pointer is nil")
 	}
 
-	d := val.Elem()
-	return setValueReflect(d, src)
+	d.literal = d.literals[valueRef{}]
+	return d.setValueReflect(val.Elem(), src)
 }
 
 // setValueReflect recursively sets values to dst from src using reflection.
-func setValueReflect(dst reflect.Value, src any) error {
+func (d *decodeState) setValueReflect(dst reflect.Value, src any) error {
+	// A null zeroes any destination, leaving a pointer nil rather than
+	// allocating a value for it.
 	if src == nil {
 		dst.Set(reflect.Zero(dst.Type()))
 		return nil
 	}
 
+	// A RawMessage captures the encoded value for later decoding.
+	if dst.Type() == rawMessageType {
+		raw, err := encodeRaw(src)
+		if err != nil {
+			return err
+		}
+		dst.SetBytes(raw)
+		return nil
+	}
+
+	// A big.Rat holds a number exactly, from a numeric literal or a string.
+	if dst.Type() == ratType {
+		return d.setRat(dst, src)
+	}
+
+	// A Number holds a number as it was written.
+	if dst.Type() == numberType {
+		return d.setNumber(dst, src)
+	}
+
+	// A type with a text form, such as a net.IP, decodes from a string.
+	if str, ok := src.(string); ok && dst.CanAddr() && reflect.PointerTo(dst.Type()).Implements(textUnmarshalerType) {
+		u := dst.Addr().Interface().(encoding.TextUnmarshaler)
+		if err := u.UnmarshalText([]byte(str)); err != nil {
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal string %q into %s: %w", str, dst.Type(), err)
+		}
+		return nil
+	}
+
+	// A number decodes into a type with a text form, such as a decimal type,
+	// from the text it was written as, so that 10.10 keeps its trailing zero.
+	if isNumber(src) && !isNumberKind(dst.Kind()) && dst.CanAddr() && reflect.PointerTo(dst.Type()).Implements(textUnmarshalerType) {
+		return d.setTextNumber(dst, src)
+	}
+
+	// A registered enum decodes from the name of one of its values.
+	if str, ok := src.(string); ok {
+		if v, ok, err := d.enumValue(dst.Type(), str); ok {
+			if err != nil {
+				return err
+			}
+			dst.Set(v)
+			return nil
+		}
+	}
+
 	s := reflect.ValueOf(src)
 
+	// An interface with methods needs a concrete type that implements it.
+	if dst.Kind() == reflect.Interface && dst.NumMethod() > 0 {
+		return d.setInterface(dst, src)
+	}
+
 	// If the destination is an interface, set it directly.
 	if dst.Kind() == reflect.Interface {
 		if s.IsValid() {
@@ -117,126 +775,533 @@ func setValueReflect(dst reflect.Value, src any) error {
 	// Handle type conversions.
 	switch dst.Kind() {
 	case reflect.Struct:
-		return setStruct(dst, src)
+		return d.setStruct(dst, src)
 	case reflect.Slice:
-		return setSlice(dst, src)
+		return d.setSlice(dst, src)
 	case reflect.Map:
-		return setMap(dst, src)
+		return d.setMap(dst, src)
 	case reflect.Ptr:
-		return setPtr(dst, src)
+		return d.setPtr(dst, src)
 	case reflect.String:
-		return setString(dst, src)
+		return d.setString(dst, src)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return setInt(dst, src)
+		return d.setInt(dst, src)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return setUint(dst, src)
+		return d.setUint(dst, src)
 	case reflect.Float32, reflect.Float64:
-		return setFloat(dst, src)
+		return d.setFloat(dst, src)
 	case reflect.Bool:
-		return setBool(dst, src)
+		return d.setBool(dst, src)
 	default:
-		return fmt.Errorf("cannot unmarshal %T into %s", src, dst.Type())
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into %s", src, dst.Type())
 	}
 }
 
-// setStruct unmarshals a map into a struct.
-func setStruct(dst reflect.Value, src any) error {
+// setStruct unmarshals a map into a struct, or a list into a struct with
+// positional fields.
+func (d *decodeState) setStruct(dst reflect.Value, src any) error {
+	if srcList, ok := src.([]any); ok && hasPositions(dst.Type()) {
+		return d.setStructFromList(dst, srcList)
+	}
 	srcMap, ok := src.(map[string]any)
 	if !ok {
-		return fmt.Errorf("cannot unmarshal %T into struct", src)
+		f, ok, err := scalarField(dst.Type())
+		if err != nil {
+			return err
+		}
+		if ok {
+			return d.setScalarField(dst, f, src)
+		}
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into struct", src)
 	}
 
-	structType := dst.Type()
-	for i := 0; i < structType.NumField(); i++ {
-		field := structType.Field(i)
-		fieldValue := dst.Field(i)
-
-		// Skip unexported fields.
-		if !fieldValue.CanSet() {
+	// Fields are set independently, so that one bad value doesn't prevent
+	// the others from being stored.
+	var firstErr error
+	var docField string
+	for _, f := range cachedTypeFields(dst.Type()) {
+		if f.comment {
+			if err := d.setComment(dst, f, srcMap); err != nil && firstErr == nil {
+				firstErr = err
+			}
 			continue
 		}
-
-		// Get the field name for mapping.
-		fieldName := getFieldName(field)
-		if fieldName == "-" {
+		if f.document {
+			if docField != "" {
+				return fmt.Errorf("struct %s has more than one document field: %s and %s", dst.Type(), docField, f.name)
+			}
+			docField = f.name
+			if err := d.setDocument(dst, f, srcMap); err != nil && firstErr == nil {
+				firstErr = err
+			}
 			continue
 		}
 
-		// Look for the value in the source map.
-		if srcValue, exists := srcMap[fieldName]; exists {
-			if err := setValueReflect(fieldValue, srcValue); err != nil {
-				return fmt.Errorf("error setting field %s: %w", field.Name, err)
+		// Look for the value in the source map, under the name or one of
+		// the former names of the field, or ask the defaulter.
+		key := f.name
+		srcValue, exists := srcMap[key]
+		for _, alias := range f.aliases {
+			if exists {
+				break
+			}
+			key = alias
+			srcValue, exists = srcMap[key]
+		}
+		if !exists {
+			if d.defaulter == nil {
+				continue
+			}
+			if srcValue, exists = d.defaulter(d.fieldPath(f.name)); !exists {
+				continue
+			}
+		}
+
+		if f.unit != "" {
+			var err error
+			if srcValue, err = fromUnit(f, srcValue, d.lenient); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("error setting field %s: %w", f.name, err)
+				}
+				continue
+			}
+		}
+
+		if f.timeFmt != "" {
+			var err error
+			if srcValue, err = fromTimeFormat(f, srcValue); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("error setting field %s: %w", f.name, err)
+				}
+				continue
 			}
 		}
+
+		fieldValue, err := fieldByIndexAlloc(dst, f.index)
+		if err == nil {
+			d.path = append(d.path, f.name)
+			d.literal = d.literalOf(srcMap, key)
+			err = d.setValueReflect(fieldValue, srcValue)
+			d.path = d.path[:len(d.path)-1]
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error setting field %s: %w", f.name, err)
+		}
+	}
+
+	return firstErr
+}
+
+// setScalarField unmarshals a value other than a dict into the field f of
+// a struct tagged scalar, which stands for the whole struct.
+func (d *decodeState) setScalarField(dst reflect.Value, f structField, src any) error {
+	fieldValue, err := fieldByIndexAlloc(dst, f.index)
+	if err == nil {
+		err = d.setValueReflect(fieldValue, src)
+	}
+	if err != nil {
+		return fmt.Errorf("error setting field %s: %w", f.name, err)
+	}
+	return nil
+}
+
+// hasPositions reports whether any field of struct type t is tagged pos=n.
+func hasPositions(t reflect.Type) bool {
+	for _, f := range cachedTypeFields(t) {
+		if f.hasPos {
+			return true
+		}
+	}
+	return false
+}
+
+// setStructFromList unmarshals a list into the positional fields of a
+// struct, each field taking the item at its position.
+func (d *decodeState) setStructFromList(dst reflect.Value, src []any) error {
+	var fields []structField
+	for _, f := range cachedTypeFields(dst.Type()) {
+		if f.hasPos {
+			fields = append(fields, f)
+		}
+	}
+	seen := make([]bool, len(fields))
+	for _, f := range fields {
+		if f.pos < 0 || f.pos >= len(fields) || seen[f.pos] {
+			return fmt.Errorf("struct %s positional fields must have distinct positions from 0 to %d", dst.Type(), len(fields)-1)
+		}
+		seen[f.pos] = true
+	}
+	if len(src) != len(fields) {
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal list of %d items into %s with %d positional fields", len(src), dst.Type(), len(fields))
+	}
+
+	var firstErr error
+	for _, f := range fields {
+		fieldValue, err := fieldByIndexAlloc(dst, f.index)
+		if err == nil {
+			d.path = append(d.path, f.name)
+			d.literal = d.literalOf(src, strconv.Itoa(f.pos))
+			err = d.setValueReflect(fieldValue, src[f.pos])
+			d.path = d.path[:len(d.path)-1]
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error setting field %s: %w", f.name, err)
+		}
+	}
+	return firstErr
+}
+
+// fromTimeFormat parses a string in the layout of the field f as a time.
+// Other values are returned as is, for the field to accept or reject.
+func fromTimeFormat(f structField, src any) (any, error) {
+	str, ok := src.(string)
+	if !ok {
+		return src, nil
+	}
+	t, err := time.Parse(f.timeFmt, str)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q with time format %q", str, f.timeFmt)
+	}
+	return t, nil
+}
+
+// typeErrorf returns a TypeError for the value src that cannot be stored in
+// a value of type t, at the current path.
+func (d *decodeState) typeErrorf(src any, t reflect.Type, format string, args ...any) error {
+	return &TypeError{Value: src, Type: t, Field: strings.Join(d.path, "."), err: fmt.Errorf(format, args...)}
+}
+
+// fieldPath returns the path of the field with the given name in the struct
+// being set, as passed to a defaulter.
+func (d *decodeState) fieldPath(name string) string {
+	if len(d.path) == 0 {
+		return name
+	}
+	return strings.Join(d.path, ".") + "." + name
+}
+
+// setComment sets a field tagged with the comment option to the comment block
+// that precedes the first entry of the source dict.
+func (d *decodeState) setComment(dst reflect.Value, f structField, srcMap map[string]any) error {
+	comment, ok := d.comments[reflect.ValueOf(srcMap).Pointer()]
+	if !ok {
+		return nil
+	}
+
+	fieldValue, err := fieldByIndexAlloc(dst, f.index)
+	if err != nil {
+		return fmt.Errorf("error setting field %s: %w", f.name, err)
+	}
+	if fieldValue.Kind() != reflect.String {
+		return fmt.Errorf("comment field %s must be a string, not %s", f.name, fieldValue.Type())
+	}
+	fieldValue.SetString(comment)
+	return nil
+}
+
+// setDocument sets a field tagged with the document option to the whole
+// source dict.
+func (d *decodeState) setDocument(dst reflect.Value, f structField, srcMap map[string]any) error {
+	fieldValue, err := fieldByIndexAlloc(dst, f.index)
+	if err != nil {
+		return fmt.Errorf("error setting field %s: %w", f.name, err)
+	}
+	if err := d.setValueReflect(fieldValue, srcMap); err != nil {
+		return fmt.Errorf("error setting field %s: %w", f.name, err)
 	}
+	return nil
+}
 
+// setInterface sets an interface with methods to a new value of the variant
+// selected by the union registered for its type.
+func (d *decodeState) setInterface(dst reflect.Value, src any) error {
+	u, ok := d.unions[dst.Type()]
+	if !ok {
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into interface %s without a registered union", src, dst.Type())
+	}
+	srcMap, ok := src.(map[string]any)
+	if !ok {
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into interface %s, expected a dict", src, dst.Type())
+	}
+
+	newVariant, err := u.variant(srcMap)
+	if err != nil {
+		return err
+	}
+	ptr := newVariant()
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("variant of %s must be a non-nil pointer, not %T", dst.Type(), ptr)
+	}
+	if err := d.setValueReflect(v.Elem(), src); err != nil {
+		return err
+	}
+
+	if !v.Type().AssignableTo(dst.Type()) {
+		return fmt.Errorf("variant %s does not implement %s", v.Type(), dst.Type())
+	}
+	dst.Set(v)
 	return nil
 }
 
-// getFieldName returns the field name to use for mapping, checking for struct tags.
-func getFieldName(field reflect.StructField) string {
-	name, _ := parseStructTag(field.Tag)
-	if name == "" {
-		return field.Name
+// fieldByIndexAlloc returns the nested field of v at the given index sequence,
+// allocating any nil embedded struct pointers along the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
 	}
-	return name
+	return v, nil
 }
 
 // setSlice unmarshals an array into a slice.
-func setSlice(dst reflect.Value, src any) error {
+func (d *decodeState) setSlice(dst reflect.Value, src any) error {
 	srcSlice, ok := src.([]any)
 	if !ok {
-		return fmt.Errorf("cannot unmarshal %T into slice", src)
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into slice", src)
 	}
 
 	sliceType := dst.Type()
 	newSlice := reflect.MakeSlice(sliceType, len(srcSlice), len(srcSlice))
+	offset := 0
+	if d.appendSlices && !dst.IsNil() {
+		offset = dst.Len()
+		newSlice = reflect.AppendSlice(dst, newSlice)
+	}
 
+	var firstErr error
 	for i, srcElem := range srcSlice {
-		elemValue := newSlice.Index(i)
-		if err := setValueReflect(elemValue, srcElem); err != nil {
-			return fmt.Errorf("error setting slice element %d: %w", i, err)
+		elemValue := newSlice.Index(offset + i)
+		index := strconv.Itoa(i)
+		d.path = append(d.path, index)
+		d.literal = d.literalOf(srcSlice, index)
+		err := d.setValueReflect(elemValue, srcElem)
+		d.path = d.path[:len(d.path)-1]
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error setting slice element %d: %w", i, err)
 		}
 	}
 
 	dst.Set(newSlice)
-	return nil
+	return firstErr
 }
 
 // setMap unmarshals a src map into a dest map.
-func setMap(dst reflect.Value, src any) error {
+func (d *decodeState) setMap(dst reflect.Value, src any) error {
 	srcMap, ok := src.(map[string]any)
 	if !ok {
-		return fmt.Errorf("cannot unmarshal %T into map", src)
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into map", src)
 	}
 
 	mapType := dst.Type()
 	keyType := mapType.Key()
 	valueType := mapType.Elem()
 
-	// Only support string keys for now (like JSON).
-	if keyType.Kind() != reflect.String {
-		return fmt.Errorf("maps with non-string keys are not supported")
+	if !isMapKeyType(keyType) {
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal into map with %s keys", keyType)
 	}
 
+	var firstErr error
 	newMap := reflect.MakeMap(mapType)
 	for key, srcValue := range srcMap {
-		keyValue := reflect.ValueOf(key)
+		keyValue, err := d.mapKey(keyType, key)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error setting map key %s: %w", key, err)
+			}
+			continue
+		}
 		valueValue := reflect.New(valueType).Elem()
 
-		if err := setValueReflect(valueValue, srcValue); err != nil {
-			return fmt.Errorf("error setting map value for key %s: %w", key, err)
+		d.path = append(d.path, key)
+		d.literal = d.literalOf(srcMap, key)
+		err = d.setValueReflect(valueValue, srcValue)
+		d.path = d.path[:len(d.path)-1]
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error setting map value for key %s: %w", key, err)
 		}
 
 		newMap.SetMapIndex(keyValue, valueValue)
 	}
 
 	dst.Set(newMap)
+	return firstErr
+}
+
+// isMapKeyType reports whether maps with keys of type t can be decoded and
+// encoded. As with encoding/json, keys can be strings, integers, or types
+// that implement encoding.TextUnmarshaler and encoding.TextMarshaler.
+func isMapKeyType(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) && t.Implements(textMarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// enumValue returns the value of the enum type t registered with
+// RegisterEnum under name, and whether t is a registered enum at all.
+func (d *decodeState) enumValue(t reflect.Type, name string) (reflect.Value, bool, error) {
+	names, ok := d.enums[t]
+	if !ok {
+		return reflect.Value{}, false, nil
+	}
+	v, ok := names[name]
+	if !ok {
+		return reflect.Value{}, true, d.typeErrorf(name, t, "unknown %s %q", t, name)
+	}
+	return v, true, nil
+}
+
+// mapKey converts the dict key s to a map key of type t.
+func (d *decodeState) mapKey(t reflect.Type, s string) (reflect.Value, error) {
+	// Keys of a registered enum are names, or numbers as for other integers.
+	if v, ok, err := d.enumValue(t, s); ok {
+		if err == nil {
+			return v, nil
+		}
+		if _, numErr := strconv.ParseInt(s, 10, 64); numErr != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	key := reflect.New(t)
+	if u, ok := key.Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return reflect.Value{}, err
+		}
+		return key.Elem(), nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		key.Elem().SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, d.typeErrorf(s, t, "cannot unmarshal key %q into %s", s, t)
+		}
+		key.Elem().SetInt(n)
+	default:
+		n, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, d.typeErrorf(s, t, "cannot unmarshal key %q into %s", s, t)
+		}
+		key.Elem().SetUint(n)
+	}
+	return key.Elem(), nil
+}
+
+// literalOf returns the text recorded by the parser for the value under key
+// in the parsed map or slice container, if any.
+func (d *decodeState) literalOf(container any, key string) string {
+	if d.literals == nil {
+		return ""
+	}
+	return d.literals[valueRef{reflect.ValueOf(container).Pointer(), key}]
+}
+
+// isNumber reports whether the parsed value src is a number.
+func isNumber(src any) bool {
+	switch src.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// isNumberKind reports whether k is an integer or float kind, whose values
+// decode from numbers directly even if their type has a text form.
+func isNumberKind(k reflect.Kind) bool {
+	return isIntegerKind(k) || k == reflect.Float32 || k == reflect.Float64
+}
+
+// setTextNumber unmarshals a number into a type implementing
+// encoding.TextUnmarshaler, passing the text of a float as written, without
+// underscores, and an integer in decimal.
+func (d *decodeState) setTextNumber(dst reflect.Value, src any) error {
+	if v, ok := src.(float64); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %g into %s", v, dst.Type())
+	}
+	text := d.numberText(src)
+
+	u := dst.Addr().Interface().(encoding.TextUnmarshaler)
+	if err := u.UnmarshalText([]byte(text)); err != nil {
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal number %s into %s: %w", text, dst.Type(), err)
+	}
+	return nil
+}
+
+// setNumber unmarshals a number into a Number, as the text it was written as.
+func (d *decodeState) setNumber(dst reflect.Value, src any) error {
+	if !isNumber(src) {
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into %s", src, dst.Type())
+	}
+	dst.SetString(d.numberText(src))
+	return nil
+}
+
+// numberText returns the text of the number src: the text of a float as the
+// parser recorded it, and the decimal form of any other number.
+func (d *decodeState) numberText(src any) string {
+	if d.literal != "" {
+		return d.literal
+	}
+	switch v := src.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatFloat(v)
+	}
+	return ""
+}
+
+// setRat unmarshals a number, or a string such as "3/4" or "0.25", into a
+// big.Rat. A float is converted from the shortest decimal that represents
+// it, so that a literal such as 0.1 becomes exactly 1/10.
+func (d *decodeState) setRat(dst reflect.Value, src any) error {
+	if !dst.CanAddr() {
+		return fmt.Errorf("cannot unmarshal into unaddressable %s", dst.Type())
+	}
+	r := dst.Addr().Interface().(*big.Rat)
+
+	var str string
+	switch v := src.(type) {
+	case int64:
+		r.SetInt64(v)
+		return nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal %g into %s", v, dst.Type())
+		}
+		str = strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		str = v
+	default:
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into %s", src, dst.Type())
+	}
+
+	if _, ok := r.SetString(str); !ok {
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal string %q into %s", str, dst.Type())
+	}
 	return nil
 }
 
 // setPtr unmarshals into a pointer.
-func setPtr(dst reflect.Value, src any) error {
+func (d *decodeState) setPtr(dst reflect.Value, src any) error {
 	if src == nil {
 		dst.Set(reflect.Zero(dst.Type()))
 		return nil
@@ -245,111 +1310,267 @@ func setPtr(dst reflect.Value, src any) error {
 	elemType := dst.Type().Elem()
 	newPtr := reflect.New(elemType)
 
-	if err := setValueReflect(newPtr.Elem(), src); err != nil {
-		return err
-	}
-
+	err := d.setValueReflect(newPtr.Elem(), src)
 	dst.Set(newPtr)
-	return nil
+	return err
 }
 
 // setString converts various types to string.
-func setString(dst reflect.Value, src any) error {
+func (d *decodeState) setString(dst reflect.Value, src any) error {
 	switch v := src.(type) {
 	case string:
 		dst.SetString(v)
 		return nil
-	default:
-		return fmt.Errorf("cannot unmarshal %T into string", src)
+	case int64:
+		if d.lenient {
+			dst.SetString(strconv.FormatInt(v, 10))
+			return nil
+		}
+	case float64:
+		if d.lenient {
+			dst.SetString(formatFloat(v))
+			return nil
+		}
+	case bool:
+		if d.lenient {
+			dst.SetString(strconv.FormatBool(v))
+			return nil
+		}
 	}
+	return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into string", src)
 }
 
 // setInt converts various numeric types to int.
-func setInt(dst reflect.Value, src any) error {
+func (d *decodeState) setInt(dst reflect.Value, src any) error {
 	switch v := src.(type) {
 	case int64:
 		if dst.OverflowInt(v) {
-			return fmt.Errorf("value %d overflows %s", v, dst.Type())
+			return d.typeErrorf(src, dst.Type(), "value %d overflows %s", v, dst.Type())
 		}
 		dst.SetInt(v)
 		return nil
 	case float64:
 		// Convert float to int if it's a whole number.
 		if v != math.Trunc(v) {
-			return fmt.Errorf("cannot unmarshal float %g into integer type", v)
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal float %g into integer type", v)
 		}
+		// Floats outside the range of int64 don't convert to it.
 		intVal := int64(v)
-		if dst.OverflowInt(intVal) {
-			return fmt.Errorf("value %g overflows %s", v, dst.Type())
+		if v < -(1<<63) || v >= 1<<63 || dst.OverflowInt(intVal) {
+			return d.typeErrorf(src, dst.Type(), "value %g overflows %s", v, dst.Type())
 		}
 		dst.SetInt(intVal)
 		return nil
+	case string:
+		if d.lenient {
+			n, ok := parseNumberLiteral(v)
+			if _, isInt := n.(int64); !ok || !isInt {
+				return d.typeErrorf(src, dst.Type(), "cannot unmarshal string %q into integer", v)
+			}
+			return d.setInt(dst, n)
+		}
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal string into integer")
 	default:
-		return fmt.Errorf("cannot unmarshal %T into integer", src)
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into integer", src)
 	}
 }
 
 // setUint converts various numeric types to uint.
-func setUint(dst reflect.Value, src any) error {
+func (d *decodeState) setUint(dst reflect.Value, src any) error {
 	switch v := src.(type) {
 	case int64:
 		if v < 0 {
-			return fmt.Errorf("cannot unmarshal negative value %d into unsigned integer", v)
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal negative value %d into unsigned integer", v)
 		}
 		uintVal := uint64(v)
 		if dst.OverflowUint(uintVal) {
-			return fmt.Errorf("value %d overflows %s", v, dst.Type())
+			return d.typeErrorf(src, dst.Type(), "value %d overflows %s", v, dst.Type())
 		}
 		dst.SetUint(uintVal)
 		return nil
 	case float64:
 		if v < 0 {
-			return fmt.Errorf("cannot unmarshal negative value %g into unsigned integer", v)
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal negative value %g into unsigned integer", v)
 		}
 		if v != math.Trunc(v) {
-			return fmt.Errorf("cannot unmarshal float %g into integer type", v)
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal float %g into integer type", v)
 		}
 		uintVal := uint64(v)
-		if dst.OverflowUint(uintVal) {
-			return fmt.Errorf("value %g overflows %s", v, dst.Type())
+		if v >= 1<<64 || dst.OverflowUint(uintVal) {
+			return d.typeErrorf(src, dst.Type(), "value %g overflows %s", v, dst.Type())
 		}
 		dst.SetUint(uintVal)
 		return nil
+	case string:
+		if d.lenient {
+			n, ok := parseNumberLiteral(v)
+			if i, isInt := n.(int64); !ok || !isInt || i < 0 {
+				return d.typeErrorf(src, dst.Type(), "cannot unmarshal string %q into unsigned integer", v)
+			}
+			return d.setUint(dst, n)
+		}
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal string into unsigned integer")
 	default:
-		return fmt.Errorf("cannot unmarshal %T into unsigned integer", src)
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into unsigned integer", src)
 	}
 }
 
 // setFloat converts various numeric types to float.
-func setFloat(dst reflect.Value, src any) error {
+func (d *decodeState) setFloat(dst reflect.Value, src any) error {
 	switch v := src.(type) {
 	case int64:
 		floatVal := float64(v)
 		if dst.OverflowFloat(floatVal) {
-			return fmt.Errorf("value %d overflows %s", v, dst.Type())
+			return d.typeErrorf(src, dst.Type(), "value %d overflows %s", v, dst.Type())
 		}
 		dst.SetFloat(floatVal)
 		return nil
 	case float64:
 		if dst.OverflowFloat(v) {
-			return fmt.Errorf("value %g overflows %s", v, dst.Type())
+			return d.typeErrorf(src, dst.Type(), "value %g overflows %s", v, dst.Type())
 		}
 		dst.SetFloat(v)
 		return nil
+	case string:
+		if d.specialFloatStrings {
+			switch v {
+			case "inf", "+inf":
+				dst.SetFloat(math.Inf(1))
+				return nil
+			case "-inf":
+				dst.SetFloat(math.Inf(-1))
+				return nil
+			case "nan":
+				dst.SetFloat(math.NaN())
+				return nil
+			}
+		}
+		if d.lenient {
+			n, ok := parseNumberLiteral(v)
+			if !ok {
+				return d.typeErrorf(src, dst.Type(), "cannot unmarshal string %q into float", v)
+			}
+			return d.setFloat(dst, n)
+		}
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal string %q into float", v)
 	default:
-		return fmt.Errorf("cannot unmarshal %T into float", src)
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into float", src)
 	}
 }
 
 // setBool converts various types to bool.
-func setBool(dst reflect.Value, src any) error {
+func (d *decodeState) setBool(dst reflect.Value, src any) error {
 	switch v := src.(type) {
 	case bool:
 		dst.SetBool(v)
 		return nil
-	default:
-		return fmt.Errorf("cannot unmarshal %T into bool", src)
+	case string:
+		if d.lenient {
+			switch v {
+			case "true", "1":
+				dst.SetBool(true)
+				return nil
+			case "false", "0":
+				dst.SetBool(false)
+				return nil
+			}
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal string %q into bool", v)
+		}
+		return d.typeErrorf(src, dst.Type(), "cannot unmarshal string into bool")
+	case int64:
+		if d.lenient {
+			if v == 0 || v == 1 {
+				dst.SetBool(v == 1)
+				return nil
+			}
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal number %d into bool", v)
+		}
+	case float64:
+		if d.lenient {
+			if v == 0 || v == 1 {
+				dst.SetBool(v == 1)
+				return nil
+			}
+			return d.typeErrorf(src, dst.Type(), "cannot unmarshal number %g into bool", v)
+		}
 	}
+	return d.typeErrorf(src, dst.Type(), "cannot unmarshal %T into bool", src)
+}
+
+// parseNumberLiteral parses s as a HUML number literal, by the rules of the
+// lexer: a decimal integer or float, or an integer with a 0x, 0o or 0b
+// prefix, with an optional sign and underscores only between digits. It
+// returns an int64 or a float64, as the parser would, and false if s is not
+// such a literal or is out of range. Words such as inf and nan are not
+// literals here.
+func parseNumberLiteral(s string) (any, bool) {
+	body, sign := s, ""
+	if len(body) > 0 && (body[0] == '+' || body[0] == '-') {
+		body, sign = body[1:], body[:1]
+	}
+	if sign == "+" {
+		sign = ""
+	}
+
+	base, isValidDigit := 10, isDigit
+	if len(body) > 2 && body[0] == '0' {
+		switch body[1] {
+		case 'x', 'X':
+			base, isValidDigit = 16, isHex
+		case 'o', 'O':
+			base, isValidDigit = 8, isOctal
+		case 'b', 'B':
+			base, isValidDigit = 2, isBinary
+		}
+		if base != 10 {
+			body = body[2:]
+		}
+	}
+
+	// Only decimal numbers have a fraction or an exponent.
+	mantissa, exp, hasExp := body, "", false
+	if base == 10 {
+		if i := strings.IndexAny(body, "eE"); i >= 0 {
+			mantissa, exp, hasExp = body[:i], body[i+1:], true
+			if len(exp) > 0 && (exp[0] == '+' || exp[0] == '-') {
+				exp = exp[1:]
+			}
+		}
+	}
+	intPart, frac, hasFrac := strings.Cut(mantissa, ".")
+	if !digitRun(intPart, isValidDigit) ||
+		(hasFrac && (base != 10 || (frac != "" && !digitRun(frac, isDigit)))) ||
+		(hasExp && !digitRun(exp, isDigit)) {
+		return nil, false
+	}
+
+	text := sign + strings.ReplaceAll(body, "_", "")
+	if !hasFrac && !hasExp {
+		n, err := strconv.ParseInt(text, base, 64)
+		return n, err == nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	return f, err == nil
+}
+
+// digitRun reports whether s is one or more digits, as told by isValidDigit,
+// with underscores only between them.
+func digitRun(s string, isValidDigit func(byte) bool) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' {
+			if i == 0 || i == len(s)-1 || !isValidDigit(s[i-1]) || !isValidDigit(s[i+1]) {
+				return false
+			}
+			continue
+		}
+		if !isValidDigit(s[i]) {
+			return false
+		}
+	}
+	return true
 }
 
 // Helper functions for character classification.
@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -110,6 +113,7 @@ func TestValues(t *testing.T) {
 	f("empty_list", "list:: []", map[string]any{"list": []any{}})
 	f("empty_dict", "dict:: {}", map[string]any{"dict": map[string]any{}})
 	f("inline_list", "list:: 1, 2, 3", map[string]any{"list": []any{int64(1), int64(2), int64(3)}})
+	f("inline_list_single", "list:: 1\nnext: 2", map[string]any{"list": []any{int64(1)}, "next": int64(2)})
 	f("root_list", "1, 2, 3, 5.6, +4, -2", []any{int64(1), int64(2), int64(3), float64(5.6), int64(4), int64(-2)})
 
 	// Test special numeric values
@@ -139,7 +143,8 @@ func TestSetValue(t *testing.T) {
 		t.Helper()
 		t.Run(name, func(t *testing.T) {
 			t.Helper()
-			err := setValue(dst, val)
+			var d decodeState
+			err := d.setValue(dst, val)
 			if errExpected {
 				if err == nil {
 					t.Error("expected error but got none")
@@ -341,6 +346,29 @@ func BenchmarkUnmarshalHUML(b *testing.B) {
 	}
 }
 
+// BenchmarkDecoderLarge decodes a large generated document through the
+// streaming Decoder, where the cost of reading lines dominates.
+func BenchmarkDecoderLarge(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("records::\n")
+	for i := range 20000 {
+		fmt.Fprintf(&sb, "  - ::\n    id: %d\n    name: \"record number %d\"\n    score: %d.5\n", i, i, i)
+		sb.WriteString("    tags:: \"alpha\", \"beta\", \"gamma\"\n    notes: \"\"\"\n      A longer line of free text for the record.\n    \"\"\"\n")
+	}
+	data := sb.String()
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for b.Loop() {
+		var result any
+		if err := NewDecoder(strings.NewReader(data)).Decode(&result); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
 func BenchmarkUnmarshalJSON(b *testing.B) {
 	data, err := os.ReadFile("tests/documents/mixed.json")
 	if err != nil {
@@ -486,3 +514,1732 @@ type errorReader struct {
 func (e *errorReader) Read(p []byte) (n int, err error) {
 	return 0, e.err
 }
+
+// TestDecoderSpecialFloatStrings tests decoding quoted special float values.
+func TestDecoderSpecialFloatStrings(t *testing.T) {
+	type config struct {
+		Ratio float64 `huml:"ratio"`
+	}
+
+	f := func(name, input string, check func(float64) bool) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var cfg config
+			dec := NewDecoder(strings.NewReader(input))
+			dec.SpecialFloatStrings()
+			if err := dec.Decode(&cfg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !check(cfg.Ratio) {
+				t.Errorf("unexpected value %v", cfg.Ratio)
+			}
+		})
+	}
+
+	f("inf", `ratio: "inf"`, func(v float64) bool { return math.IsInf(v, 1) })
+	f("plus_inf", `ratio: "+inf"`, func(v float64) bool { return math.IsInf(v, 1) })
+	f("minus_inf", `ratio: "-inf"`, func(v float64) bool { return math.IsInf(v, -1) })
+	f("nan", `ratio: "nan"`, math.IsNaN)
+
+	t.Run("disabled_by_default", func(t *testing.T) {
+		var cfg config
+		if err := Unmarshal([]byte(`ratio: "inf"`), &cfg); err == nil {
+			t.Error("expected error but got none")
+		}
+	})
+
+	t.Run("other_strings_rejected", func(t *testing.T) {
+		var cfg config
+		dec := NewDecoder(strings.NewReader(`ratio: "infinity"`))
+		dec.SpecialFloatStrings()
+		if err := dec.Decode(&cfg); err == nil {
+			t.Error("expected error but got none")
+		}
+	})
+}
+
+// TestDecoderIndentMultiple tests the indentation multiple check.
+func TestDecoderIndentMultiple(t *testing.T) {
+	f := func(name, input string, multiple int, errContains string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			dec := NewDecoder(strings.NewReader(input))
+			if multiple >= 0 {
+				dec.RequireIndentMultiple(multiple)
+			}
+			err := dec.Decode(&result)
+			if errContains == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error but got none")
+			}
+			if !strings.Contains(err.Error(), errContains) {
+				t.Errorf("expected error to contain %q, got: %v", errContains, err)
+			}
+		})
+	}
+
+	f("two_spaces", "a::\n  b::\n    c: 1", -1, "")
+	f("three_spaces", "a::\n   b: 1", -1, "line 2: indentation of 3 spaces is not a multiple of 2")
+	f("one_space", "a: 1\nb::\n c: 1", -1, "line 3: indentation of 1 space is not a multiple of 2")
+	f("list_item", "a::\n  - 1\n   - 2", -1, "line 3: indentation of 3 spaces is not a multiple of 2")
+	f("comment_ignored", "a::\n   # odd comment\n  b: 1", -1, "")
+	f("multiline_content_ignored", "a: \"\"\"\n     odd\n\"\"\"", -1, "")
+	f("multiple_of_one", "a::\n   b: 1", 1, "line 2: bad indent 3, expected 2")
+	f("disabled", "a::\n   b: 1", 0, "line 2: bad indent 3, expected 2")
+
+	// Each level is exactly 2 spaces deeper than its parent, so a document
+	// can't mix indentation widths, even in separate subtrees.
+	f("mixed_widths", "a::\n  b: 1\nc::\n    d: 2", -1, "line 4: bad indent 4, expected 2")
+	f("mixed_widths_disabled", "a::\n  b: 1\nc::\n    d: 2", 0, "line 4: bad indent 4, expected 2")
+	f("mixed_widths_list", "a::\n  - ::\n      b: 1", 0, "line 3: bad indent 6, expected 4")
+
+	// Multiples of more than 2 would reject every nested line.
+	const unsupported = "huml: indentation multiple of 4 is not supported, nested levels are 2 spaces deeper than their parent"
+	f("unsupported", "a: 1", 4, unsupported)
+	var result any
+	assert.EqualError(t, Unmarshal([]byte("a: 1"), &result, RequireIndentMultiple(4)), unsupported)
+	assert.EqualError(t, ValidateStream(strings.NewReader("a: 1"), nil, RequireIndentMultiple(4)), unsupported)
+}
+
+// TestUnclosedMultilineString tests that the error for an unclosed multiline
+// string refers to the line where the string was opened.
+func TestUnclosedMultilineString(t *testing.T) {
+	input := "a: 1\nb: 2\ntext: \"\"\"\n  line one\n  line two\n\n  line four\n"
+
+	var result any
+	err := Unmarshal([]byte(input), &result)
+	assert.EqualError(t, err, "line 3: multiline string is never closed")
+	var syntaxErr *SyntaxError
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, 3, syntaxErr.Line)
+		assert.Equal(t, 6, syntaxErr.Column)
+	}
+}
+
+// TestDecoderLenient tests the lenient scalar coercions.
+func TestDecoderLenient(t *testing.T) {
+	type config struct {
+		Port    int     `huml:"port"`
+		Workers uint8   `huml:"workers"`
+		Ratio   float64 `huml:"ratio"`
+		Enabled bool    `huml:"enabled"`
+		Version string  `huml:"version"`
+	}
+
+	f := func(name, input string, lenient bool, expected config, errExpected bool) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var cfg config
+			dec := NewDecoder(strings.NewReader(input))
+			if lenient {
+				dec.Lenient()
+			}
+			err := dec.Decode(&cfg)
+			if errExpected {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, expected, cfg)
+		})
+	}
+
+	f("string_to_int", `port: "8080"`, true, config{Port: 8080}, false)
+	f("string_to_int_hex", `port: "0x1F"`, true, config{Port: 31}, false)
+	f("string_to_int_leading_zero", `port: "010"`, true, config{Port: 10}, false)
+	f("string_to_int_underscores", `port: "8_080"`, true, config{Port: 8080}, false)
+	f("string_to_int_bad_underscore", `port: "_8080"`, true, config{}, true)
+	f("string_to_int_float", `port: "80.0"`, true, config{}, true)
+	f("string_to_int_invalid", `port: "eighty"`, true, config{}, true)
+	f("string_to_int_strict", `port: "8080"`, false, config{}, true)
+	f("string_to_uint", `workers: "4"`, true, config{Workers: 4}, false)
+	f("string_to_uint_overflow", `workers: "300"`, true, config{}, true)
+	f("string_to_uint_leading_zero", `workers: "010"`, true, config{Workers: 10}, false)
+	f("string_to_uint_negative", `workers: "-1"`, true, config{}, true)
+	f("string_to_float", `ratio: "0.75"`, true, config{Ratio: 0.75}, false)
+	f("string_to_float_exponent", `ratio: "1_5e-1"`, true, config{Ratio: 1.5}, false)
+	f("string_to_float_int", `ratio: "0x10"`, true, config{Ratio: 16}, false)
+	f("string_to_float_inf", `ratio: "inf"`, true, config{}, true)
+	f("string_to_float_infinity", `ratio: "Infinity"`, true, config{}, true)
+	f("string_to_float_nan", `ratio: "NaN"`, true, config{}, true)
+	f("string_to_float_hex", `ratio: "0x1p4"`, true, config{}, true)
+	f("string_to_bool_true", `enabled: "true"`, true, config{Enabled: true}, false)
+	f("string_to_bool_one", `enabled: "1"`, true, config{Enabled: true}, false)
+	f("string_to_bool_zero", `enabled: "0"`, true, config{Enabled: false}, false)
+	f("string_to_bool_invalid", `enabled: "yes"`, true, config{}, true)
+	f("string_to_bool_strict", `enabled: "true"`, false, config{}, true)
+	f("int_to_bool_one", `enabled: 1`, true, config{Enabled: true}, false)
+	f("int_to_bool_zero", `enabled: 0`, true, config{Enabled: false}, false)
+	f("int_to_bool_two", `enabled: 2`, true, config{}, true)
+	f("int_to_bool_negative", `enabled: -1`, true, config{}, true)
+	f("float_to_bool_one", `enabled: 1.0`, true, config{Enabled: true}, false)
+	f("float_to_bool_half", `enabled: 0.5`, true, config{}, true)
+	f("int_to_bool_strict", `enabled: 1`, false, config{}, true)
+	f("float_to_string", `version: 1.5`, true, config{Version: "1.5"}, false)
+	f("int_to_string", `version: 2`, true, config{Version: "2"}, false)
+	f("nan_to_string", `version: nan`, true, config{Version: "nan"}, false)
+	f("inf_to_string", `version: +inf`, true, config{Version: "inf"}, false)
+	f("negative_inf_to_string", `version: -inf`, true, config{Version: "-inf"}, false)
+	f("int_to_string_strict", `version: 2`, false, config{}, true)
+}
+
+// TestFloatUnderscores tests where underscores are allowed in floats.
+func TestFloatUnderscores(t *testing.T) {
+	f := func(input string, expected float64, errExpected bool) {
+		t.Helper()
+		t.Run(input, func(t *testing.T) {
+			t.Helper()
+			var result map[string]any
+			err := Unmarshal([]byte("v: "+input), &result)
+			if errExpected {
+				if err == nil {
+					t.Errorf("expected error but got none, value: %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, expected, result["v"])
+		})
+	}
+
+	f("1_000.5", 1000.5, false)
+	f("1.0_5", 1.05, false)
+	f("1_0.2_5", 10.25, false)
+	f("1e1_0", 1e10, false)
+	f("1.5e-1_0", 1.5e-10, false)
+	f("-1_000.25", -1000.25, false)
+	f("1_.5", 0, true)
+	f("1._5", 0, true)
+	f("1.5_", 0, true)
+	f("1__0.5", 0, true)
+	f("1_e5", 0, true)
+	f("1e_5", 0, true)
+	f("1e-_5", 0, true)
+}
+
+// TestLexerQuotedKeys tests that the lexer keeps track of whether a key was
+// quoted in the source, even when it would also be valid as a bare key.
+func TestLexerQuotedKeys(t *testing.T) {
+	l := newLexer(strings.NewReader("a_b: 1\n\"a_b\": 2\n\"a.b\":: x: 1"))
+
+	var keys []Token
+	for {
+		tk, err := l.next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tk.Type == TokenEOF {
+			break
+		}
+		if tk.Type == TokenKey || tk.Type == TokenQuotedKey {
+			keys = append(keys, tk)
+		}
+	}
+
+	if assert.Len(t, keys, 4) {
+		assert.Equal(t, Token{Type: TokenKey, Value: "a_b", Line: 1}, keys[0])
+		assert.Equal(t, Token{Type: TokenQuotedKey, Value: "a_b", Line: 2}, keys[1])
+		assert.Equal(t, Token{Type: TokenQuotedKey, Value: "a.b", Line: 3}, keys[2])
+		assert.Equal(t, Token{Type: TokenKey, Value: "x", Line: 3, Column: 8}, keys[3])
+	}
+}
+
+func TestUnicodeEscapes(t *testing.T) {
+	f := func(name, doc string, exp any, expErr bool) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result map[string]any
+			err := Unmarshal([]byte(doc), &result)
+			if expErr {
+				assert.Error(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result["v"])
+		})
+	}
+
+	f("control", `v: "\u0007"`, "\a", false)
+	f("bmp", `v: "caf\u00e9 \u20AC"`, "café €", false)
+	f("surrogate_pair", `v: "\ud83d\ude00"`, "😀", false)
+	f("lone_surrogate", `v: "\ud83d!"`, "\ufffd!", false)
+	f("short", `v: "\u12"`, nil, true)
+	f("invalid_hex", `v: "\u12zz"`, nil, true)
+}
+
+func TestDecoderMaxDepth(t *testing.T) {
+	// nested builds a document of n dicts, each nested in the one above.
+	nested := func(n int) string {
+		var b strings.Builder
+		for i := range n {
+			b.WriteString(strings.Repeat("  ", i) + "a::\n")
+		}
+		b.WriteString(strings.Repeat("  ", n) + "a: 1\n")
+		return b.String()
+	}
+
+	f := func(name, doc string, maxDepth int, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			dec := NewDecoder(strings.NewReader(doc))
+			if maxDepth >= 0 {
+				dec.MaxDepth(maxDepth)
+			}
+			var result any
+			err := dec.Decode(&result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	f("default_pathological", nested(5000), -1, "line 1001: maximum nesting depth of 1000 exceeded")
+	f("default_ok", nested(1000), -1, "")
+	f("custom_ok", nested(3), 3, "")
+	f("custom_exceeded", nested(4), 3, "line 4: maximum nesting depth of 3 exceeded")
+	f("list_exceeded", "- ::\n  - ::\n    - 1", 1, "line 2: maximum nesting depth of 1 exceeded")
+	f("unlimited", nested(1500), 0, "")
+}
+
+func TestRootMultilineString(t *testing.T) {
+	f := func(name, doc string, exp any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result)
+		})
+	}
+
+	f("simple", "\"\"\"\n  hello\n  world\n\"\"\"", "hello\nworld", "")
+	f("trailing_newline", "\"\"\"\n  hello\n\"\"\"\n", "hello", "")
+	f("extra_indent", "\"\"\"\n  a\n    b\n\"\"\"\n", "a\n  b", "")
+	f("trailing_comment", "\"\"\"\n  a\n\"\"\"\n# done\n", "a", "")
+	f("trailing_content", "\"\"\"\n  a\n\"\"\"\nx: 1\n", nil, "line 4: unexpected content after root scalar value")
+	f("unclosed", "\"\"\"\n  a\n", nil, "line 1: multiline string is never closed")
+
+	// A root multi-line string must survive a round trip through Marshal.
+	str := "first\n  indented\nlast"
+	marshalled, err := Marshal(str)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+	assert.Equal(t, "%HUML v0.2.0\n\"\"\"\n  first\n    indented\n  last\n\"\"\"\n", string(marshalled))
+	f("round_trip", string(marshalled), str, "")
+}
+
+func TestDecoderAllowTrailingSpaces(t *testing.T) {
+	f := func(name, doc string, exp any, expStrictErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := NewDecoder(strings.NewReader(doc)).Decode(&result)
+			assert.EqualError(t, err, expStrictErr)
+
+			dec := NewDecoder(strings.NewReader(doc))
+			dec.AllowTrailingSpaces()
+			result = nil
+			if err := dec.Decode(&result); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result)
+		})
+	}
+
+	f("scalar", "a: 1  \nb: 2", map[string]any{"a": int64(1), "b": int64(2)},
+		"line 1: trailing spaces are not allowed")
+	f("vector", "a::   \n  - 1 \n  - 2", map[string]any{"a": []any{int64(1), int64(2)}},
+		"line 1: trailing spaces are not allowed")
+	f("comment", "# note  \na: 1", map[string]any{"a": int64(1)},
+		"line 1: trailing spaces are not allowed")
+	f("blank_line", "a: 1\n    \nb: 2", map[string]any{"a": int64(1), "b": int64(2)},
+		"line 2: trailing spaces are not allowed")
+	f("inline", "a:: 1, 2 ", map[string]any{"a": []any{int64(1), int64(2)}},
+		"line 1: trailing spaces are not allowed")
+
+	// Trailing spaces in multi-line string content are always kept, but the
+	// delimiter lines may be padded.
+	f("multiline", "a: \"\"\"  \n  x  \n  y\n\"\"\"  ", map[string]any{"a": "x  \ny"},
+		"line 1: trailing spaces are not allowed")
+
+	t.Run("multiline_strict_content", func(t *testing.T) {
+		var result any
+		if err := Unmarshal([]byte("a: \"\"\"\n  x  \n\"\"\""), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, map[string]any{"a": "x  "}, result)
+	})
+}
+
+func TestBasePrefixFloats(t *testing.T) {
+	f := func(input, expErr string) {
+		t.Helper()
+		t.Run(input, func(t *testing.T) {
+			t.Helper()
+			var result map[string]any
+			err := Unmarshal([]byte("v: "+input), &result)
+			assert.EqualError(t, err, expErr)
+		})
+	}
+
+	f("0x1.5", "line 1: invalid number '0x1.5', hexadecimal, octal and binary floats are not supported")
+	f("0b1.0", "line 1: invalid number '0b1.0', hexadecimal, octal and binary floats are not supported")
+	f("0o1.2", "line 1: invalid number '0o1.2', hexadecimal, octal and binary floats are not supported")
+	f("-0x1p3", "line 1: invalid number '-0x1p3', hexadecimal, octal and binary floats are not supported")
+	f("0b1e5", "line 1: invalid number '0b1e5', hexadecimal, octal and binary floats are not supported")
+
+	// The exponent letter of a decimal float is a digit in hex.
+	var result map[string]any
+	if err := Unmarshal([]byte("v: 0x1e5"), &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, int64(0x1e5), result["v"])
+}
+
+func TestUnmarshalOptions(t *testing.T) {
+	type Config struct {
+		Port  int     `huml:"port"`
+		Ratio float64 `huml:"ratio"`
+	}
+
+	f := func(name, doc string, opts []Option, exp Config, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result Config
+			err := Unmarshal([]byte(doc), &result, opts...)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result)
+
+			// NewDecoder takes the same options.
+			result = Config{}
+			if err := NewDecoder(strings.NewReader(doc), opts...).Decode(&result); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result)
+		})
+	}
+
+	doc := "port: \"8080\"\nratio: \"inf\"  "
+	f("none", doc, nil, Config{}, "line 2: trailing spaces are not allowed")
+	f("trailing", doc, []Option{AllowTrailingSpaces()}, Config{},
+		"error setting field port: cannot unmarshal string into integer")
+	f("all", doc, []Option{AllowTrailingSpaces(), Lenient(), SpecialFloatStrings()},
+		Config{Port: 8080, Ratio: math.Inf(1)}, "")
+	f("depth", "a::\n  b::\n    c: 1", []Option{MaxDepth(1)}, Config{},
+		"line 2: maximum nesting depth of 1 exceeded")
+	f("indent", "port::\n   a: 1", []Option{RequireIndentMultiple(0)}, Config{}, "line 2: bad indent 3, expected 2")
+}
+
+func TestMultilineFence(t *testing.T) {
+	f := func(name, doc string, exp any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result map[string]any
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result["v"])
+		})
+	}
+
+	f("long_fence", "v: \"\"\"\"\n  \"\"\"\n  x\n\"\"\"\"", "\"\"\"\nx", "")
+	f("longer_closer", "v: \"\"\"\n  x\n\"\"\"\"", nil, "line 3: invalid content after multiline string closing delimiter")
+	f("short_closer", "v: \"\"\"\"\n  x\n\"\"\"\n", nil, "line 1: multiline string is never closed")
+}
+
+func TestDecoderStrictBlankLines(t *testing.T) {
+	f := func(name, doc string, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			// Blank lines are always fine by default.
+			var result any
+			assert.NoError(t, Unmarshal([]byte(doc), &result))
+
+			err := Unmarshal([]byte(doc), &result, StrictBlankLines())
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	f("none", "a: 1\nb: 2\n", "")
+	f("single", "a: 1\n\n# b\nb: 2\n", "")
+	f("multiline_content", "a: \"\"\"\n  x\n\n\n  y\n\"\"\"\nb: 2", "")
+	f("leading", "\na: 1\n", "line 1: blank lines are not allowed at the start of the document")
+	f("trailing", "a: 1\n\n", "line 2: blank lines are not allowed at the end of the document")
+	f("final_newline", "a: 1\n", "")
+	f("double", "a: 1\n\n\nb: 2\n", "line 3: consecutive blank lines are not allowed")
+	f("double_nested", "a::\n  b: 1\n\n\n  c: 2\n", "line 4: consecutive blank lines are not allowed")
+}
+
+func TestNumberSuffix(t *testing.T) {
+	f := func(input, expErr string) {
+		t.Helper()
+		t.Run(input, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(input), &result)
+			assert.EqualError(t, err, expErr)
+		})
+	}
+
+	const units = "values with units such as durations must be quoted strings"
+	f("v: 100ms", "line 1: unexpected 'ms' after number '100', "+units)
+	f("v: 5s", "line 1: unexpected 's' after number '5', "+units)
+	f("v: 10GB", "line 1: unexpected 'GB' after number '10', "+units)
+	f("v: -1.5h", "line 1: unexpected 'h' after number '-1.5', "+units)
+	f("v: 2em", "line 1: unexpected 'em' after number '2', "+units)
+	f("v: 1e3x", "line 1: unexpected 'x' after number '1e3', "+units)
+	f("v: 0x1Fg", "line 1: unexpected 'g' after number '0x1F', "+units)
+	f("v:: 1, 2ms", "line 1: unexpected 'ms' after number '2', "+units)
+	f("- 5kb", "line 1: unexpected 'kb' after number '5', "+units)
+
+	// Other content after a number is still reported as before.
+	f("v: 5 s", "line 1: unexpected content at end of line")
+}
+
+func TestDecoderAllowedRootKeys(t *testing.T) {
+	f := func(name, doc string, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result map[string]any
+			err := Unmarshal([]byte(doc), &result, AllowedRootKeys("name", "server"))
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	f("allowed", "name: \"a\"\nserver::\n  port: 1\n", "")
+	f("subset", "name: \"a\"", "")
+	f("nested_keys_unchecked", "server::\n  other: 1\n", "")
+	f("unknown", "name: \"a\"\nnmae: \"b\"\n", "line 2: unknown root key 'nmae'")
+	f("unknown_vector", "server::\n  port: 1\nclient::\n  port: 2\n", "line 3: unknown root key 'client'")
+	f("inline", "name: \"a\", zz: 1, other: 2", "line 1: unknown root key 'zz'")
+	f("not_a_dict", "- 1\n- 2", "cannot unmarshal []interface {} into map")
+
+	t.Run("token", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader("name: \"a\"\nextra: 1\n"), AllowedRootKeys("name"))
+		var err error
+		for err == nil {
+			_, err = dec.Token()
+		}
+		assert.EqualError(t, err, "line 2: unknown root key 'extra'")
+	})
+}
+
+func TestSignedNaN(t *testing.T) {
+	f := func(name, doc, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			assert.EqualError(t, Unmarshal([]byte(doc), &result), expErr)
+
+			dec := NewDecoder(strings.NewReader(doc))
+			var err error
+			for err == nil {
+				_, err = dec.Token()
+			}
+			assert.EqualError(t, err, expErr)
+		})
+	}
+
+	f("plus", "v: +nan", "line 1: signed nan is not permitted; use 'nan'")
+	f("minus", "v: -nan", "line 1: signed nan is not permitted; use 'nan'")
+	f("list_item", "- 1\n- -nan", "line 2: signed nan is not permitted; use 'nan'")
+	f("inline", "v:: 1, +nan", "line 1: signed nan is not permitted; use 'nan'")
+	f("comment", "v: -nan # not a number", "line 1: signed nan is not permitted; use 'nan'")
+	f("longer_word", "v: -nanx", "line 1: invalid char after '-'")
+
+	var syntaxErr *SyntaxError
+	err := Unmarshal([]byte("v: -nan"), new(any))
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, 3, syntaxErr.Column)
+	}
+
+	var result map[string]any
+	assert.NoError(t, Unmarshal([]byte("v: nan"), &result))
+	assert.True(t, math.IsNaN(result["v"].(float64)))
+}
+
+func TestEqualValues(t *testing.T) {
+	f := func(name string, a, b any, exp bool) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			assert.Equal(t, exp, EqualValues(a, b))
+			assert.Equal(t, exp, EqualValues(b, a))
+		})
+	}
+
+	nan := math.NaN()
+	f("nan", nan, nan, true)
+	f("nan_number", nan, 1.0, false)
+	f("inf", math.Inf(1), math.Inf(1), true)
+	f("inf_sign", math.Inf(1), math.Inf(-1), false)
+	f("map", map[string]any{"a": nan, "b": []any{nan, int64(1)}}, map[string]any{"a": nan, "b": []any{nan, int64(1)}}, true)
+	f("map_extra_key", map[string]any{"a": nan}, map[string]any{"a": nan, "b": nil}, false)
+	f("map_missing_key", map[string]any{"a": nil}, map[string]any{"b": nil}, false)
+	f("list_length", []any{nan}, []any{nan, nan}, false)
+	f("int_float", int64(1), 1.0, false)
+	f("nil_empty", []any(nil), []any{}, false)
+	f("strings", "a", "a", true)
+
+	// A document with special floats survives a round trip.
+	doc := map[string]any{"nan": nan, "inf": math.Inf(1), "ninf": math.Inf(-1), "list": []any{nan, 1.5}}
+	marshalled, err := Marshal(doc)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+	var result any
+	if err := Unmarshal(marshalled, &result); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v", err)
+	}
+	assert.True(t, EqualValues(doc, result))
+}
+
+// TestRootTypeDetection tests that commas and colons within strings and
+// comments don't affect how the type of the root is detected.
+func TestRootTypeDetection(t *testing.T) {
+	f := func(name, doc string, exp any) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			if err := Unmarshal([]byte(doc), &result); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result)
+		})
+	}
+
+	f("list_with_colons", `"a:b", "c:d"`, []any{"a:b", "c:d"})
+	f("list_with_double_colons", `"a::b", "c: d"`, []any{"a::b", "c: d"})
+	f("scalar_with_comma", `"a, b"`, "a, b")
+	f("scalar_with_comment", "\"a\" # b, c", "a")
+	f("dict_comma_in_value", "k: \"a, b\"\nj: 1", map[string]any{"k": "a, b", "j": int64(1)})
+	f("dict_comma_in_quoted_key", "\"k, l\": 1\nj: 2", map[string]any{"k, l": int64(1), "j": int64(2)})
+	f("dict_comma_in_comment", "k: 1 # a, b\nj: 2", map[string]any{"k": int64(1), "j": int64(2)})
+	f("dict_escaped_quote", "k: \"a\\\", b\"\nj: 2", map[string]any{"k": "a\", b", "j": int64(2)})
+	f("inline_dict", `k: "a, b", j: "c:d"`, map[string]any{"k": "a, b", "j": "c:d"})
+}
+
+// TestInlineVectorPunctuation tests that commas and colons within strings
+// don't affect the parsing of inline lists and dicts.
+func TestInlineVectorPunctuation(t *testing.T) {
+	f := func(name, doc string, exp any) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			if err := Unmarshal([]byte(doc), &result); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result)
+		})
+	}
+
+	f("list", `key:: "a, b", "c:d"`, map[string]any{"key": []any{"a, b", "c:d"}})
+	f("list_first_colon", `key:: "x:y", "z"`, map[string]any{"key": []any{"x:y", "z"}})
+	f("list_escaped_quote", `key:: "a\":b", "c, d"`, map[string]any{"key": []any{"a\":b", "c, d"}})
+	f("list_item", `- :: "a, b", "c:: d"`, []any{[]any{"a, b", "c:: d"}})
+	f("dict", `key:: "a:b": 1, "c,d": "e:f, g"`, map[string]any{"key": map[string]any{"a:b": int64(1), "c,d": "e:f, g"}})
+	f("quoted_key_vector", `"a:b":: 1, 2`, map[string]any{"a:b": []any{int64(1), int64(2)}})
+	f("root_dict", `"a, b": "c:d", e: 1`, map[string]any{"a, b": "c:d", "e": int64(1)})
+}
+
+func TestDecoderDefaultEmptyVector(t *testing.T) {
+	f := func(name, doc string, mode EmptyVector, exp any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, DefaultEmptyVector(mode))
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result)
+		})
+	}
+
+	doc := "a::\nb:: # nothing here\nc::\n  d::\ne: 1"
+	f("error", doc, EmptyVectorError, nil, "line 2: ambiguous empty vector after '::'. Use [] or {}.")
+	f("dict", doc, EmptyVectorDict, map[string]any{
+		"a": map[string]any{},
+		"b": map[string]any{},
+		"c": map[string]any{"d": map[string]any{}},
+		"e": int64(1),
+	}, "")
+	f("list", doc, EmptyVectorList, map[string]any{
+		"a": []any{},
+		"b": []any{},
+		"c": map[string]any{"d": []any{}},
+		"e": int64(1),
+	}, "")
+	f("list_item", "- ::\n- 1", EmptyVectorList, []any{[]any{}, int64(1)}, "")
+	f("at_eof", "a::", EmptyVectorDict, map[string]any{"a": map[string]any{}}, "")
+
+	t.Run("token", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader("a::\nb: 1"), DefaultEmptyVector(EmptyVectorList))
+		var evs []Event
+		for {
+			ev, err := dec.Token()
+			if err != nil {
+				break
+			}
+			evs = append(evs, ev)
+		}
+		assert.Equal(t, []Event{
+			{Kind: EventDictStart},
+			{Kind: EventKey, Key: "a"}, {Kind: EventListStart}, {Kind: EventListEnd},
+			{Kind: EventKey, Key: "b"}, {Kind: EventValue, Value: int64(1)},
+			{Kind: EventDictEnd},
+		}, evs)
+	})
+}
+
+// TestLongLines tests lines longer than the reader's buffer, with and
+// without a final newline.
+func TestLongLines(t *testing.T) {
+	long := strings.Repeat("abcdefgh", 2000)
+	for _, end := range []string{"", "\n"} {
+		doc := "a: \"" + long + "\"\nb: \"\"\"\n  " + long + "\n\"\"\"\nc: \"" + long + "\"" + end
+		var result map[string]any
+		if err := Unmarshal([]byte(doc), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, map[string]any{"a": long, "b": long, "c": long}, result)
+	}
+
+	// Trailing spaces are found at the end of a long line.
+	var result any
+	err := Unmarshal([]byte("a: \""+long+"\" \nb: 1"), &result)
+	assert.EqualError(t, err, "line 1: trailing spaces are not allowed")
+}
+
+// TestDecodeChunkedReader tests decoding from readers that return the input
+// in small pieces, splitting lines, strings and numbers.
+func TestDecodeChunkedReader(t *testing.T) {
+	doc := "# config\n" +
+		"name: \"" + strings.Repeat("x", 5000) + "\"\n" +
+		"ports:: 80, 443\n" +
+		"db::\n" +
+		"  host: \"localhost\"\n" +
+		"  notes: \"\"\"\n" +
+		"    one\n" +
+		"    two\n" +
+		"  \"\"\"\n" +
+		"items::\n" +
+		"  - 1.5\n" +
+		"  - true\n" +
+		"  - null"
+
+	var expected any
+	err := Unmarshal([]byte(doc), &expected)
+	assert.NoError(t, err)
+
+	f := func(name string, r io.Reader) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := NewDecoder(r).Decode(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("one byte", iotest.OneByteReader(strings.NewReader(doc)))
+	f("half", iotest.HalfReader(strings.NewReader(doc)))
+	f("data with EOF", iotest.DataErrReader(strings.NewReader(doc)))
+	f("newline terminated", iotest.OneByteReader(strings.NewReader(doc+"\n")))
+
+	// Read errors are returned.
+	var result any
+	err = NewDecoder(iotest.ErrReader(errors.New("boom"))).Decode(&result)
+	assert.EqualError(t, err, "boom")
+}
+
+func TestDecoderDisallowInlineVectors(t *testing.T) {
+	f := func(name, doc string, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, DisallowInlineVectors())
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	f("block_list", "ports::\n  - 80\n  - 443\n", "")
+	f("block_dict", "point::\n  x: 1\n  y: 2\n", "")
+	f("empty_list", "ports:: []\n", "")
+	f("empty_dict", "point:: {}\n", "")
+	f("root_empty", "[]", "")
+	f("list_item_empty", "- :: []\n- :: {}\n", "")
+	f("inline_list", "name: \"a\"\nports:: 80, 443\n", "line 2: inline list is not allowed, use the multi-line form")
+	f("inline_dict", "point:: x: 1, y: 2\n", "line 1: inline dict is not allowed, use the multi-line form")
+	f("list_item", "- :: 1, 2\n", "line 1: inline list is not allowed, use the multi-line form")
+	f("nested", "a::\n  b:: \"x\"\n", "line 2: inline list is not allowed, use the multi-line form")
+	f("root_list", "1, 2, 3", "line 1: inline list is not allowed, use the multi-line form")
+	f("root_dict", "a: 1, b: 2", "line 1: inline dict is not allowed, use the multi-line form")
+
+	t.Run("token", func(t *testing.T) {
+		dec := NewDecoder(strings.NewReader("a: 1\nb:: 1, 2\n"), DisallowInlineVectors())
+		var err error
+		for err == nil {
+			_, err = dec.Token()
+		}
+		assert.EqualError(t, err, "line 2: inline list is not allowed, use the multi-line form")
+	})
+}
+
+func TestDirectiveOnlyDocument(t *testing.T) {
+	f := func(name, doc, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any = "previous"
+			assert.EqualError(t, Unmarshal([]byte(doc), &result), expErr)
+			assert.Equal(t, "previous", result)
+		})
+	}
+
+	const msg = "document contains only a version directive and no content"
+	f("no_newline", "%HUML v0.2.0", msg)
+	f("newline", "%HUML v0.2.0\n", msg)
+	f("no_version", "%HUML\n", msg)
+	f("comments", "%HUML v0.1.0 # header\n\n# nothing here\n", msg)
+	f("comments_only", "# nothing here\n", "empty document is undefined")
+	f("blank", "\n\n", "empty document is undefined")
+
+	// Content after the directive is a document as usual.
+	var result any
+	assert.NoError(t, Unmarshal([]byte("%HUML v0.2.0\n{}\n"), &result))
+	assert.Equal(t, map[string]any{}, result)
+}
+
+func TestEmptyVectorComments(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, expected, result)
+			}
+
+			// Token reads the same document.
+			dec := NewDecoder(strings.NewReader(doc))
+			for err = nil; err == nil; {
+				_, err = dec.Token()
+			}
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.Equal(t, io.EOF, err)
+			}
+		})
+	}
+
+	f("list", "key:: [] # note", map[string]any{"key": []any{}}, "")
+	f("dict", "key:: {} # note", map[string]any{"key": map[string]any{}}, "")
+	f("root_list", "[] # note", []any{}, "")
+	f("root_dict", "{} # note", map[string]any{}, "")
+	f("list_items", "- :: [] # a\n- :: {} # b", []any{[]any{}, map[string]any{}}, "")
+	f("nested", "a::\n  b:: [] # note\n  c:: {} # note", map[string]any{"a": map[string]any{"b": []any{}, "c": map[string]any{}}}, "")
+	f("no_space_in_comment", "key:: [] #note", nil, "line 1: comment hash '#' must be followed by a space")
+	f("trailing_content", "key:: [] x", nil, "line 1: unexpected content at end of line")
+}
+
+func TestDecoderAllowBareStrings(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, AllowBareStrings())
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("word", "key: hello", map[string]any{"key": "hello"}, "")
+	f("punctuation", "key: snake_case-2", map[string]any{"key": "snake_case-2"}, "")
+	f("keywords", "a: true\nb: null\nc: inf\nd: no", map[string]any{"a": true, "b": nil, "c": math.Inf(1), "d": "no"}, "")
+	f("comment", "key: hello # note", map[string]any{"key": "hello"}, "")
+	f("list", "- one\n- \"two\"\n- 3", []any{"one", "two", int64(3)}, "")
+	f("inline_list", "key:: red, green, blue", map[string]any{"key": []any{"red", "green", "blue"}}, "")
+	f("inline_dict", "key:: a: x, b: y", map[string]any{"key": map[string]any{"a": "x", "b": "y"}}, "")
+	f("root", "hello", "hello", "")
+	f("spaces", "key: hello world", nil, "line 1: unexpected content at end of line")
+	f("trailing_spaces", "key: hello  ", nil, "line 1: trailing spaces are not allowed")
+
+	// Without the option, bare words are rejected.
+	var result any
+	err := Unmarshal([]byte("key: hello"), &result)
+	assert.EqualError(t, err, "line 1: unquoted string 'hello' is not allowed")
+}
+
+func TestDecoderAllowLineContinuations(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, AllowLineContinuations())
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("continued", "key: \"one \\\ntwo\"\nnext: 1", map[string]any{"key": "one two", "next": int64(1)}, "")
+	f("several", "key: \"a\\\nb\\\nc\"", map[string]any{"key": "abc"}, "")
+	f("indented", "key: \"one\\\n  two\"", map[string]any{"key": "one  two"}, "")
+	f("escapes", "key: \"\\t\\\n\\n\"", map[string]any{"key": "\t\n"}, "")
+	f("nested", "a::\n  key: \"x\\\ny\"\n  b: 2", map[string]any{"a": map[string]any{"key": "xy", "b": int64(2)}}, "")
+	f("quoted_key", "\"a\\\nb\": 1", map[string]any{"ab": int64(1)}, "")
+	f("not_at_end", "key: \"one \\ two\"", nil, "line 1: invalid escape character '\\ '")
+	f("unclosed", "key: \"one\\\ntwo", nil, "line 2: unclosed string")
+	f("at_eof", "key: \"one\\\n", nil, "line 1: unclosed string")
+
+	// Without the option, a '\' at the end of a line is an error.
+	var result any
+	err := Unmarshal([]byte("key: \"one\\\ntwo\""), &result)
+	assert.EqualError(t, err, "line 1: incomplete escape sequence")
+}
+
+func TestDecoderLenientEscapes(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, LenientEscapes())
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("unknown", `key: "a\xb"`, map[string]any{"key": `a\xb`}, "")
+	f("path", `key: "C:\data\logs"`, map[string]any{"key": `C:\data\logs`}, "")
+	f("regexp", `key: "\d+\.\d+"`, map[string]any{"key": `\d+\.\d+`}, "")
+	f("known", `key: "a\tb\\c\u00e9"`, map[string]any{"key": "a\tb\\c\u00e9"}, "")
+	f("mixed", `key: "C:\temp\x"`, map[string]any{"key": "C:\temp\\x"}, "")
+	f("unicode_char", `key: "\é"`, map[string]any{"key": `\é`}, "")
+	f("quoted_key", `"a\xb": 1`, map[string]any{`a\xb`: int64(1)}, "")
+	f("bad_unicode", `key: "\uZZZZ"`, nil, `line 1: invalid unicode escape sequence '\uZZZZ'`)
+
+	// Without the option, an unknown escape is an error.
+	var result any
+	err := Unmarshal([]byte(`key: "a\xb"`), &result)
+	assert.EqualError(t, err, "line 1: invalid escape character '\\x'")
+}
+
+func TestDecoderAllowLeadingDecimalPoint(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, AllowLeadingDecimalPoint())
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("plain", "key: .5", map[string]any{"key": 0.5}, "")
+	f("negative", "key: -.5", map[string]any{"key": -0.5}, "")
+	f("positive", "key: +.25", map[string]any{"key": 0.25}, "")
+	f("exponent", "key: .5e2", map[string]any{"key": 50.0}, "")
+	f("inline_list", "key:: .5, -.75", map[string]any{"key": []any{0.5, -0.75}}, "")
+	f("list_item", "- .5", []any{0.5}, "")
+	f("root", ".125", 0.125, "")
+	f("dot_alone", "key: .", nil, "line 1: unexpected character '.'")
+	f("sign_and_dot", "key: -.", nil, "line 1: invalid char after '-'")
+	f("dot_letter", "key: .e5", nil, "line 1: unexpected character '.'")
+
+	// Without the option, a leading '.' is an error.
+	var result any
+	err := Unmarshal([]byte("key: .5"), &result)
+	assert.EqualError(t, err, "line 1: unexpected character '.'")
+	err = Unmarshal([]byte("key: -.5"), &result)
+	assert.EqualError(t, err, "line 1: invalid char after '-'")
+}
+
+func TestNumber(t *testing.T) {
+	type Config struct {
+		Rate    Number   `huml:"rate"`
+		Limit   Number   `huml:"limit"`
+		Scale   Number   `huml:"scale"`
+		Count   Number   `huml:"count"`
+		Weights []Number `huml:"weights,inline"`
+		Max     *Number  `huml:"max"`
+	}
+
+	doc := `%HUML v0.2.0
+rate: 1.50
+limit: 100.0
+scale: 1e3
+count: 0x1F
+weights:: 0.10, 2, -3.250
+max: inf
+`
+	var cfg Config
+	assert.NoError(t, Unmarshal([]byte(doc), &cfg))
+	inf := Number("inf")
+	assert.Equal(t, Config{
+		Rate:    "1.50",
+		Limit:   "100.0",
+		Scale:   "1e3",
+		Count:   "31",
+		Weights: []Number{"0.10", "2", "-3.250"},
+		Max:     &inf,
+	}, cfg)
+
+	// The numbers are written back as they were read.
+	out, err := Marshal(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Replace(doc, "0x1F", "31", 1), string(out))
+
+	f, err := cfg.Rate.Float64()
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, f)
+	n, err := cfg.Count.Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(31), n)
+	_, err = cfg.Limit.Int64()
+	assert.EqualError(t, err, `huml: "100.0" is not an integer`)
+
+	// The accessors read numbers as a document would.
+	n, err = Number("010").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	n, err = Number("-0x1F").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-31), n)
+	f, err = Number("0o17").Float64()
+	assert.NoError(t, err)
+	assert.Equal(t, 15.0, f)
+	f, err = Number("-inf").Float64()
+	assert.NoError(t, err)
+	assert.True(t, math.IsInf(f, -1))
+	_, err = Number("0x1p4").Float64()
+	assert.EqualError(t, err, `huml: invalid number "0x1p4"`)
+
+	// Floats keep digits a float64 cannot hold.
+	exact := "a: 12345678.9\nb: 0.000001\nc: 1_000.50\nd: 100000000000000000000.5\n"
+	var nums map[string]Number
+	assert.NoError(t, Unmarshal([]byte(exact), &nums))
+	assert.Equal(t, map[string]Number{
+		"a": "12345678.9",
+		"b": "0.000001",
+		"c": "1000.50",
+		"d": "100000000000000000000.5",
+	}, nums)
+	out, err = Marshal(nums)
+	assert.NoError(t, err)
+	assert.Equal(t, "%HUML v0.2.0\n"+strings.Replace(exact, "1_000.50", "1000.50", 1), string(out))
+
+	// Only numbers decode into a Number, and only numbers are written.
+	err = Unmarshal([]byte(`rate: "1.50"`), &cfg)
+	assert.EqualError(t, err, "error setting field rate: cannot unmarshal string into huml.Number")
+	for _, bad := range []Number{"", "abc", `"1"`, "1, 2", "1 # x", "1\n2"} {
+		_, err = Marshal(map[string]Number{"n": bad})
+		assert.EqualError(t, err, fmt.Sprintf("huml: invalid number %q", bad))
+	}
+}
+
+func TestUnmarshalEach(t *testing.T) {
+	type Record struct {
+		ID   int    `huml:"id"`
+		Name string `huml:"name"`
+	}
+
+	var doc strings.Builder
+	for i := range 10000 {
+		fmt.Fprintf(&doc, "- ::\n  id: %d\n  name: \"r%d\"\n", i, i)
+	}
+
+	var count int
+	err := UnmarshalEach(strings.NewReader(doc.String()), func(raw []byte) error {
+		var rec Record
+		if err := Unmarshal(raw, &rec); err != nil {
+			return err
+		}
+		assert.Equal(t, Record{ID: count, Name: fmt.Sprintf("r%d", count)}, rec)
+		count++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10000, count)
+
+	f := func(name, doc string, expected []any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var items []any
+			err := UnmarshalEach(strings.NewReader(doc), func(raw []byte) error {
+				var item any
+				if err := Unmarshal(raw, &item); err != nil {
+					return err
+				}
+				items = append(items, item)
+				return nil
+			})
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, expected, items)
+		})
+	}
+
+	f("scalars", "- 1\n- \"a\"\n- null", []any{int64(1), "a", nil}, "")
+	f("nested", "- :: 1, 2\n- ::\n  - true", []any{[]any{int64(1), int64(2)}, []any{true}}, "")
+	f("inline", "1, 2, 3", []any{int64(1), int64(2), int64(3)}, "")
+	f("empty", "[]", nil, "")
+	f("not_a_list", "a: 1", nil, "root value is a map[string]interface {}, not a list")
+	f("syntax_error", "- 1\n- 2\n- x", []any{int64(1), int64(2)}, "line 3: unquoted string 'x' is not allowed")
+	f("trailing_content", "- 1\nkey: 2", []any{int64(1)}, "line 2: unexpected content after root list")
+
+	// An error from fn stops reading.
+	count = 0
+	err = UnmarshalEach(strings.NewReader("- 1\n- 2\n- 3"), func(raw []byte) error {
+		count++
+		return errors.New("stop")
+	})
+	assert.EqualError(t, err, "stop")
+	assert.Equal(t, 1, count)
+}
+
+func TestRootListTrailingContent(t *testing.T) {
+	var result any
+	err := Unmarshal([]byte("- 1\n- 2\nkey: 3"), &result)
+	assert.EqualError(t, err, "line 3: unexpected content after root list")
+
+	dec := NewDecoder(strings.NewReader("- 1\nkey: 3"))
+	for err = nil; err == nil; {
+		_, err = dec.Token()
+	}
+	assert.EqualError(t, err, "line 2: unexpected content after root list")
+}
+
+func TestInvalidKeyCharacters(t *testing.T) {
+	f := func(name, doc string, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	f("dot", "a.b: 1", "line 1: invalid character '.' in key; quote the key if it contains special characters")
+	f("space", "a b: 1", "line 1: invalid character ' ' in key; quote the key if it contains special characters")
+	f("at", "a@b: 1", "line 1: invalid character '@' in key; quote the key if it contains special characters")
+	f("unicode", "aé: 1", "line 1: invalid character 'é' in key; quote the key if it contains special characters")
+	f("vector", "a.b:: 1, 2", "line 1: invalid character '.' in key; quote the key if it contains special characters")
+	f("nested", "p::\n  a/b: 1", "line 2: invalid character '/' in key; quote the key if it contains special characters")
+	f("inline_dict", "p:: x: 1, a.b: 2", "line 1: invalid character '.' in key; quote the key if it contains special characters")
+	f("quoted", "\"a.b\": 1", "")
+	f("comment", "a: 1 # see: docs", "")
+	f("value", "a: b # c: d", "line 1: unquoted string 'b' is not allowed")
+}
+
+func TestBlankLinesInVectors(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, expected, result)
+			}
+
+			// Token reads the same document.
+			dec := NewDecoder(strings.NewReader(doc))
+			for err = nil; err == nil; {
+				_, err = dec.Token()
+			}
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.Equal(t, io.EOF, err)
+			}
+		})
+	}
+
+	f("between_root_items", "- 1\n\n- 2", []any{int64(1), int64(2)}, "")
+	f("after_vector_indicator", "items::\n\n  - \"a\"\n\n  - \"b\"", map[string]any{"items": []any{"a", "b"}}, "")
+	f("after_vector_indicator_dict", "d::\n\n\n  a: 1", map[string]any{"d": map[string]any{"a": int64(1)}}, "")
+	f("after_list_item_vector", "- ::\n\n  - 1\n\n- 2", []any{[]any{int64(1)}, int64(2)}, "")
+	f("nested_resume", "a::\n  b::\n    - 1\n\n    - 2\n\n  c: 3\n\nd: 4",
+		map[string]any{"a": map[string]any{"b": []any{int64(1), int64(2)}, "c": int64(3)}, "d": int64(4)}, "")
+	f("comment_and_blank", "items::\n  # first\n\n  - 1", map[string]any{"items": []any{int64(1)}}, "")
+	f("only_blanks", "items::\n\n\nx: 1", nil, "line 4: ambiguous empty vector after '::'. Use [] or {}.")
+	f("only_blanks_at_end", "items::\n\n", nil, "line 2: ambiguous empty vector after '::'. Use [] or {}.")
+}
+
+func TestDecoderMaxElements(t *testing.T) {
+	f := func(name, doc string, maxElements int, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, MaxElements(maxElements))
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// Token enforces the same limit.
+			dec := NewDecoder(strings.NewReader(doc), MaxElements(maxElements))
+			for err = nil; err == nil; {
+				_, err = dec.Token()
+			}
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.Equal(t, io.EOF, err)
+			}
+		})
+	}
+
+	// The document has 6 elements: a, b, its 2 items, c and its key d.
+	doc := "a: 1\nb:: 1, 2\nc::\n  d: true"
+	f("at_limit", doc, 6, "")
+	f("over_limit", doc, 5, "line 4: maximum number of elements of 5 exceeded")
+	f("inline_list", doc, 3, "line 2: maximum number of elements of 3 exceeded")
+	f("inline_dict", "a:: x: 1, y: 2, z: 3", 3, "line 1: maximum number of elements of 3 exceeded")
+	f("list", "- 1\n- 2\n- 3", 2, "line 3: maximum number of elements of 2 exceeded")
+	f("root_inline", "1, 2, 3", 2, "line 1: maximum number of elements of 2 exceeded")
+	f("scalar", "1", 1, "")
+	f("unlimited", doc, 0, "")
+}
+
+func TestDecoderMaxLines(t *testing.T) {
+	f := func(name, doc string, maxLines int, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, MaxLines(maxLines))
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// Token enforces the same limit.
+			dec := NewDecoder(strings.NewReader(doc), MaxLines(maxLines))
+			for err = nil; err == nil; {
+				_, err = dec.Token()
+			}
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.Equal(t, io.EOF, err)
+			}
+		})
+	}
+
+	doc := "# config\na: 1\n\nb::\n  c: true\n"
+	f("at_limit", doc, 5, "")
+	f("over_limit", doc, 4, "line 5: document exceeds 4 lines")
+	f("unlimited", doc, 0, "")
+	f("multiline_string", "s: \"\"\"\n  one\n  two\n\"\"\"", 3, "line 4: document exceeds 3 lines")
+}
+
+func TestDecoderAppendToSlices(t *testing.T) {
+	var nums []int
+	assert.NoError(t, Unmarshal([]byte("1, 2"), &nums, AppendToSlices()))
+	assert.NoError(t, Unmarshal([]byte("3, 4"), &nums, AppendToSlices()))
+	assert.Equal(t, []int{1, 2, 3, 4}, nums)
+
+	// By default, the slice is replaced.
+	assert.NoError(t, Unmarshal([]byte("5, 6"), &nums))
+	assert.Equal(t, []int{5, 6}, nums)
+
+	// Slice fields of a struct accumulate, and errors give the index within
+	// the list.
+	type Config struct {
+		Hosts []string `huml:"hosts"`
+		Ports []int    `huml:"ports"`
+	}
+	var cfg Config
+	assert.NoError(t, Unmarshal([]byte("hosts:: \"a\", \"b\"\nports:: 1"), &cfg, AppendToSlices()))
+	assert.NoError(t, Unmarshal([]byte("hosts:: \"c\""), &cfg, AppendToSlices()))
+	assert.Equal(t, Config{Hosts: []string{"a", "b", "c"}, Ports: []int{1}}, cfg)
+
+	err := Unmarshal([]byte("ports:: 2, \"x\""), &cfg, AppendToSlices())
+	assert.EqualError(t, err, "error setting field ports: error setting slice element 1: cannot unmarshal string into integer")
+	assert.Equal(t, []int{1, 2, 0}, cfg.Ports)
+}
+
+func TestDecoderAllowUnicodeKeys(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, AllowUnicodeKeys())
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("accented", "café: 1\nnaïve_key-2: 2", map[string]any{"café": int64(1), "naïve_key-2": int64(2)}, "")
+	f("cjk", "名前: \"x\"\n設定::\n  値: true", map[string]any{"名前": "x", "設定": map[string]any{"値": true}}, "")
+	f("combining_mark", "café: 1", map[string]any{"café": int64(1)}, "")
+	f("inline_dict", "a:: é: 1, ü: 2", map[string]any{"a": map[string]any{"é": int64(1), "ü": int64(2)}}, "")
+	f("symbol", "a☃: 1", nil, "line 1: invalid character '☃' in key; quote the key if it contains special characters")
+	f("leading_mark", "́a: 1", nil, "line 1: unexpected character '́'")
+
+	// Without the option, such keys must be quoted.
+	var result any
+	err := Unmarshal([]byte("café: 1"), &result)
+	assert.EqualError(t, err, "line 1: invalid character 'é' in key; quote the key if it contains special characters")
+}
+
+func TestFloatIntegerOverflow(t *testing.T) {
+	var i struct {
+		N int64 `huml:"n"`
+	}
+	assert.EqualError(t, Unmarshal([]byte("n: 1e30"), &i), "error setting field n: value 1e+30 overflows int64")
+	assert.EqualError(t, Unmarshal([]byte("n: 9223372036854775808.0"), &i), "error setting field n: value 9.223372036854776e+18 overflows int64")
+	assert.NoError(t, Unmarshal([]byte("n: -9223372036854775808.0"), &i))
+	assert.Equal(t, int64(math.MinInt64), i.N)
+
+	var u struct {
+		N uint64 `huml:"n"`
+	}
+	assert.EqualError(t, Unmarshal([]byte("n: 1e30"), &u), "error setting field n: value 1e+30 overflows uint64")
+}
+
+func TestMultilineStringClosingComment(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, expected, result)
+			}
+
+			// Token reads the same document.
+			dec := NewDecoder(strings.NewReader(doc))
+			for err = nil; err == nil; {
+				_, err = dec.Token()
+			}
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+			} else {
+				assert.Equal(t, io.EOF, err)
+			}
+		})
+	}
+
+	f("dict", "a: \"\"\"\n  x\n\"\"\" # done\nb: 1", map[string]any{"a": "x", "b": int64(1)}, "")
+	f("list", "- \"\"\"\n  x\n\"\"\" # done\n- 2", []any{"x", int64(2)}, "")
+	f("root", "\"\"\"\n  x\n\"\"\" # done", "x", "")
+	f("nested", "a::\n  b: \"\"\"\n    x\n  \"\"\" # done", map[string]any{"a": map[string]any{"b": "x"}}, "")
+	f("long_fence", "a: \"\"\"\"\n  x\n\"\"\"\" # done", map[string]any{"a": "x"}, "")
+	f("content", "a: \"\"\"\n  x\n\"\"\" extra", nil, "line 3: invalid content after multiline string closing delimiter")
+	f("no_space_before", "a: \"\"\"\n  x\n\"\"\"# done", map[string]any{"a": "x"}, "")
+	f("no_space_after", "a: \"\"\"\n  x\n\"\"\" #done", nil, "line 3: comment hash '#' must be followed by a space")
+	f("trailing_spaces", "a: \"\"\"\n  x\n\"\"\"  ", nil, "line 3: trailing spaces are not allowed")
+}
+
+func TestDecoderExpandDottedKeys(t *testing.T) {
+	f := func(name, doc string, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result, ExpandDottedKeys())
+			// Token rejects the same documents.
+			streamErr := ValidateStream(strings.NewReader(doc), nil, ExpandDottedKeys())
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				assert.EqualError(t, streamErr, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, streamErr)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	abc := map[string]any{"a": map[string]any{"b": map[string]any{"c": int64(1)}}}
+	f("three_levels", "a.b.c: 1", abc, "")
+	f("vector", "a.b:: c: 1", abc, "")
+	f("block_vector", "a.b::\n  c: 1", abc, "")
+	f("shared_prefix", "a.b: 1\na.c:: 2, 3\nd: 4", map[string]any{
+		"a": map[string]any{"b": int64(1), "c": []any{int64(2), int64(3)}},
+		"d": int64(4),
+	}, "")
+	f("nested", "x::\n  a.b: 1", map[string]any{"x": map[string]any{"a": map[string]any{"b": int64(1)}}}, "")
+	f("inline_dict", "x:: a.b: 1, a.c: 2", map[string]any{"x": map[string]any{"a": map[string]any{"b": int64(1), "c": int64(2)}}}, "")
+	f("quoted", `"a.b": 1`, map[string]any{"a.b": int64(1)}, "")
+	f("quoted_and_dotted", "\"a.b\": 1\na.b: 2", map[string]any{"a.b": int64(1), "a": map[string]any{"b": int64(2)}}, "")
+	f("duplicate", "a.b: 1\na.b: 2", nil, "line 2: duplicate key 'a.b' in dict")
+	f("scalar_prefix", "a: 1\na.b: 2", nil, "line 2: duplicate key 'a' in dict")
+	f("dotted_then_full", "a.b: 1\na:: c: 2", nil, "line 2: duplicate key 'a' in dict")
+	f("full_then_dotted", "a:: c: 2\na.b: 1", nil, "line 2: duplicate key 'a' in dict")
+	f("dotted_then_scalar", "a.b: 1\na: 2", nil, "line 2: duplicate key 'a' in dict")
+	f("scalar_middle", "a.b: 1\na.b.c: 2", nil, "line 2: duplicate key 'b' in dict")
+	f("dict_middle", "a.b.c: 1\na.b: 2", nil, "line 2: duplicate key 'a.b' in dict")
+	f("nested_conflict", "x::\n  a: 1\n  a.b: 2", nil, "line 3: duplicate key 'a' in dict")
+	f("quoted_then_dotted_prefix", "\"a\": 1\na.b: 2", nil, "line 2: duplicate key 'a' in dict")
+	f("leading_dot", ".a: 1", nil, "line 1: unexpected character '.'")
+	f("trailing_dot", "a.: 1", nil, "line 1: invalid character '.' in key; quote the key if it contains special characters")
+	f("double_dot", "a..b: 1", nil, "line 1: invalid character '.' in key; quote the key if it contains special characters")
+
+	// Without the option, dots need quoting.
+	var result any
+	err := Unmarshal([]byte("a.b: 1"), &result)
+	assert.EqualError(t, err, "line 1: invalid character '.' in key; quote the key if it contains special characters")
+}
+
+func TestUnmarshalIndented(t *testing.T) {
+	doc := `server::
+  # The fragment starts below.
+  host: "localhost"
+
+  ports:: 80, 443
+  tls::
+    cert: "a.pem"
+  motd: """
+    hello
+      world
+  """
+name: "app"
+`
+	// Cut out the block of the server key.
+	lines := strings.Split(doc, "\n")
+	fragment := strings.Join(lines[1:11], "\n")
+
+	var result map[string]any
+	assert.NoError(t, UnmarshalIndented([]byte(fragment), 2, &result))
+	assert.Equal(t, map[string]any{
+		"host":  "localhost",
+		"ports": []any{int64(80), int64(443)},
+		"tls":   map[string]any{"cert": "a.pem"},
+		"motd":  "hello\n  world",
+	}, result)
+
+	// Without the base indentation, the root must not be indented.
+	err := Unmarshal([]byte(fragment), &result)
+	assert.EqualError(t, err, "line 2: root element must not be indented")
+
+	// Lines must keep the base indentation.
+	err = UnmarshalIndented([]byte("  a: 1\nb: 2"), 2, &result)
+	assert.EqualError(t, err, "line 2: line is indented less than the base indentation of 2")
+	err = UnmarshalIndented([]byte("  a: 1\n    b: 2"), 2, &result)
+	assert.EqualError(t, err, "line 2: bad indent 2, expected 0")
+
+	// It reads the output of an Encoder with the same base indentation.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetBaseIndent(4)
+	in := map[string]any{"list": []any{int64(1), "two"}, "dict": map[string]any{"x": true}}
+	assert.NoError(t, enc.Encode(in))
+	result = nil
+	assert.NoError(t, NewDecoder(&buf, BaseIndent(4)).Decode(&result))
+	assert.Equal(t, in, result)
+}
+
+func TestQuotedKeyEscapes(t *testing.T) {
+	f := func(name, doc, key string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result map[string]any
+			assert.NoError(t, Unmarshal([]byte(doc), &result))
+			assert.Equal(t, map[string]any{key: int64(1)}, result)
+
+			// The streaming API reads the same key.
+			dec := NewDecoder(strings.NewReader(doc))
+			var events []Event
+			for {
+				ev, err := dec.Token()
+				if err != nil {
+					assert.ErrorIs(t, err, io.EOF)
+					break
+				}
+				events = append(events, ev)
+			}
+			assert.Equal(t, []Event{{Kind: EventDictStart}, {Kind: EventKey, Key: key, Quoted: true}, {Kind: EventValue, Value: int64(1)}, {Kind: EventDictEnd}}, events)
+
+			// The key round-trips through Marshal.
+			out, err := Marshal(result)
+			assert.NoError(t, err)
+			var again map[string]any
+			assert.NoError(t, Unmarshal(out, &again), string(out))
+			assert.Equal(t, result, again)
+		})
+	}
+
+	f("tab", `"a\tb": 1`, "a\tb")
+	f("newline", `"a\nb": 1`, "a\nb")
+	f("quote", `"say \"hi\"": 1`, `say "hi"`)
+	f("backslash", `"a\\b": 1`, `a\b`)
+	f("unicode", `"café": 1`, "café")
+	f("control", `"a\u0001": 1`, "a\x01")
+	f("unicode_escape", `"caf\u00e9": 1`, "café")
+
+	// Keys of inline dicts are read the same way.
+	var result map[string]any
+	assert.NoError(t, Unmarshal([]byte(`d:: "a\tb": 1, "\u00e9": 2`), &result))
+	assert.Equal(t, map[string]any{"d": map[string]any{"a\tb": int64(1), "é": int64(2)}}, result)
+
+	// Escaped and literal forms of a key are the same key.
+	err := Unmarshal([]byte("\"café\": 1\n\"caf\\u00e9\": 2"), &result)
+	assert.EqualError(t, err, "line 2: duplicate key 'café' in dict")
+
+	out, err := Marshal(map[string]any{"a\tb": int64(1), "say \"hi\"": int64(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, "%HUML v0.2.0\n\"a\\tb\": 1\n\"say \\\"hi\\\"\": 2\n", string(out))
+}
+
+func TestFormatError(t *testing.T) {
+	f := func(name, doc, expected string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result)
+			if assert.Error(t, err) {
+				assert.Equal(t, expected, FormatError([]byte(doc), err))
+			}
+		})
+	}
+
+	f("middle", "name: \"app\"\nserver::\n    port: 8080\ndebug: true\n", `line 3: bad indent 4, expected 2
+1 | name: "app"
+2 | server::
+3 |     port: 8080
+  |     ^
+4 | debug: true`)
+	f("first_line", "key:  1\nb: 2\nc: 3\nd: 4", `line 1: expected single space after ':', found multiple
+1 | key:  1
+  |      ^
+2 | b: 2
+3 | c: 3`)
+	f("blank_lines", "a: 1\n\nb: 2\n\nc: 3 x\n\n", `line 5: unexpected content at end of line
+3 | b: 2
+4 |
+5 | c: 3 x
+  |      ^
+6 |`)
+	f("unicode", "k: \"é\" x", `line 1: unexpected content at end of line
+1 | k: "é" x
+  |        ^`)
+	f("wide_numbers", "a::\n"+strings.Repeat("  - 1\n", 8)+"b:  2", `line 10: expected single space after ':', found multiple
+ 8 |   - 1
+ 9 |   - 1
+10 | b:  2
+   |    ^`)
+
+	// Other errors are rendered as they are.
+	var n int
+	err := Unmarshal([]byte(`"x"`), &n)
+	assert.Equal(t, err.Error(), FormatError([]byte(`"x"`), err))
+}
+
+func TestTypedErrors(t *testing.T) {
+	type Item struct {
+		Count uint8 `huml:"count"`
+	}
+	type Config struct {
+		Name  string `huml:"name"`
+		Items []Item `huml:"items"`
+	}
+
+	var cfg Config
+	err := Unmarshal([]byte("name: \"a\"\nitems::\n  - ::\n    count: 300"), &cfg)
+	var typeErr *TypeError
+	if assert.ErrorAs(t, err, &typeErr) {
+		assert.Equal(t, int64(300), typeErr.Value)
+		assert.Equal(t, reflect.TypeFor[uint8](), typeErr.Type)
+		assert.Equal(t, "items.0.count", typeErr.Field)
+		assert.EqualError(t, typeErr, "value 300 overflows uint8")
+	}
+
+	err = Unmarshal([]byte("name:: 1, 2"), &cfg)
+	if assert.ErrorAs(t, err, &typeErr) {
+		assert.Equal(t, []any{int64(1), int64(2)}, typeErr.Value)
+		assert.Equal(t, reflect.TypeFor[string](), typeErr.Type)
+		assert.Equal(t, "name", typeErr.Field)
+	}
+
+	// The error of UnmarshalText is kept.
+	var ip net.IP
+	err = Unmarshal([]byte(`"nope"`), &ip)
+	if assert.ErrorAs(t, err, &typeErr) {
+		assert.Equal(t, "nope", typeErr.Value)
+		assert.Equal(t, "", typeErr.Field)
+		var parseErr *net.ParseError
+		assert.ErrorAs(t, err, &parseErr)
+	}
+
+	var syntaxErr *SyntaxError
+	err = Unmarshal([]byte("a: 1\na: 2"), &cfg)
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, &SyntaxError{Line: 2, Column: 0, Msg: "duplicate key 'a' in dict"}, syntaxErr)
+	}
+	err = Unmarshal([]byte("a: \"x"), &cfg)
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, 1, syntaxErr.Line)
+		assert.Equal(t, "unclosed string", syntaxErr.Msg)
+	}
+	assert.False(t, errors.As(err, &typeErr))
+
+	// Documents breaking the rules set by options are syntax errors too.
+	err = Unmarshal([]byte("a:: 1, 2"), &cfg, DisallowInlineVectors())
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, &SyntaxError{Line: 1, Column: 4, Msg: "inline list is not allowed, use the multi-line form"}, syntaxErr)
+	}
+	err = Unmarshal([]byte("a: 1\nb: 2"), &cfg, MaxElements(1))
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, 2, syntaxErr.Line)
+		assert.Equal(t, "maximum number of elements of 1 exceeded", syntaxErr.Msg)
+	}
+
+	var missingErr *MissingFieldError
+	_, err = UnmarshalUnion([]byte(`name: "a"`), "kind", map[string]func() any{})
+	if assert.ErrorAs(t, err, &missingErr) {
+		assert.Equal(t, "kind", missingErr.Field)
+	}
+
+	var unknownErr *UnknownFieldError
+	err = Unmarshal([]byte("name: \"a\"\nport: 1"), &cfg, AllowedRootKeys("name"))
+	if assert.ErrorAs(t, err, &unknownErr) {
+		assert.Equal(t, &UnknownFieldError{Line: 2, Field: "port"}, unknownErr)
+	}
+}
+
+func TestSyntaxErrorColumns(t *testing.T) {
+	f := func(name, doc string, line, column int, msg string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result any
+			err := Unmarshal([]byte(doc), &result)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected a SyntaxError, got %v", err)
+			}
+			assert.Equal(t, &SyntaxError{Line: line, Column: column, Msg: msg}, syntaxErr)
+			assert.EqualError(t, err, fmt.Sprintf("line %d: %s", line, msg))
+		})
+	}
+
+	f("double_space_after_colon", "a: 1\nkey:  1", 2, 5, "expected single space after ':', found multiple")
+	f("no_space_after_colon", "key:1", 1, 4, "expected single space after ':'")
+	f("double_space_after_vector", "key::  1, 2", 1, 6, "expected single space after '::', found multiple")
+	f("space_before_comma", "key:: 1 , 2", 1, 7, "no spaces allowed before comma")
+	f("spaces_before_comma", "key:: a: 1  , b: 2", 1, 10, "no spaces allowed before comma")
+	f("double_space_after_comma", "key:: 1,  2", 1, 9, "expected single space after comma, found multiple")
+	f("nested", "a::\n  b:  1", 2, 5, "expected single space after ':', found multiple")
+	f("comment_hash", "a: 1 #x", 1, 6, "comment hash '#' must be followed by a space")
+	f("trailing_spaces", "a: 1  \nb: 2", 1, 4, "trailing spaces are not allowed")
+}
@@ -4,28 +4,67 @@ package huml
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // An Encoder writes HUML values to an output stream.
 type Encoder struct {
-	w io.Writer
+	w    io.Writer
+	opts encodeOpts
+}
+
+// encodeOpts holds the options that control how values are encoded.
+type encodeOpts struct {
+	keyTransform     func(string) string    // Applied to untagged struct field names.
+	transformMapKeys bool                   // Also apply keyTransform to map keys.
+	compact          bool                   // Write vectors of scalars inline.
+	omitNilMapValues bool                   // Skip map entries whose value is nil.
+	plainFloatLen    int                    // Max length of floats in plain notation (0 disables).
+	baseIndent       string                 // Prefix written at the start of every line.
+	jsonFallback     bool                   // Encode json.Marshaler values via their JSON.
+	unicodeKeys      bool                   // Leave keys with Unicode letters bare.
+	enumNames        bool                   // Write integer fmt.Stringer values as their names.
+	errorStrings     bool                   // Write error values as their messages.
+	dottedKeys       bool                   // Join keys of chains of single-key dicts with dots.
+	maxLineWidth     int                    // Max width of lines with inline vectors (0 disables).
+	styles           map[string]vectorStyle // Styles of vectors by key path, for MarshalLike.
+	header           []string               // Comment lines written before the content.
+	lineComments     map[string]string      // Comments ending dict entry lines by key path, for Canonicalize.
+}
+
+// tracksPath reports whether the state keeps the path of the value being
+// written, which the options that apply by key path need.
+func (o *encodeOpts) tracksPath() bool {
+	return o.styles != nil || o.lineComments != nil
 }
 
 // state holds the encoding state for a single Marshal or Encode call.
 // It is used to pass state through the recursive encoding process without
 // passing many arguments.
 type state struct {
-	w   io.Writer
-	err error
+	w    io.Writer
+	err  error
+	opts *encodeOpts
+
+	midLine bool // True if the current output line has content.
+	col     int  // Characters written on the current line, if maxLineWidth is set.
+
+	path []string // Keys and indices leading to the value being written, if styles is set.
 }
 
 var statePool = sync.Pool{
@@ -41,12 +80,18 @@ var statePool = sync.Pool{
 //
 // The mapping from Go types to HUML is as follows:
 //   - bool -> true | false
-//   - int, float, etc. -> number
+//   - int, float, etc. -> number (NaN -> nan, +Inf -> inf, -Inf -> -inf)
 //   - string -> "quoted string" or ```multiline string```
 //   - struct -> multi-line dictionary
-//   - map -> multi-line dictionary
-//   - slice, array -> multi-line list
-//   - nil pointer or interface -> null
+//   - map -> multi-line dictionary, or {} if empty
+//   - slice, array -> multi-line list, or [] if empty
+//   - nil pointer, interface, map or slice -> null
+//   - big.Rat -> exact string such as "3/4", or "5" for an integer
+//   - encoding.TextMarshaler, such as net.IP or time.Time -> quoted string
+//   - integer fmt.Stringer, with Encoder.SetEnumNames -> quoted string
+//
+// A root value is written the same way, so Marshal(nil) returns a document
+// holding just null, which is valid HUML and decodes back to nil.
 //
 // Struct fields can be customized with `huml` tags. For example:
 //
@@ -59,10 +104,59 @@ var statePool = sync.Pool{
 //	// Field is omitted if it has a zero/empty value.
 //	Field string `huml:"my_field,omitempty"`
 //
+//	// Vector field is always written inline (`tags:: a, b`), or always
+//	// as an indented block, regardless of the compact option.
+//	Tags  []string `huml:"tags,inline"`
+//	Items []string `huml:"items,block"`
+//
+//	// Field is preceded by the comment line "# The listening port". The
+//	// comment takes up the rest of the tag, so it must be the last option.
+//	Port int `huml:"port,comment=The listening port"`
+//
+//	// Slice of strings or numbers is written in sorted order.
+//	Tags []string `huml:"tags,sort"`
+//
+//	// Number is written in the given unit, here 30 for 30 seconds.
+//	Timeout time.Duration `huml:"timeout,unit=s"`
+//
+//	// Time is written with the given layout, as "2024-05-06".
+//	Created time.Time `huml:"created,timeformat=2006-01-02"`
+//
+//	// The struct holding the field is written as the field's value alone,
+//	// so that a Port{V: 8080} is written as 8080.
+//	V int `huml:",scalar"`
+//
+// The unit option scales a number field between the unit used in the
+// document and the base unit of the field, nanoseconds for durations and bytes
+// for sizes. The supported units are ns, us (or µs), ms, s, m and h for
+// durations, B, KB, MB, GB and TB for decimal sizes, and KiB, MiB, GiB and
+// TiB for binary sizes. A value that is not a whole number of units is written
+// as a float.
+//
+// The timeformat option writes a time.Time field, or a pointer to one, as a
+// string in the given layout, as accepted by time.Format, instead of RFC 3339.
+// The layout cannot contain commas.
+//
+// Marshalling fails if a field tagged inline holds nested vectors or
+// multi-line strings, which cannot be written on a single line.
+//
 // The omitempty option skips fields that are:
 //   - Empty strings, zero numbers, false booleans
 //   - Nil pointers, empty slices/maps/arrays
-//   - Structs where all exported fields are empty
+//   - Structs where all exported fields are empty, and structs with a text
+//     form, such as time.Time or netip.Addr, that are the zero value
+//
+// The omitempty=kinds option only skips empty values of the given kinds,
+// separated by "|", out of string, number, bool, nil, vector and struct.
+// Pointers and interfaces are looked through, so that a field of type any
+// tagged `huml:"value,omitempty=string|nil"` is skipped if it is nil or holds
+// an empty string, but not if it holds 0. A nil slice or map counts as an
+// empty vector.
+//
+// Anonymous struct fields follow the rules of encoding/json. The fields of an
+// untagged embedded struct are flattened into the parent, while an embedded
+// non-struct type (or a tagged embedded struct) is keyed by its type name or
+// tag. The same rules apply when decoding.
 func Marshal(v any) ([]byte, error) {
 	var buf bytes.Buffer
 	encoder := NewEncoder(&buf)
@@ -77,16 +171,323 @@ func Marshal(v any) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// Canonicalize parses the HUML document in data and returns it re-encoded in
+// the canonical form written by Marshal, with dict keys sorted and comments
+// dropped, apart from the comment block at the start of the document, which
+// is kept as its header (see Decoder.Header), and the comments at the end of
+// the lines of multi-line dict entries with single-line scalar values. Unlike
+// Marshal, which always writes a %HUML v0.2.0 directive, the %HUML directive
+// of the source is kept as it is, with its version, and none is added to a
+// document without one.
+func Canonicalize(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty document is undefined")
+	}
+
+	var v any
+	dec := NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeDirective(&buf, dec)
+	enc := NewEncoder(&buf)
+	enc.SetHeader(dec.Header())
+	if len(dec.parser.lineComments) > 0 {
+		enc.opts.lineComments = make(map[string]string)
+		collectLineComments(v, nil, dec.parser.lineComments, enc.opts.lineComments)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeDirective writes the %HUML directive of the document read by dec, with
+// its version as written, if it had one.
+func writeDirective(buf *bytes.Buffer, dec *Decoder) {
+	version, ok := dec.Version()
+	if !ok {
+		return
+	}
+	buf.WriteString("%HUML")
+	if version != "" {
+		buf.WriteString(" " + version)
+	}
+	buf.WriteByte('\n')
+}
+
+// MarshalLike is like Marshal, but follows the style of reference, an
+// earlier version of the document, to keep the differences between them small
+// when a document is regenerated. A vector found at the same path of keys and
+// list indices in reference is written inline or in block form as it is
+// there, if it can be, and the %HUML directive of reference is kept as
+// Canonicalize does. Other vectors are written as Marshal writes them.
+// Indentation is always two spaces, as the spec requires, and keys are
+// ordered as Marshal orders them.
+func MarshalLike(v any, reference []byte) ([]byte, error) {
+	if len(reference) == 0 {
+		return nil, errors.New("empty document is undefined")
+	}
+
+	dec := NewDecoder(bytes.NewReader(reference))
+	dec.parser.inlineVectors = make(map[uintptr]struct{})
+	var ref any
+	if err := dec.Decode(&ref); err != nil {
+		return nil, fmt.Errorf("huml: invalid reference: %w", err)
+	}
+	styles := make(map[string]vectorStyle)
+	collectStyles(ref, nil, dec.parser.inlineVectors, styles)
+
+	var buf bytes.Buffer
+	writeDirective(&buf, dec)
+	enc := NewEncoder(&buf)
+	enc.opts.styles = styles
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// collectStyles records in styles whether each non-empty vector below the
+// decoded value v is written inline, as recorded in inline by the parser, or
+// in block form, by its path from the root.
+func collectStyles(v any, path []string, inline map[uintptr]struct{}, styles map[string]vectorStyle) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map && rv.Kind() != reflect.Slice || rv.Len() == 0 {
+		return
+	}
+	if len(path) > 0 {
+		style := styleBlock
+		if _, ok := inline[rv.Pointer()]; ok {
+			style = styleInline
+		}
+		styles[strings.Join(path, "\x00")] = style
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			collectStyles(val, append(path, key), inline, styles)
+		}
+	case []any:
+		for i, val := range v {
+			collectStyles(val, append(path, strconv.Itoa(i)), inline, styles)
+		}
+	}
+}
+
+// collectLineComments records in comments the comment at the end of the line
+// of each dict entry below the decoded value v, as recorded in lineComments by
+// the parser, by its path from the root.
+func collectLineComments(v any, path []string, lineComments map[valueRef]string, comments map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		ptr := reflect.ValueOf(v).Pointer()
+		for key, val := range v {
+			p := append(path, key)
+			if comment, ok := lineComments[valueRef{ptr, key}]; ok {
+				comments[strings.Join(p, "\x00")] = comment
+			}
+			collectLineComments(val, p, lineComments, comments)
+		}
+	case []any:
+		for i, val := range v {
+			collectLineComments(val, append(path, strconv.Itoa(i)), lineComments, comments)
+		}
+	}
+}
+
+// QuoteValue returns s as a HUML string value, for building documents by hand.
+// A string without newlines is returned double-quoted and escaped, as in
+// "say \"hi\"". A string with newlines is returned as a multi-line string,
+// with its content indented two spaces deeper than indent, the indentation of
+// the line holding the key, and the closing delimiter indented by indent. The
+// result is meant to follow a "key: " or "- " on that line.
+func QuoteValue(s string, indent int) string {
+	if !strings.Contains(s, "\n") {
+		return quoteString(s)
+	}
+
+	var b strings.Builder
+	st := newState(&b, &encodeOpts{})
+	defer putState(st)
+	st.marshalString(s, max(indent, 0)+2)
+	return b.String()
+}
+
+// QuoteKey returns key as a HUML dict key. A key made of letters, digits,
+// underscores and hyphens that starts with a letter is returned as is, and
+// any other key is double-quoted and escaped.
+func QuoteKey(key string) string {
+	return quoteKeyIfNeeded(key)
+}
+
+// Quote returns v as a single-line HUML value, without the document
+// wrapper of Marshal, for use as a template function such as
+// template.FuncMap{"huml": huml.Quote}. A scalar is returned as it would
+// follow "key: ", so that a string is quoted and escaped. A list or dict of
+// scalars is returned in inline form, and an empty one as [] or {}, to follow
+// "key:: ". A string with newlines or a vector holding vectors has no
+// single-line form and is an error; use QuoteValue or Marshal for those.
+func Quote(v any) (string, error) {
+	var b strings.Builder
+	st := newState(&b, &encodeOpts{})
+	defer putState(st)
+
+	rv := st.resolve(reflect.ValueOf(v))
+	switch {
+	case st.err != nil:
+	case isVectorKind(rv.Kind()) && st.isEmptyVector(rv):
+		st.marshalValue(rv, 0)
+	case isVectorKind(rv.Kind()):
+		if !st.canInline(rv) {
+			if st.err == nil {
+				st.err = fmt.Errorf("huml: cannot quote %s with vectors or multi-line strings on a single line", rv.Type())
+			}
+			break
+		}
+		st.marshalInline(rv)
+	case rv.Kind() == reflect.String && strings.Contains(rv.String(), "\n"):
+		st.err = errors.New("huml: cannot quote a multi-line string on a single line")
+	default:
+		st.marshalValue(rv, 0)
+	}
+	if st.err != nil {
+		return "", st.err
+	}
+	return b.String(), nil
+}
+
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{w: w}
 }
 
+// SetKeyTransform sets a function that is applied to the Go names of struct
+// fields that have no name in their `huml` tag, for example to emit FieldName
+// as field-name or field_name. Explicit tag names are always used as is.
+// A nil function disables the transform.
+func (enc *Encoder) SetKeyTransform(fn func(string) string) {
+	enc.opts.keyTransform = fn
+}
+
+// SetTransformMapKeys controls whether the function set by SetKeyTransform is
+// also applied to the keys of maps. It is disabled by default.
+func (enc *Encoder) SetTransformMapKeys(on bool) {
+	enc.opts.transformMapKeys = on
+}
+
+// SetCompact controls whether nested vectors whose values are all single-line
+// scalars are written inline, as in `key:: 1, 2, 3`, `key:: a: 1, b: 2` or
+// `- :: x: 1, y: 2` for a list of small structs, rather than as indented
+// blocks. The root value is always written in block form. It is disabled by
+// default.
+func (enc *Encoder) SetCompact(on bool) {
+	enc.opts.compact = on
+}
+
+// SetMaxLineWidth makes a compact encoder write a vector in block form when
+// its inline form would make the line longer than n characters, counting
+// the indentation and the key, so that short vectors stay inline and long ones
+// become readable. Vectors tagged inline are always written inline. A value of
+// n <= 0, the default, removes the limit.
+func (enc *Encoder) SetMaxLineWidth(n int) {
+	enc.opts.maxLineWidth = max(n, 0)
+}
+
+// SetOmitNilMapValues controls whether map entries whose value is nil are
+// skipped instead of being written as `key: null`. A value counts as nil if it
+// is a nil interface, pointer, map or slice, or a non-nil interface holding
+// one, such as a (*T)(nil) stored in a map[string]any. It is disabled by default.
+func (enc *Encoder) SetOmitNilMapValues(on bool) {
+	enc.opts.omitNilMapValues = on
+}
+
+// SetPlainFloats makes the encoder write floats in plain decimal notation,
+// such as 0.0000001 or 1000000.0, instead of the shortest form which may use
+// an exponent (1e-07, 1e+06). Whole numbers keep a trailing ".0". Floats whose
+// plain form would be longer than maxLen characters, such as 1e+300, are
+// still written with an exponent. Either way, the output parses back to the
+// same value. A maxLen of 0 restores the default shortest form.
+func (enc *Encoder) SetPlainFloats(maxLen int) {
+	enc.opts.plainFloatLen = max(maxLen, 0)
+}
+
+// SetBaseIndent makes the encoder indent every line of its output by n
+// spaces, for splicing the output into a document as the value of a key
+// indented by n-2 spaces. A value of n <= 0 restores the default of no
+// indentation.
+func (enc *Encoder) SetBaseIndent(n int) {
+	enc.opts.baseIndent = strings.Repeat(" ", max(n, 0))
+}
+
+// SetJSONFallback controls whether values that implement json.Marshaler are
+// encoded as the HUML equivalent of the JSON they produce, so that types
+// written for encoding/json can be used as is. JSON objects become dicts,
+// arrays become lists, and numbers become integers if they have no fraction
+// or exponent, and floats otherwise. It is disabled by default, in which case
+// such values are encoded like any other value of their kind.
+func (enc *Encoder) SetJSONFallback(on bool) {
+	enc.opts.jsonFallback = on
+}
+
+// SetUnicodeKeys controls whether keys containing Unicode letters, such as
+// café, are written bare rather than quoted. The output can then only be read
+// by a Decoder with AllowUnicodeKeys. It is disabled by default.
+func (enc *Encoder) SetUnicodeKeys(on bool) {
+	enc.opts.unicodeKeys = on
+}
+
+// SetDottedKeys controls whether a chain of dicts with a single entry each is
+// written as one dotted key, so that
+//
+//	a::
+//	  b::
+//	    c: 1
+//
+// is written as `a.b.c: 1`. Only keys that can be written bare are joined, so
+// a key containing a dot is still quoted and never mistaken for a chain. The
+// output can only be read by a Decoder with ExpandDottedKeys. It is disabled
+// by default.
+func (enc *Encoder) SetDottedKeys(on bool) {
+	enc.opts.dottedKeys = on
+}
+
+// SetEnumNames controls whether values of integer types that implement
+// fmt.Stringer, such as an iota enum, are written as the quoted string
+// returned by their String method rather than as numbers, both as values and
+// as map keys. A Decoder with RegisterEnum maps the names back. It is disabled
+// by default, as other integer types such as time.Duration are Stringers too.
+func (enc *Encoder) SetEnumNames(on bool) {
+	enc.opts.enumNames = on
+}
+
+// SetErrorStrings controls whether values that implement error, such as the
+// result of errors.New, are written as the quoted string returned by their
+// Error method, which is handy when logging structs that hold errors. A value
+// that implements encoding.TextMarshaler is still written as its text. It is
+// disabled by default, as the message can't be decoded back into the error.
+func (enc *Encoder) SetErrorStrings(on bool) {
+	enc.opts.errorStrings = on
+}
+
+// SetHeader sets comment lines, such as a license notice, that are written
+// as a block at the start of each document, each prefixed with "# ", and
+// separated from the content by a blank line. A Decoder returns them from
+// Header. Each line must be a single line. A nil slice, the default, writes
+// no header.
+func (enc *Encoder) SetHeader(lines []string) {
+	enc.opts.header = lines
+}
+
 // Encode writes the HUML encoding of v to the stream, followed by a newline.
 // See the documentation for Marshal for details about the conversion of Go
 // values to HUML.
 func (enc *Encoder) Encode(v any) error {
-	s := newState(enc.w)
+	s := newState(enc.w, &enc.opts)
+	s.writeHeader()
 	s.marshalValue(reflect.ValueOf(v), 0)
 	if s.err == nil {
 		// Ensure the document ends with a newline for POSIX compatibility.
@@ -97,10 +498,34 @@ func (enc *Encoder) Encode(v any) error {
 	return err
 }
 
+// writeHeader writes the header comment block, if any, and the blank line
+// after it.
+func (s *state) writeHeader() {
+	if len(s.opts.header) == 0 {
+		return
+	}
+	for _, line := range s.opts.header {
+		if strings.ContainsAny(line, "\r\n") {
+			s.err = fmt.Errorf("huml: header line %q must be a single line", line)
+			return
+		}
+		if line == "" {
+			s.write("#\n")
+		} else {
+			s.write("# " + line + "\n")
+		}
+	}
+	s.write("\n")
+}
+
 // newState retrieves a new state from the pool.
-func newState(w io.Writer) *state {
+func newState(w io.Writer, opts *encodeOpts) *state {
 	s := statePool.Get().(*state)
 	s.w = w
+	s.opts = opts
+	s.midLine = false
+	s.col = 0
+	s.path = s.path[:0]
 	return s
 }
 
@@ -108,6 +533,7 @@ func newState(w io.Writer) *state {
 func putState(s *state) {
 	s.w = nil
 	s.err = nil
+	s.opts = nil
 	statePool.Put(s)
 }
 
@@ -117,9 +543,40 @@ func (s *state) write(str string) {
 	if s.err != nil {
 		return
 	}
+	if s.opts.maxLineWidth > 0 {
+		if i := strings.LastIndexByte(str, '\n'); i >= 0 {
+			s.col = utf8.RuneCountInString(str[i+1:])
+		} else {
+			s.col += utf8.RuneCountInString(str)
+		}
+	}
+	if s.opts.baseIndent != "" {
+		s.writeIndented(str)
+		return
+	}
 	_, s.err = io.WriteString(s.w, str)
 }
 
+// writeIndented writes str, prefixing each line that has content with the
+// base indentation.
+func (s *state) writeIndented(str string) {
+	for str != "" && s.err == nil {
+		line, rest, found := strings.Cut(str, "\n")
+		if line != "" && !s.midLine {
+			if _, s.err = io.WriteString(s.w, s.opts.baseIndent); s.err != nil {
+				return
+			}
+			s.midLine = true
+		}
+		if found {
+			line += "\n"
+			s.midLine = false
+		}
+		_, s.err = io.WriteString(s.w, line)
+		str = rest
+	}
+}
+
 // marshalValue is the primary recursive function that dispatches to the
 // appropriate marshalling function based on the value's kind.
 func (s *state) marshalValue(v reflect.Value, indent int) {
@@ -129,7 +586,7 @@ func (s *state) marshalValue(v reflect.Value, indent int) {
 
 	// Follow pointers and interfaces to find the concrete value.
 	// If we encounter a nil pointer along the way, it represents a null value.
-	v = indirect(v, &s.err)
+	v = s.resolve(v)
 	if s.err != nil {
 		return
 	}
@@ -140,6 +597,16 @@ func (s *state) marshalValue(v reflect.Value, indent int) {
 		return
 	}
 
+	// A Number is written as is, rather than as a string.
+	if v.Type() == numberType {
+		if !Number(v.String()).valid() {
+			s.err = fmt.Errorf("huml: invalid number %q", v.String())
+			return
+		}
+		s.write(v.String())
+		return
+	}
+
 	switch v.Kind() {
 	case reflect.Map:
 		s.marshalMap(v, indent)
@@ -154,17 +621,7 @@ func (s *state) marshalValue(v reflect.Value, indent int) {
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		s.write(strconv.FormatUint(v.Uint(), 10))
 	case reflect.Float32, reflect.Float64:
-		f := v.Float()
-		if math.IsNaN(f) {
-			s.write("nan")
-		} else if math.IsInf(f, 1) {
-			s.write("inf")
-		} else if math.IsInf(f, -1) {
-			s.write("-inf")
-		} else {
-			// 'g' format is used for the most compact representation.
-			s.write(strconv.FormatFloat(f, 'g', -1, 64))
-		}
+		s.marshalFloat(v.Float())
 	case reflect.Bool:
 		s.write(strconv.FormatBool(v.Bool()))
 	default:
@@ -173,36 +630,231 @@ func (s *state) marshalValue(v reflect.Value, indent int) {
 	}
 }
 
+// marshalFloat writes a float, including the special values nan and inf.
+// Each special value has a single canonical form: positive infinity is always
+// written as inf, never +inf, and every NaN is written as nan regardless of its
+// sign bit or payload, as HUML has no -nan.
+func (s *state) marshalFloat(f float64) {
+	if s.opts.plainFloatLen == 0 || math.IsNaN(f) || math.IsInf(f, 0) || (f == 0 && math.Signbit(f)) {
+		s.write(formatFloat(f))
+		return
+	}
+
+	// The shortest plain decimal that parses back to the same value.
+	str := strconv.FormatFloat(f, 'f', -1, 64)
+	if len(str) > s.opts.plainFloatLen {
+		s.write(strconv.FormatFloat(f, 'g', -1, 64))
+		return
+	}
+	s.write(str)
+	// Keep whole numbers recognisable as floats.
+	if !strings.Contains(str, ".") {
+		s.write(".0")
+	}
+}
+
+// formatFloat returns the HUML text of f in its most compact form.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == 0 && math.Signbit(f):
+		// "-0" would decode as the integer 0, losing the sign.
+		return "-0.0"
+	}
+	// 'g' format is used for the most compact representation.
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
 // marshalMap converts a Go map into a HUML multi-line dictionary.
 func (s *state) marshalMap(v reflect.Value, indent int) {
-	// An empty map is represented by the special empty dict marker.
+	s.writeDict(s.mapEntries(v), indent)
+}
+
+// dictEntry is a key-value pair of a map or struct being encoded.
+type dictEntry struct {
+	key   string
+	value reflect.Value
+	style vectorStyle
+	doc   string // Comment line to write above the entry.
+
+	dotted bool // Key joins bare keys with dots, and is written unquoted.
+}
+
+// vectorStyle selects how a vector value is written, as requested by the
+// `inline` and `block` struct tag options.
+type vectorStyle int
+
+const (
+	styleDefault vectorStyle = iota // Decided by the encoder options.
+	styleInline                     // Always on the line of the key.
+	styleBlock                      // Always as an indented block.
+)
+
+// dictEntries returns the entries of the map or struct v in output order.
+func (s *state) dictEntries(v reflect.Value) []dictEntry {
+	if v.Kind() == reflect.Map {
+		return s.mapEntries(v)
+	}
+	return s.structEntries(v)
+}
+
+// mapEntries returns the entries of the map v sorted by key.
+func (s *state) mapEntries(v reflect.Value) []dictEntry {
 	if v.Len() == 0 {
-		s.write("{}")
-		return
+		return nil
 	}
 
-	// The HUML spec requires string keys for dictionaries.
-	if v.Type().Key().Kind() != reflect.String {
-		s.err = fmt.Errorf("huml: map key type must be a string, not %s", v.Type().Key())
-		return
+	// The HUML spec requires string keys for dictionaries, so other keys are
+	// written as their text.
+	if !isMapKeyType(v.Type().Key()) {
+		s.err = fmt.Errorf("huml: map key type must be a string, an integer or implement encoding.TextMarshaler, not %s", v.Type().Key())
+		return nil
 	}
 
 	// Sort map keys to ensure the output is deterministic. This is crucial
 	// for consistency in tests, version control, and other automated processing.
-	keys := v.MapKeys()
+	type keyName struct {
+		key  reflect.Value
+		name string
+	}
+	keys := make([]keyName, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		name, err := s.mapKeyString(key)
+		if err != nil {
+			s.err = fmt.Errorf("huml: error encoding map key: %w", err)
+			return nil
+		}
+		keys = append(keys, keyName{key, name})
+	}
 	sort.Slice(keys, func(i, j int) bool {
-		return keys[i].String() < keys[j].String()
+		return keys[i].name < keys[j].name
 	})
 
-	for i, key := range keys {
+	entries := make([]dictEntry, 0, len(keys))
+	for _, k := range keys {
+		val := v.MapIndex(k.key)
+		if s.opts.omitNilMapValues && !s.resolve(val).IsValid() {
+			if s.err != nil {
+				return nil
+			}
+			continue
+		}
+
+		name := k.name
+		if s.opts.transformMapKeys && s.opts.keyTransform != nil {
+			name = s.opts.keyTransform(name)
+		}
+		entries = append(entries, dictEntry{key: name, value: val})
+	}
+
+	return entries
+}
+
+// toTimeFormat formats the time v with the layout of the field f. A nil
+// pointer, which indirect turns into an invalid value, stays null.
+func toTimeFormat(f structField, v reflect.Value) (reflect.Value, error) {
+	if !v.IsValid() {
+		return v, nil
+	}
+	if v.Type() != timeType {
+		return reflect.Value{}, fmt.Errorf("huml: field %s tagged timeformat must be a time.Time, not %s", f.name, v.Type())
+	}
+	return reflect.ValueOf(v.Interface().(time.Time).Format(f.timeFmt)), nil
+}
+
+// mapKeyString returns the dict key for the map key k.
+func (s *state) mapKeyString(k reflect.Value) (string, error) {
+	if name, ok := s.enumName(k); ok {
+		return name, nil
+	}
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+
+	switch k.Kind() {
+	case reflect.String:
+		return k.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	default:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+}
+
+// writeDict writes the entries of a dictionary as key-value pairs on
+// separate lines. An empty dictionary is written as the empty dict marker.
+func (s *state) writeDict(entries []dictEntry, indent int) {
+	if s.err != nil {
+		return
+	}
+
+	if len(entries) == 0 {
+		s.write("{}")
+		return
+	}
+
+	for i, e := range entries {
 		// Separate key-value pairs with a newline.
 		if i > 0 {
 			s.write("\n")
 		}
+		if e.doc != "" {
+			s.writeComment(e.key, e.doc, indent)
+		}
+		if s.opts.dottedKeys {
+			e = s.dottedEntry(e)
+		}
+		if e.dotted {
+			s.write(strings.Repeat(" ", indent) + e.key)
+			s.writeValue(e.key, e.value, e.style, indent)
+			continue
+		}
+		if s.opts.tracksPath() {
+			s.path = append(s.path, e.key)
+		}
+		s.writeKVPair(e.key, e.value, e.style, indent)
+		s.writeLineComment(e.value)
+		if s.opts.tracksPath() {
+			s.path = s.path[:len(s.path)-1]
+		}
+	}
+}
 
-		val := v.MapIndex(key)
-		s.writeKVPair(key.String(), val, indent)
+// writeLineComment writes the comment recorded for the current path at the
+// end of the line of the dict entry just written, if its value fits on that
+// line.
+func (s *state) writeLineComment(val reflect.Value) {
+	comment, ok := s.opts.lineComments[strings.Join(s.path, "\x00")]
+	if !ok {
+		return
+	}
+	v := s.resolve(val)
+	if !v.IsValid() || !isVectorKind(v.Kind()) && (v.Kind() != reflect.String || !strings.Contains(v.String(), "\n")) {
+		s.write(" # " + comment)
+	}
+}
+
+// writeComment writes doc as a comment line above the entry with the given key.
+func (s *state) writeComment(key, doc string, indent int) {
+	if strings.ContainsAny(doc, "\r\n") {
+		if s.err == nil {
+			s.err = fmt.Errorf("huml: comment of field %s must be a single line", key)
+		}
+		return
 	}
+	s.write(strings.Repeat(" ", indent))
+	s.write("# ")
+	s.write(doc)
+	s.write("\n")
 }
 
 // parseStructTag parses a struct tag and returns the field name and options.
@@ -210,35 +862,198 @@ func (s *state) marshalMap(v reflect.Value, indent int) {
 //
 // Returns:
 //   - name: the field name to use (or "-" if the field should be skipped)
-//   - omitempty: whether the omitempty option is set
+//   - opts: the options following the name, such as omitempty
 //
 // Golang concept: Struct tags are string literals attached to struct fields.
 // They're accessed via reflect.StructTag.Get("tagname"). The format is typically
 // "value" or "value,option1,option2". We parse this to extract the name and options.
-func parseStructTag(tag reflect.StructTag) (name string, omitempty bool) {
+func parseStructTag(tag reflect.StructTag) (name string, opts tagOptions) {
 	tagValue := tag.Get("huml")
 	if tagValue == "" {
-		return "", false
+		return "", nil
 	}
 
 	// Handle the skip marker "-".
 	if tagValue == "-" {
-		return "-", false
+		return "-", nil
 	}
 
 	// Split by comma to separate name from options.
 	parts := strings.Split(tagValue, ",")
 	name = parts[0]
 
-	// Check for omitempty option in the remaining parts.
 	for i := 1; i < len(parts); i++ {
-		option := strings.TrimSpace(parts[i])
-		if option == "omitempty" {
-			omitempty = true
+		// A comment is free text that may contain commas, so it takes
+		// up the rest of the tag.
+		if opt := strings.TrimSpace(parts[i]); strings.HasPrefix(opt, "comment=") {
+			opts = append(opts, strings.TrimSpace(strings.Join(parts[i:], ",")))
+			break
+		}
+		opts = append(opts, strings.TrimSpace(parts[i]))
+	}
+
+	return name, opts
+}
+
+// tagOptions holds the options that follow the name in a `huml` struct tag.
+// An option is either a flag, such as omitempty, or a key=value pair.
+type tagOptions []string
+
+// value returns the value of the key=value option with the given key.
+func (o tagOptions) value(key string) (string, bool) {
+	for _, opt := range o {
+		if v, ok := strings.CutPrefix(opt, key+"="); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// has reports whether the flag is set in the options.
+func (o tagOptions) has(flag string) bool {
+	for _, opt := range o {
+		if opt == flag {
+			return true
 		}
 	}
+	return false
+}
 
-	return name, omitempty
+// tagDoc returns the text of the comment=text option.
+func tagDoc(opts tagOptions) string {
+	doc, _ := opts.value("comment")
+	return doc
+}
+
+// tagAliases returns the names in the humlalt tag, which lists former names
+// of a field, separated by commas.
+func tagAliases(tag reflect.StructTag) []string {
+	alt := tag.Get("humlalt")
+	if alt == "" {
+		return nil
+	}
+	return strings.Split(alt, ",")
+}
+
+// tagUnit returns the unit of the unit=name option.
+func tagUnit(opts tagOptions) string {
+	unit, _ := opts.value("unit")
+	return unit
+}
+
+// tagTimeFormat returns the layout of the timeformat=layout option.
+func tagTimeFormat(opts tagOptions) string {
+	layout, _ := opts.value("timeformat")
+	return layout
+}
+
+// tagPosition returns the index of the pos=n option, and whether it is set.
+// An index that is not a non-negative integer is returned as -1.
+func tagPosition(opts tagOptions) (int, bool) {
+	v, ok := opts.value("pos")
+	if !ok {
+		return 0, false
+	}
+	pos, err := strconv.Atoi(v)
+	if err != nil || pos < 0 {
+		return -1, true
+	}
+	return pos, true
+}
+
+// tagStyle returns the vector style requested by the inline or block option.
+func tagStyle(opts tagOptions) vectorStyle {
+	switch {
+	case opts.has("inline"):
+		return styleInline
+	case opts.has("block"):
+		return styleBlock
+	}
+	return styleDefault
+}
+
+// Types with special handling, as their fields are unexported.
+var (
+	timeType  = reflect.TypeFor[time.Time]()
+	ratType   = reflect.TypeFor[big.Rat]()
+	valueType = reflect.TypeFor[reflect.Value]()
+
+	jsonMarshalerType   = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	stringerType        = reflect.TypeFor[fmt.Stringer]()
+	errorType           = reflect.TypeFor[error]()
+)
+
+// omitKinds is a set of kinds of values for the omitempty=kinds tag option.
+type omitKinds uint8
+
+const (
+	omitString omitKinds = 1 << iota
+	omitNumber
+	omitBool
+	omitNil
+	omitVector
+	omitStruct
+)
+
+// omitKindNames maps the names used in omitempty=kinds to kinds.
+var omitKindNames = map[string]omitKinds{
+	"string": omitString,
+	"number": omitNumber,
+	"bool":   omitBool,
+	"nil":    omitNil,
+	"vector": omitVector,
+	"struct": omitStruct,
+}
+
+// tagOmitKinds returns the kinds of the omitempty=kinds option, where kinds
+// are separated by "|", and the first unknown kind, if any.
+func tagOmitKinds(opts tagOptions) (omitKinds, string) {
+	spec, ok := opts.value("omitempty")
+	if !ok {
+		return 0, ""
+	}
+	var kinds omitKinds
+	for _, name := range strings.Split(spec, "|") {
+		kind, ok := omitKindNames[name]
+		if !ok {
+			return 0, name
+		}
+		kinds |= kind
+	}
+	return kinds, ""
+}
+
+// emptyKind returns the kind of v for the omitempty=kinds option, looking
+// through pointers and interfaces, and whether it is empty as defined by
+// isEmptyValue.
+func emptyKind(v reflect.Value) (omitKinds, bool) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return omitNil, true
+		}
+		v = v.Elem()
+	}
+
+	var kind omitKinds
+	switch v.Kind() {
+	case reflect.String:
+		kind = omitString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		kind = omitNumber
+	case reflect.Bool:
+		kind = omitBool
+	case reflect.Array, reflect.Map, reflect.Slice:
+		kind = omitVector
+	case reflect.Struct:
+		kind = omitStruct
+	default:
+		return 0, false
+	}
+	return kind, isEmptyValue(v)
 }
 
 // isEmptyValue checks if a reflect.Value represents an "empty" value.
@@ -262,6 +1077,22 @@ func isEmptyValue(v reflect.Value) bool {
 	case reflect.Interface, reflect.Ptr:
 		return v.IsNil()
 	case reflect.Struct:
+		// A time.Time has no exported fields, so it is empty only if it
+		// is the zero time.
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+		if v.Type() == ratType {
+			r := v.Interface().(big.Rat)
+			return r.Sign() == 0
+		}
+
+		// A struct with a text form, such as a netip.Addr, is written as its
+		// text rather than its fields, which are typically all unexported.
+		if v.Type().Implements(textMarshalerType) || reflect.PointerTo(v.Type()).Implements(textMarshalerType) {
+			return v.IsZero()
+		}
+
 		// For structs, check if all exported fields are empty.
 		// We only check exported fields since unexported fields
 		// can't be marshalled anyway.
@@ -279,58 +1110,350 @@ func isEmptyValue(v reflect.Value) bool {
 	}
 }
 
-// marshalStruct converts a Go struct into a HUML multi-line dictionary.
-func (s *state) marshalStruct(v reflect.Value, indent int) {
-	var fields []struct {
-		name  string
-		value reflect.Value
+// structField describes a struct field that takes part in encoding and
+// decoding, including fields promoted from anonymous embedded structs.
+type structField struct {
+	name      string
+	index     []int // Index sequence for reflect.Value.FieldByIndex.
+	tagged    bool  // True if the name came from a `huml` tag.
+	omitempty bool
+	omitKinds omitKinds   // Kinds of empty values skipped by omitempty=kinds.
+	badOmit   string      // Unknown kind in omitempty=kinds, reported when marshalling.
+	comment   bool        // Receives the comment of the dict instead of a key.
+	document  bool        // Receives the whole dict instead of a key.
+	style     vectorStyle // Forced style of a vector value.
+	doc       string      // Comment written above the key when marshalling.
+	sort      bool        // Write a slice of scalars in sorted order.
+	unit      string      // Unit of a number in the document, such as "s" or "MB".
+	timeFmt   string      // Layout of a time.Time in the document, as for time.Format.
+	aliases   []string    // Former names accepted when decoding, from the humlalt tag.
+	scalar    bool        // Stands for the whole struct, which is written as its value.
+	pos       int         // Index of the field in a list decoded into the struct.
+	hasPos    bool        // True if the field is tagged with pos=n.
+}
+
+// fieldCache caches the structFields of a type, keyed by reflect.Type.
+var fieldCache sync.Map
+
+// cachedTypeFields is like typeFields but uses a cache to avoid repeated work.
+func cachedTypeFields(t reflect.Type) []structField {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]structField)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]structField)
+}
+
+// typeFields returns the fields of struct type t that should be encoded or
+// decoded. It follows the same rules as encoding/json for anonymous fields:
+//   - An untagged embedded struct (or pointer to struct) has its fields
+//     flattened into the parent, as if they were declared there.
+//   - An embedded non-struct type, or an embedded struct with a name in its
+//     `huml` tag, is treated as a regular field keyed by its type name (or tag).
+//   - When several fields resolve to the same name, the least nested one wins.
+//     Among fields at the same depth, a tagged field wins; otherwise all of
+//     them are dropped as ambiguous.
+func typeFields(t reflect.Type) []structField {
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var (
+		fields  []structField
+		current []queued
+		next    = []queued{{typ: t}}
+		visited = map[reflect.Type]bool{}
+	)
+
+	for len(next) > 0 {
+		current, next = next, nil
+
+		// Fields found at this depth, grouped by name.
+		var (
+			names = map[string][]structField{}
+			order []string
+		)
+
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+
+			for i := 0; i < q.typ.NumField(); i++ {
+				sf := q.typ.Field(i)
+
+				ft := sf.Type
+				if ft.Kind() == reflect.Pointer && ft.Name() == "" {
+					ft = ft.Elem()
+				}
+
+				if sf.Anonymous {
+					// Unexported embedded non-structs can never be set.
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				name, opts := parseStructTag(sf.Tag)
+				if name == "-" {
+					continue
+				}
+
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = i
+
+				// Flatten untagged embedded structs at the next depth.
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					next = append(next, queued{typ: ft, index: index})
+					continue
+				}
+
+				// Embedded unexported structs with a name tag can't be set.
+				if !sf.IsExported() {
+					continue
+				}
+
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+				}
+				if _, ok := names[name]; !ok {
+					order = append(order, name)
+				}
+				omitKinds, badOmit := tagOmitKinds(opts)
+				pos, hasPos := tagPosition(opts)
+				names[name] = append(names[name], structField{
+					name:      name,
+					index:     index,
+					tagged:    tagged,
+					omitempty: opts.has("omitempty"),
+					omitKinds: omitKinds,
+					badOmit:   badOmit,
+					comment:   opts.has("comment"),
+					document:  opts.has("document"),
+					scalar:    opts.has("scalar"),
+					style:     tagStyle(opts),
+					doc:       tagDoc(opts),
+					sort:      opts.has("sort"),
+					unit:      tagUnit(opts),
+					timeFmt:   tagTimeFormat(opts),
+					aliases:   tagAliases(sf.Tag),
+					pos:       pos,
+					hasPos:    hasPos,
+				})
+			}
+		}
+
+		for _, name := range order {
+			// A name already claimed at a shallower depth dominates.
+			if hasField(fields, name) {
+				continue
+			}
+			if f, ok := dominantField(names[name]); ok {
+				fields = append(fields, f)
+			} else {
+				// Mark the name as taken so deeper fields don't resurface.
+				fields = append(fields, structField{name: name})
+			}
+		}
+	}
+
+	// Drop the placeholders left by ambiguous names and restore the
+	// declaration order, which is the order of the index sequences.
+	out := fields[:0]
+	for _, f := range fields {
+		if f.index != nil {
+			out = append(out, f)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i].index, out[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	return out
+}
+
+// hasField reports whether fields contains a field with the given name.
+func hasField(fields []structField, name string) bool {
+	for _, f := range fields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dominantField picks the field that wins among fields with the same name at
+// the same depth. It returns false if the choice is ambiguous.
+func dominantField(fields []structField) (structField, bool) {
+	if len(fields) == 1 {
+		return fields[0], true
 	}
 
-	// Iterate over the struct fields to gather exported fields and their names.
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Type().Field(i)
-		// Skip unexported fields as they are not accessible.
-		if !field.IsExported() {
+	var (
+		out   structField
+		found bool
+	)
+	for _, f := range fields {
+		if !f.tagged {
 			continue
 		}
+		if found {
+			return structField{}, false
+		}
+		out, found = f, true
+	}
+	return out, found
+}
 
-		// Parse the `huml` tag to determine the field name and options.
-		fieldName, omitempty := parseStructTag(field.Tag)
-		if fieldName == "-" {
+// scalarField returns the field of struct type t tagged scalar, if any, and
+// an error if there are several.
+func scalarField(t reflect.Type) (structField, bool, error) {
+	var found structField
+	for _, f := range cachedTypeFields(t) {
+		if !f.scalar {
 			continue
 		}
-		if fieldName == "" {
-			fieldName = field.Name
+		if found.scalar {
+			return found, false, fmt.Errorf("struct %s has more than one scalar field: %s and %s", t, found.name, f.name)
 		}
+		found = f
+	}
+	return found, found.scalar, nil
+}
 
-		fieldValue := v.Field(i)
+// fieldByIndex returns the nested field of v at the given index sequence.
+// It returns false if the path goes through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// marshalStruct converts a Go struct into a HUML multi-line dictionary.
+func (s *state) marshalStruct(v reflect.Value, indent int) {
+	s.writeDict(s.structEntries(v), indent)
+}
+
+// structEntries returns the fields of the struct v to be written, including
+// those promoted from embedded structs.
+func (s *state) structEntries(v reflect.Value) []dictEntry {
+	var entries []dictEntry
+	for _, f := range cachedTypeFields(v.Type()) {
+		// Comment and document fields only capture input when decoding.
+		if f.comment || f.document {
+			continue
+		}
+
+		if f.badOmit != "" {
+			s.err = fmt.Errorf("huml: field %s has unknown kind %q in omitempty", f.name, f.badOmit)
+			return nil
+		}
+
+		fieldValue, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue
+		}
 
 		// If omitempty is set and the value is empty, skip this field.
-		if omitempty && isEmptyValue(fieldValue) {
+		if f.omitempty && isEmptyValue(fieldValue) {
 			continue
 		}
+		if f.omitKinds != 0 {
+			if kind, empty := emptyKind(fieldValue); empty && f.omitKinds&kind != 0 {
+				continue
+			}
+		}
+
+		if f.sort {
+			fieldValue = s.sortedSlice(f.name, fieldValue)
+			if s.err != nil {
+				return nil
+			}
+		}
+
+		if f.unit != "" {
+			if v := indirect(fieldValue, &s.err); v.IsValid() {
+				fieldValue, s.err = toUnit(f, v)
+			}
+			if s.err != nil {
+				return nil
+			}
+		}
+
+		if f.timeFmt != "" {
+			if fieldValue, s.err = toTimeFormat(f, indirect(fieldValue, &s.err)); s.err != nil {
+				return nil
+			}
+		}
+
+		// Explicitly tagged names take precedence over the key transform.
+		name := f.name
+		if !f.tagged && s.opts.keyTransform != nil {
+			name = s.opts.keyTransform(name)
+		}
 
-		fields = append(fields, struct {
-			name  string
-			value reflect.Value
-		}{
-			name:  fieldName,
-			value: fieldValue,
-		})
+		entries = append(entries, dictEntry{key: name, value: fieldValue, style: f.style, doc: f.doc})
 	}
 
-	// An empty struct (or one with no exported fields) is an empty dict.
-	if len(fields) == 0 {
-		s.write("{}")
-		return
+	return entries
+}
+
+// sortedSlice returns a sorted copy of the slice or array v of the field
+// with the given name, for the sort tag option. The elements must be of an
+// ordered scalar type. NaNs sort first, as with slices.Sort.
+func (s *state) sortedSlice(name string, v reflect.Value) reflect.Value {
+	v = indirect(v, &s.err)
+	if !v.IsValid() || (v.Kind() == reflect.Slice && v.IsNil()) {
+		return v
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		s.err = fmt.Errorf("huml: field %s tagged sort must be a slice or array, not %s", name, v.Type())
+		return reflect.Value{}
 	}
 
-	for i, field := range fields {
-		if i > 0 {
-			s.write("\n")
+	var less func(a, b reflect.Value) bool
+	switch v.Type().Elem().Kind() {
+	case reflect.String:
+		less = func(a, b reflect.Value) bool { return a.String() < b.String() }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less = func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		less = func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case reflect.Float32, reflect.Float64:
+		less = func(a, b reflect.Value) bool {
+			x, y := a.Float(), b.Float()
+			return x < y || (math.IsNaN(x) && !math.IsNaN(y))
 		}
-		s.writeKVPair(field.name, field.value, indent)
+	default:
+		s.err = fmt.Errorf("huml: field %s tagged sort has unsortable elements of type %s", name, v.Type().Elem())
+		return reflect.Value{}
 	}
+
+	sorted := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), v.Len(), v.Len())
+	reflect.Copy(sorted, v)
+	sort.SliceStable(sorted.Interface(), func(i, j int) bool {
+		return less(sorted.Index(i), sorted.Index(j))
+	})
+	return sorted
 }
 
 // marshalSlice converts a Go slice or array into a HUML multi-line list.
@@ -352,17 +1475,20 @@ func (s *state) marshalSlice(v reflect.Value, indent int) {
 
 		// Determine if the list element is a scalar or a vector.
 		// This is necessary to decide between `- value` and `- ::\n  ...`.
-		elemKind := indirect(elem, &s.err).Kind()
+		elem = s.resolve(elem)
 		if s.err != nil {
 			return
 		}
 
-		isVector := elemKind == reflect.Map || elemKind == reflect.Struct || elemKind == reflect.Slice || elemKind == reflect.Array
-
-		if isVector {
-			// A vector within a list is denoted by `::` and must start on a new line.
-			s.write("::\n")
-			s.marshalValue(elem, indent+2)
+		if isVectorKind(elem.Kind()) {
+			// A vector within a list is denoted by `::`.
+			if s.opts.tracksPath() {
+				s.path = append(s.path, strconv.Itoa(i))
+			}
+			s.writeVector(elem, styleDefault, indent+2)
+			if s.opts.tracksPath() {
+				s.path = s.path[:len(s.path)-1]
+			}
 		} else {
 			// A scalar within a list is written on the same line.
 			s.marshalValue(elem, indent)
@@ -373,16 +1499,20 @@ func (s *state) marshalSlice(v reflect.Value, indent int) {
 // marshalString handles both single-line and multi-line strings.
 func (s *state) marshalString(str string, indent int) {
 	// If a string contains a newline, it must be formatted as a multi-line string.
-	// We use """ to preserve all whitespace as per the spec.
+	// We use """ to preserve all whitespace as per the spec, or a longer fence
+	// if a line of the content starts with """.
 	if strings.Contains(str, "\n") {
 		// The `indent` passed here is the indentation for the value, which is key_indent + 2.
 		// The content of the multi-line string must be at key_indent + 2.
-		// The closing delimiter must be at key_indent.
-		keyIndent := indent - 2
-		contentIndent := indent
+		// The closing delimiter must be at key_indent. A root string has no key,
+		// so it is written with indent 0 and its delimiters are not indented.
+		keyIndent := max(indent-2, 0)
+		contentIndent := keyIndent + 2
 
-		s.write("\"\"\"\n")
 		lines := strings.Split(str, "\n")
+		fence := multilineFence(lines)
+		s.write(fence)
+		s.write("\n")
 		// The last line of a multi-line string from split can be empty if the string ends with a newline.
 		// We trim this to avoid an extra trailing newline inside the HUML block.
 		if len(lines) > 0 && lines[len(lines)-1] == "" {
@@ -394,68 +1524,252 @@ func (s *state) marshalString(str string, indent int) {
 			s.write("\n")
 		}
 		s.write(strings.Repeat(" ", keyIndent))
-		s.write("\"\"\"")
+		s.write(fence)
 	} else {
-		// Standard Go quoting handles all necessary escapes for a valid HUML string.
-		s.write(strconv.Quote(str))
+		// Single-line strings are always quoted, so that strings such as "true",
+		// "123" or "nan" are never mistaken for values of another type.
+		s.write(quoteString(str))
 	}
 }
 
-// isStructEmpty checks if a struct has any marshallable fields.
-func (s *state) isStructEmpty(v reflect.Value) bool {
-	// This assumes 'v' is an indirected value of kind Struct.
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Type().Field(i)
-		// A field is marshallable if it's exported and not tagged with "-".
-		if field.IsExported() && field.Tag.Get("huml") != "-" {
-			return false
+// multilineFence returns the delimiter for a multi-line string with the given
+// lines. It is """ unless a line starts with three or more quotes after its
+// indentation, in which case it is one quote longer than the longest such run
+// so that the line cannot close the string.
+func multilineFence(lines []string) string {
+	n := 3
+	for _, line := range lines {
+		line = strings.TrimLeft(line, " ")
+		run := len(line) - len(strings.TrimLeft(line, `"`))
+		n = max(n, run+1)
+	}
+	return strings.Repeat(`"`, n)
+}
+
+// quoteString returns str as a double-quoted HUML string. Unlike strconv.Quote,
+// it only uses escapes that HUML understands: control characters without a
+// short escape are written as \u00XX rather than \xXX.
+func quoteString(str string) string {
+	var b strings.Builder
+	b.Grow(len(str) + 2)
+	b.WriteByte('"')
+	for _, r := range str {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
 		}
 	}
-	return true
+	b.WriteByte('"')
+	return b.String()
+}
+
+// dottedEntry joins the key of e with the keys of the chain of dicts with a
+// single entry below it, for SetDottedKeys. The chain stops at a key that
+// must be quoted or an entry with a comment.
+func (s *state) dottedEntry(e dictEntry) dictEntry {
+	if s.quoteKey(e.key) != e.key {
+		return e
+	}
+	for s.err == nil {
+		v := s.resolve(e.value)
+		if v.Kind() != reflect.Map && v.Kind() != reflect.Struct {
+			break
+		}
+		entries := s.dictEntries(v)
+		if len(entries) != 1 || entries[0].doc != "" || s.quoteKey(entries[0].key) != entries[0].key {
+			break
+		}
+		sub := entries[0]
+		sub.key = e.key + "." + sub.key
+		sub.doc = e.doc
+		sub.dotted = true
+		e = sub
+	}
+	return e
 }
 
 // writeKVPair writes a complete key-value pair, including indentation, the key,
 // the correct indicator (':' or '::'), and the marshalled value.
-func (s *state) writeKVPair(key string, val reflect.Value, indent int) {
+func (s *state) writeKVPair(key string, val reflect.Value, style vectorStyle, indent int) {
 	s.write(strings.Repeat(" ", indent))
-	s.write(quoteKeyIfNeeded(key))
+	s.write(s.quoteKey(key))
+	s.writeValue(key, val, style, indent)
+}
 
+// writeValue writes the indicator and value of a key-value pair whose key has
+// been written.
+func (s *state) writeValue(key string, val reflect.Value, style vectorStyle, indent int) {
 	// The indicator depends on whether the value is a scalar or a vector.
-	iVal := indirect(val, &s.err)
+	iVal := s.resolve(val)
 	if s.err != nil {
 		return
 	}
-	valKind := iVal.Kind()
 
-	isVector := valKind == reflect.Map || valKind == reflect.Struct || valKind == reflect.Slice || valKind == reflect.Array
+	if isVectorKind(iVal.Kind()) {
+		if style == styleInline && !s.isEmptyVector(iVal) && !s.canInline(iVal) {
+			if s.err == nil {
+				s.err = fmt.Errorf("huml: field %s is tagged inline but contains vectors or multi-line strings", key)
+			}
+			return
+		}
+		s.writeVector(iVal, style, indent+2)
+		return
+	}
+
+	// The value of a key-value pair is always indented further, which
+	// matters for multi-line strings.
+	s.write(": ")
+	s.marshalValue(iVal, indent+2)
+}
+
+// writeVector writes the '::' indicator followed by the resolved vector v.
+// Empty vectors, vectors with the inline style and, in compact mode, vectors
+// of scalars without the block style are written on the same line. Other
+// vectors start on a new line, with content at indent.
+func (s *state) writeVector(v reflect.Value, style vectorStyle, indent int) {
+	if style == styleDefault && s.opts.styles != nil {
+		style = s.opts.styles[strings.Join(s.path, "\x00")]
+		if style == styleInline && !s.canInline(v) {
+			style = styleBlock
+		}
+	}
+
+	switch {
+	case s.isEmptyVector(v):
+		s.write(":: ")
+		s.marshalValue(v, indent)
+	case style == styleInline:
+		s.write(":: ")
+		s.marshalInline(v)
+	case style == styleDefault && s.opts.compact && s.canInline(v):
+		if text, ok := s.inlineText(v); ok {
+			s.write(":: " + text)
+		} else if s.err == nil {
+			s.write("::\n")
+			s.marshalValue(v, indent)
+		}
+	default:
+		s.write("::\n")
+		s.marshalValue(v, indent)
+	}
+}
+
+// isVectorKind reports whether values of kind k are encoded as vectors.
+func isVectorKind(k reflect.Kind) bool {
+	return k == reflect.Map || k == reflect.Struct || k == reflect.Slice || k == reflect.Array
+}
+
+// isEmptyVector reports whether the resolved vector v has no entries,
+// in which case it is written as an empty list or dict marker.
+func (s *state) isEmptyVector(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map:
+		return len(s.dictEntries(v)) == 0
+	default:
+		return v.Len() == 0
+	}
+}
 
-	if isVector {
-		isEmpty := false
-		switch valKind {
-		case reflect.Map, reflect.Slice, reflect.Array:
-			if iVal.Len() == 0 {
-				isEmpty = true
+// canInline reports whether the non-empty resolved vector v can be written
+// as an inline list or dict, which requires all its values to be scalars
+// that fit on a single line.
+func (s *state) canInline(v reflect.Value) bool {
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			if !s.isInlineScalar(v.Index(i)) {
+				return false
 			}
-		case reflect.Struct:
-			if s.isStructEmpty(iVal) {
-				isEmpty = true
+		}
+		return true
+	}
+
+	for _, e := range s.dictEntries(v) {
+		// Comments need a line of their own.
+		if e.doc != "" || !s.isInlineScalar(e.value) {
+			return false
+		}
+	}
+	return s.err == nil
+}
+
+// isInlineScalar reports whether v is a scalar that fits on a single line.
+func (s *state) isInlineScalar(v reflect.Value) bool {
+	v = s.resolve(v)
+	if s.err != nil {
+		return false
+	}
+	if !v.IsValid() {
+		return true
+	}
+	if isVectorKind(v.Kind()) {
+		return false
+	}
+	return v.Kind() != reflect.String || !strings.Contains(v.String(), "\n")
+}
+
+// inlineText returns the inline form of the resolved vector v, and whether
+// it fits within the maximum line width, if any, after the current column and
+// a "::" indicator.
+func (s *state) inlineText(v reflect.Value) (string, bool) {
+	var buf strings.Builder
+	opts := *s.opts
+	opts.baseIndent = ""
+	opts.maxLineWidth = 0
+	sub := newState(&buf, &opts)
+	sub.marshalInline(v)
+	text, err := buf.String(), sub.err
+	putState(sub)
+	if err != nil {
+		s.err = err
+		return "", false
+	}
+	if s.opts.maxLineWidth == 0 {
+		return text, true
+	}
+	width := len(s.opts.baseIndent) + s.col + len(":: ") + utf8.RuneCountInString(text)
+	return text, width <= s.opts.maxLineWidth
+}
+
+// marshalInline writes the resolved vector v as an inline list
+// (`1, 2, 3`) or inline dict (`a: 1, b: 2`). See canInline.
+func (s *state) marshalInline(v reflect.Value) {
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				s.write(", ")
 			}
+			s.marshalValue(v.Index(i), 0)
 		}
+		return
+	}
 
-		// This is the crucial change. For multi-line (non-empty) vectors, `::` is
-		// followed by a newline. For empty vectors, it's followed by a space.
-		if isEmpty {
-			s.write(":: ")
-		} else {
-			s.write("::\n")
+	for i, e := range s.dictEntries(v) {
+		if i > 0 {
+			s.write(", ")
 		}
-	} else {
+		s.write(s.quoteKey(e.key))
 		s.write(": ")
+		s.marshalValue(e.value, 0)
 	}
-
-	// The value of a key-value pair is always indented further.
-	// For a multi-line vector, its content starts at the new indentation level.
-	s.marshalValue(val, indent+2)
 }
 
 // A regular expression to check if a key is a "bare" key, meaning it doesn't
@@ -464,12 +1778,232 @@ func (s *state) writeKVPair(key string, val reflect.Value, indent int) {
 var bareKeyRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
 
 // quoteKeyIfNeeded wraps a key in quotes if it contains characters that are
-// not allowed in a bare key.
+// not allowed in a bare key. As a bare key must start with a letter, keys that
+// look like numbers, such as "123", "-1" or "1.5", are always quoted, as are
+// the empty key and keys made of spaces, which are valid quoted keys.
 func quoteKeyIfNeeded(key string) string {
 	if bareKeyRegex.MatchString(key) {
 		return key
 	}
-	return strconv.Quote(key)
+	return quoteString(key)
+}
+
+// quoteKey is like quoteKeyIfNeeded, but also leaves keys with Unicode
+// letters bare if the encoder allows it.
+func (s *state) quoteKey(key string) string {
+	if s.opts.unicodeKeys && isUnicodeBareKey(key) {
+		return key
+	}
+	return quoteKeyIfNeeded(key)
+}
+
+// isUnicodeBareKey reports whether key can be written bare when Unicode
+// letters are allowed, mirroring how a Decoder with AllowUnicodeKeys reads
+// bare keys.
+func isUnicodeBareKey(key string) bool {
+	for i, r := range key {
+		if r < utf8.RuneSelf {
+			c := byte(r)
+			if !isAlpha(c) && (i == 0 || !isDigit(c) && c != '_' && c != '-') {
+				return false
+			}
+		} else if !unicode.IsLetter(r) && (i == 0 || !unicode.IsMark(r)) {
+			return false
+		}
+	}
+	return key != ""
+}
+
+// resolve follows pointers and interfaces in v like indirect, and replaces
+// values of types with a custom representation, such as RawMessage, with
+// the plain value that is written in their place.
+func (s *state) resolve(v reflect.Value) reflect.Value {
+	v = indirect(v, &s.err)
+	if s.err != nil || !v.IsValid() {
+		return v
+	}
+
+	// A reflect.Value is written as the value it holds.
+	if v.Type() == valueType {
+		return s.resolve(v.Interface().(reflect.Value))
+	}
+
+	if s.opts.jsonFallback {
+		if m, ok := jsonMarshaler(v); ok {
+			out, err := fromJSON(m)
+			if err != nil {
+				s.err = fmt.Errorf("huml: error encoding %s via JSON: %w", v.Type(), err)
+				return reflect.Value{}
+			}
+			return reflect.ValueOf(out)
+		}
+	}
+
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return reflect.Value{}
+		}
+		var out any
+		if err := Unmarshal(v.Bytes(), &out); err != nil {
+			s.err = fmt.Errorf("huml: invalid RawMessage: %w", err)
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(out)
+	}
+
+	// A big.Rat is written as an exact string such as "3/4", or "5" for an
+	// integer, which decodes back to the same value.
+	if v.Type() == ratType {
+		r := v.Interface().(big.Rat)
+		return reflect.ValueOf(r.RatString())
+	}
+
+	// A value with a text form, such as a net.IP, is written as a string.
+	if m, ok := textMarshaler(v); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			s.err = fmt.Errorf("huml: error encoding %s as text: %w", v.Type(), err)
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(string(text))
+	}
+
+	if name, ok := s.enumName(v); ok {
+		return reflect.ValueOf(name)
+	}
+
+	if s.opts.errorStrings {
+		if e, ok := errorValue(v); ok {
+			return reflect.ValueOf(e.Error())
+		}
+	}
+
+	// A struct with a scalar field is written as the value of that field.
+	if v.Kind() == reflect.Struct {
+		f, ok, err := scalarField(v.Type())
+		if err != nil {
+			s.err = fmt.Errorf("huml: %w", err)
+			return reflect.Value{}
+		}
+		if ok {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok {
+				return reflect.Value{}
+			}
+			return s.resolve(fv)
+		}
+	}
+
+	// A nil map or slice has no entries to write, and is distinguished from
+	// an empty one by being written as null.
+	if (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.IsNil() {
+		return reflect.Value{}
+	}
+
+	return v
+}
+
+// enumName returns the name of v for SetEnumNames, if it is enabled and v is
+// an integer that implements fmt.Stringer.
+func (s *state) enumName(v reflect.Value) (string, bool) {
+	if !s.opts.enumNames || !isIntegerKind(v.Kind()) || !v.Type().Implements(stringerType) {
+		return "", false
+	}
+	return v.Interface().(fmt.Stringer).String(), true
+}
+
+// isIntegerKind reports whether k is a signed or unsigned integer kind.
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// jsonMarshaler returns v as a json.Marshaler, including when only a pointer
+// to v implements it.
+func jsonMarshaler(v reflect.Value) (json.Marshaler, bool) {
+	if v.Type().Implements(jsonMarshalerType) {
+		return v.Interface().(json.Marshaler), true
+	}
+	if v.CanAddr() && reflect.PointerTo(v.Type()).Implements(jsonMarshalerType) {
+		return v.Addr().Interface().(json.Marshaler), true
+	}
+	return nil, false
+}
+
+// textMarshaler returns v as an encoding.TextMarshaler, including when only a
+// pointer to v implements it.
+func textMarshaler(v reflect.Value) (encoding.TextMarshaler, bool) {
+	if v.Type().Implements(textMarshalerType) {
+		return v.Interface().(encoding.TextMarshaler), true
+	}
+	if v.CanAddr() && reflect.PointerTo(v.Type()).Implements(textMarshalerType) {
+		return v.Addr().Interface().(encoding.TextMarshaler), true
+	}
+	return nil, false
+}
+
+// errorValue returns v as an error, including when only a pointer to v
+// implements it, as for the result of errors.New.
+func errorValue(v reflect.Value) (error, bool) {
+	if v.Type().Implements(errorType) {
+		return v.Interface().(error), true
+	}
+	if v.CanAddr() && reflect.PointerTo(v.Type()).Implements(errorType) {
+		return v.Addr().Interface().(error), true
+	}
+	return nil, false
+}
+
+// fromJSON returns the value produced by m as JSON, with the types produced by
+// Unmarshal. Numbers become int64 if they are integers, and float64 otherwise.
+func fromJSON(m json.Marshaler) (any, error) {
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out any
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return convertJSONNumbers(out)
+}
+
+// convertJSONNumbers replaces the json.Numbers in v with int64 or float64
+// values.
+func convertJSONNumbers(v any) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if !strings.ContainsAny(string(v), ".eE") {
+			if i, err := v.Int64(); err == nil {
+				return i, nil
+			}
+		}
+		return v.Float64()
+	case map[string]any:
+		for k, item := range v {
+			conv, err := convertJSONNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = conv
+		}
+	case []any:
+		for i, item := range v {
+			conv, err := convertJSONNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = conv
+		}
+	}
+	return v, nil
 }
 
 // indirect walks down a chain of pointers and interfaces to find the underlying
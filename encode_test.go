@@ -1,9 +1,17 @@
 package huml
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"math"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"text/template"
+	"time"
+	"unicode"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -45,3 +53,1230 @@ func TestEncodeDoc(t *testing.T) {
 	// Deep-compare both.
 	assert.Equal(t, out, resJson, "test.huml and tests/documents/mixed.json should be deeply equal")
 }
+
+func TestEncoderKeyTransform(t *testing.T) {
+	// kebab converts a Go identifier like FieldName to field-name.
+	kebab := func(s string) string {
+		var b strings.Builder
+		for i, r := range s {
+			if unicode.IsUpper(r) {
+				if i > 0 {
+					b.WriteByte('-')
+				}
+				r = unicode.ToLower(r)
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+
+	t.Run("struct", func(t *testing.T) {
+		type TestStruct struct {
+			FieldName  string
+			MaxRetries int
+			Tagged     bool `huml:"Explicit_Tag"`
+		}
+
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetKeyTransform(kebab)
+		if err := enc.Encode(TestStruct{FieldName: "a", MaxRetries: 3, Tagged: true}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, "field-name: \"a\"\nmax-retries: 3\nExplicit_Tag: true\n", buf.String())
+	})
+
+	t.Run("map", func(t *testing.T) {
+		data := map[string]any{"FooBar": 1, "Baz": 2}
+
+		// Map keys are left alone by default.
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetKeyTransform(kebab)
+		if err := enc.Encode(data); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, "Baz: 2\nFooBar: 1\n", buf.String())
+
+		buf.Reset()
+		enc.SetTransformMapKeys(true)
+		if err := enc.Encode(data); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, "baz: 2\nfoo-bar: 1\n", buf.String())
+	})
+}
+
+func TestEncoderCompact(t *testing.T) {
+	type Point struct {
+		X int `huml:"x"`
+		Y int `huml:"y"`
+	}
+	type Shape struct {
+		Name   string  `huml:"name"`
+		Points []Point `huml:"points"`
+		Tags   []string
+		Meta   map[string]any `huml:"meta"`
+	}
+
+	shape := Shape{
+		Name:   "triangle",
+		Points: []Point{{1, 2}, {3, 4}, {5, 6}},
+		Tags:   []string{"a, b", "c: d"},
+		Meta:   map[string]any{"nested": []int{1}, "note": "x"},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetCompact(true)
+	if err := enc.Encode(shape); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, `name: "triangle"
+points::
+  - :: x: 1, y: 2
+  - :: x: 3, y: 4
+  - :: x: 5, y: 6
+Tags:: "a, b", "c: d"
+meta::
+  nested:: 1
+  note: "x"
+`, buf.String())
+
+	// The compact output must decode back to the same value.
+	var result Shape
+	if err := Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v", err)
+	}
+	assert.Equal(t, shape.Points, result.Points)
+	assert.Equal(t, shape.Tags, result.Tags)
+	assert.Equal(t, "x", result.Meta["note"])
+
+	// Without the option, vectors are written as blocks.
+	buf.Reset()
+	if err := NewEncoder(&buf).Encode([]Point{{1, 2}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "- ::\n  x: 1\n  y: 2\n", buf.String())
+}
+
+func TestEncoderSetMaxLineWidth(t *testing.T) {
+	type Config struct {
+		Short []int          `huml:"short"`
+		Long  []string       `huml:"long"`
+		Point map[string]int `huml:"point"`
+		Fixed []string       `huml:"fixed,inline"`
+		Items [][]int        `huml:"items"`
+	}
+
+	cfg := Config{
+		Short: []int{1, 2, 3},
+		Long:  []string{"alpha", "bravo", "charlie", "delta"},
+		Point: map[string]int{"x": 1, "y": 2},
+		Fixed: []string{"alpha", "bravo", "charlie", "delta"},
+		Items: [][]int{{1, 2}, {10000, 20000, 30000}},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetCompact(true)
+	enc.SetMaxLineWidth(20)
+	assert.NoError(t, enc.Encode(cfg))
+	// "short:: 1, 2, 3" is 15 characters and "  - :: 1, 2" is 11; the others
+	// would exceed 20, except for the field tagged inline.
+	assert.Equal(t, `short:: 1, 2, 3
+long::
+  - "alpha"
+  - "bravo"
+  - "charlie"
+  - "delta"
+point:: x: 1, y: 2
+fixed:: "alpha", "bravo", "charlie", "delta"
+items::
+  - :: 1, 2
+  - ::
+    - 10000
+    - 20000
+    - 30000
+`, buf.String())
+
+	var result Config
+	assert.NoError(t, Unmarshal(buf.Bytes(), &result))
+	assert.Equal(t, cfg, result)
+
+	// The width counts the base indentation.
+	buf.Reset()
+	enc.SetBaseIndent(8)
+	enc.SetMaxLineWidth(18)
+	assert.NoError(t, enc.Encode(map[string][]int{"a": {1, 2, 3}, "bb": {1, 2}}))
+	assert.Equal(t, "        a::\n          - 1\n          - 2\n          - 3\n        bb:: 1, 2\n", buf.String())
+}
+
+func TestEncoderOmitNilMapValues(t *testing.T) {
+	var nilPtr *int
+	data := map[string]any{
+		"real":  1,
+		"nil":   nil,
+		"typed": nilPtr,
+		"inner": map[string]any{"gone": nil},
+	}
+
+	// Nil values are written as null by default.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Encode(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "inner::\n  gone: null\nnil: null\nreal: 1\ntyped: null\n", buf.String())
+
+	buf.Reset()
+	enc.SetOmitNilMapValues(true)
+	if err := enc.Encode(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "inner:: {}\nreal: 1\n", buf.String())
+}
+
+// TestEncodeAmbiguousStrings tests that strings that look like other types
+// are quoted and decode back as strings.
+func TestEncodeAmbiguousStrings(t *testing.T) {
+	f := func(str string) {
+		t.Helper()
+		t.Run(str, func(t *testing.T) {
+			t.Helper()
+			marshalled, err := Marshal(map[string]any{"value": str, "list": []string{str}})
+			if err != nil {
+				t.Fatalf("unexpected error marshalling: %v", err)
+			}
+
+			var result map[string]any
+			if err := Unmarshal(marshalled, &result); err != nil {
+				t.Fatalf("unexpected error unmarshalling: %v\n%s", err, marshalled)
+			}
+			assert.Equal(t, str, result["value"])
+			assert.Equal(t, []any{str}, result["list"])
+		})
+	}
+
+	f("true")
+	f("false")
+	f("123")
+	f("1.5")
+	f("0x1F")
+	f("inf")
+	f("-inf")
+	f("+inf")
+	f("nan")
+	f("null")
+	f("foo")
+	f("[]")
+	f("{}")
+	f("")
+}
+
+func TestEncoderPlainFloats(t *testing.T) {
+	f := func(val float64, expG, expF string) {
+		t.Helper()
+		t.Run(expG, func(t *testing.T) {
+			t.Helper()
+			for _, c := range []struct {
+				maxLen int
+				exp    string
+			}{{0, expG}, {24, expF}} {
+				var buf bytes.Buffer
+				enc := NewEncoder(&buf)
+				enc.SetPlainFloats(c.maxLen)
+				if err := enc.Encode(map[string]float64{"v": val}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				assert.Equal(t, "v: "+c.exp+"\n", buf.String())
+
+				// The output must parse back to the same value.
+				var result map[string]float64
+				if err := Unmarshal(buf.Bytes(), &result); err != nil {
+					t.Fatalf("unexpected error unmarshalling: %v", err)
+				}
+				assert.Equal(t, val, result["v"])
+			}
+		})
+	}
+
+	f(0.0000001, "1e-07", "0.0000001")
+	f(1000000, "1e+06", "1000000.0")
+	f(123456789.125, "1.23456789125e+08", "123456789.125")
+	f(3.14, "3.14", "3.14")
+	f(-0.5, "-0.5", "-0.5")
+	f(2, "2", "2.0")
+	f(1e-30, "1e-30", "1e-30")
+	f(1e300, "1e+300", "1e+300")
+	f(math.Inf(1), "inf", "inf")
+}
+
+// TestEncodeControlCharacters tests that every control character round-trips
+// through a single-line string, in both values and keys.
+func TestEncodeControlCharacters(t *testing.T) {
+	f := func(r rune) {
+		t.Helper()
+		t.Run(fmt.Sprintf("%#04x", r), func(t *testing.T) {
+			t.Helper()
+			str := "a" + string(r) + "b"
+			if r == '\n' {
+				// Strings with newlines are written as multi-line strings.
+				return
+			}
+
+			marshalled, err := Marshal(map[string]any{"value": str, str: 1})
+			if err != nil {
+				t.Fatalf("unexpected error marshalling: %v", err)
+			}
+			assert.NotContains(t, string(marshalled), `\x`)
+
+			var result map[string]any
+			if err := Unmarshal(marshalled, &result); err != nil {
+				t.Fatalf("unexpected error unmarshalling: %v\n%s", err, marshalled)
+			}
+			assert.Equal(t, str, result["value"])
+			assert.Equal(t, int64(1), result[str])
+		})
+	}
+
+	for r := rune(0); r < 0x20; r++ {
+		f(r)
+	}
+	f(0x7f)
+}
+
+// TestEncodeVerticalTab tests that a vertical tab is written with the short
+// \v escape and that the parser accepts it in keys, values and list items.
+func TestEncodeVerticalTab(t *testing.T) {
+	str := "a\vb"
+	marshalled, err := Marshal(map[string]any{"value": str, "list": []string{str}, str: 1})
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+	assert.Equal(t, "%HUML v0.2.0\n\"a\\vb\": 1\nlist::\n  - \"a\\vb\"\nvalue: \"a\\vb\"\n", string(marshalled))
+
+	var result map[string]any
+	if err := Unmarshal(marshalled, &result); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v\n%s", err, marshalled)
+	}
+	assert.Equal(t, str, result["value"])
+	assert.Equal(t, []any{str}, result["list"])
+	assert.Equal(t, int64(1), result[str])
+}
+
+// TestEncodeSpecialFloats pins the canonical form of the special float values.
+func TestEncodeSpecialFloats(t *testing.T) {
+	f := func(name string, val float64, exp string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			for _, v := range []any{val, float32(val)} {
+				var buf bytes.Buffer
+				if err := NewEncoder(&buf).Encode(map[string]any{"v": v}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				assert.Equal(t, "v: "+exp+"\n", buf.String())
+			}
+		})
+	}
+
+	f("pos_inf", math.Inf(1), "inf")
+	f("neg_inf", math.Inf(-1), "-inf")
+	f("nan", math.NaN(), "nan")
+	f("neg_nan", math.Copysign(math.NaN(), -1), "nan")
+	f("payload_nan", math.Float64frombits(0x7ff8000000000123), "nan")
+}
+
+// TestEncodeNilVersusEmpty tests that nil maps and slices are written as null
+// and empty ones as {} and [], and that each decodes back the same way.
+func TestEncodeNilVersusEmpty(t *testing.T) {
+	var (
+		nilMap   map[string]int
+		nilSlice []int
+	)
+
+	f := func(name string, val any, exp string, expDecoded any) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).Encode(val); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, buf.String())
+
+			var result any
+			if err := Unmarshal(buf.Bytes(), &result); err != nil {
+				t.Fatalf("unexpected error unmarshalling: %v", err)
+			}
+			assert.Equal(t, expDecoded, result)
+		})
+	}
+
+	f("nil_map", nilMap, "null\n", nil)
+	f("empty_map", map[string]int{}, "{}\n", map[string]any{})
+	f("nil_slice", nilSlice, "null\n", nil)
+	f("empty_slice", []int{}, "[]\n", []any{})
+	f("nested", map[string]any{"a": nilMap, "b": map[string]int{}, "c": nilSlice, "d": []int{}},
+		"a: null\nb:: {}\nc: null\nd:: []\n",
+		map[string]any{"a": nil, "b": map[string]any{}, "c": nil, "d": []any{}})
+	f("list", []any{nilMap, map[string]int{}, nilSlice, []int{}},
+		"- null\n- :: {}\n- null\n- :: []\n",
+		[]any{nil, map[string]any{}, nil, []any{}})
+}
+
+func TestEncodeVectorStyleTags(t *testing.T) {
+	type Point struct {
+		X int `huml:"x"`
+		Y int `huml:"y"`
+	}
+	type Config struct {
+		Tags   []string       `huml:"tags,inline"`
+		Items  []string       `huml:"items,block"`
+		Origin Point          `huml:"origin,inline"`
+		Empty  []string       `huml:"empty,inline"`
+		Meta   map[string]int `huml:"meta"`
+	}
+
+	cfg := Config{
+		Tags:   []string{"a", "b", "c"},
+		Items:  []string{"x", "y"},
+		Origin: Point{1, 2},
+		Empty:  []string{},
+		Meta:   map[string]int{"n": 1},
+	}
+
+	f := func(name string, compact bool, exp string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetCompact(compact)
+			if err := enc.Encode(cfg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, buf.String())
+
+			var result Config
+			if err := Unmarshal(buf.Bytes(), &result); err != nil {
+				t.Fatalf("unexpected error unmarshalling: %v", err)
+			}
+			assert.Equal(t, cfg, result)
+		})
+	}
+
+	// The tags override the compact option in both directions.
+	f("default", false, `tags:: "a", "b", "c"
+items::
+  - "x"
+  - "y"
+origin:: x: 1, y: 2
+empty:: []
+meta::
+  n: 1
+`)
+	f("compact", true, `tags:: "a", "b", "c"
+items::
+  - "x"
+  - "y"
+origin:: x: 1, y: 2
+empty:: []
+meta:: n: 1
+`)
+
+	t.Run("nested_vectors", func(t *testing.T) {
+		type Bad struct {
+			Rows [][]int `huml:"rows,inline"`
+		}
+		_, err := Marshal(Bad{Rows: [][]int{{1}}})
+		assert.EqualError(t, err, "huml: field rows is tagged inline but contains vectors or multi-line strings")
+	})
+}
+
+// TestEncodeMultilineFence tests that multi-line strings whose lines start
+// with quotes get a longer fence and survive a round trip.
+func TestEncodeMultilineFence(t *testing.T) {
+	f := func(name, str, expFence string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).Encode(map[string]any{"v": str, "l": []any{map[string]any{"w": str}}}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.True(t, strings.HasPrefix(buf.String(), "l::\n  - ::\n    w: "+expFence+"\n"), buf.String())
+
+			var result map[string]any
+			if err := Unmarshal(buf.Bytes(), &result); err != nil {
+				t.Fatalf("unexpected error unmarshalling: %v\n%s", err, buf.String())
+			}
+			assert.Equal(t, str, result["v"])
+			assert.Equal(t, []any{map[string]any{"w": str}}, result["l"])
+		})
+	}
+
+	f("plain", "a\nb", `"""`)
+	f("backticks", "```go\nfmt.Println()\n```", `"""`)
+	f("inline_quotes", "say \"\"\"hi\"\"\"\nok", `"""`)
+	f("line_quotes", "doc:\n\"\"\"\nquoted\n\"\"\"", `""""`)
+	f("indented_quotes", "x\n  \"\"\"\"\" y", `""""""`)
+	f("both", "```\n\"\"\"\n```", `""""`)
+}
+
+func TestEncodeOmitEmptyTime(t *testing.T) {
+	type Record struct {
+		Name    string    `huml:"name"`
+		Created time.Time `huml:"created,omitempty"`
+		Deleted time.Time `huml:"deleted,omitempty"`
+	}
+
+	var buf bytes.Buffer
+	rec := Record{Name: "a", Created: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	if err := NewEncoder(&buf).Encode(rec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var result map[string]any
+	if err := Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v\n%s", err, buf.String())
+	}
+	assert.Contains(t, result, "created")
+	assert.NotContains(t, result, "deleted")
+
+	// Only the zero time counts as empty, even though no time has exported fields.
+	assert.False(t, isEmptyValue(reflect.ValueOf(rec.Created)))
+	assert.True(t, isEmptyValue(reflect.ValueOf(rec.Deleted)))
+}
+
+func TestEncodeOmitEmptyKinds(t *testing.T) {
+	type Record struct {
+		Name  string `huml:"name,omitempty=string"`
+		Count int    `huml:"count,omitempty=string"`
+		Value any    `huml:"value,omitempty=string|nil"`
+		Tags  []int  `huml:"tags,omitempty=vector"`
+		Ptr   *int   `huml:"ptr,omitempty=number|nil"`
+	}
+
+	f := func(name string, in Record, want string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, NewEncoder(&buf).Encode(in))
+			assert.Equal(t, want, buf.String())
+		})
+	}
+
+	zero := 0
+	f("empty", Record{}, "count: 0\n")
+	f("zero_ptr", Record{Ptr: &zero}, "count: 0\n")
+	f("set", Record{Name: "a", Count: 1, Value: "b", Tags: []int{1}}, "name: \"a\"\ncount: 1\nvalue: \"b\"\ntags::\n  - 1\n")
+	f("empty_string_value", Record{Value: ""}, "count: 0\n")
+	f("zero_value", Record{Value: 0, Tags: []int{}}, "count: 0\nvalue: 0\n")
+
+	type Bad struct {
+		Name string `huml:"name,omitempty=text"`
+	}
+	_, err := Marshal(Bad{})
+	assert.EqualError(t, err, `huml: field name has unknown kind "text" in omitempty`)
+}
+
+func TestEncodeFieldComments(t *testing.T) {
+	type Server struct {
+		Host string `huml:"host,comment=Host name, or IP address"`
+		Port int    `huml:"port,omitempty,comment=The listening port"`
+	}
+	type Config struct {
+		Name    string   `huml:"name"`
+		Server  Server   `huml:"server,comment=Where to listen"`
+		Servers []Server `huml:"servers"`
+	}
+
+	cfg := Config{
+		Name:    "app",
+		Server:  Server{Host: "localhost", Port: 8080},
+		Servers: []Server{{Host: "a", Port: 1}},
+	}
+
+	// Comments force block style, even in compact mode.
+	for _, compact := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetCompact(compact)
+		if err := enc.Encode(cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, `name: "app"
+# Where to listen
+server::
+  # Host name, or IP address
+  host: "localhost"
+  # The listening port
+  port: 8080
+servers::
+  - ::
+    # Host name, or IP address
+    host: "a"
+    # The listening port
+    port: 1
+`, buf.String())
+
+		var result Config
+		if err := Unmarshal(buf.Bytes(), &result); err != nil {
+			t.Fatalf("unexpected error unmarshalling: %v", err)
+		}
+		assert.Equal(t, cfg, result)
+	}
+
+	// Omitted fields take their comment with them.
+	marshalled, err := Marshal(Server{Host: "h"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "%HUML v0.2.0\n# Host name, or IP address\nhost: \"h\"\n", string(marshalled))
+
+	t.Run("multiline", func(t *testing.T) {
+		type Bad struct {
+			A int `huml:"a,comment=one\ntwo"`
+		}
+		_, err := Marshal(Bad{})
+		assert.EqualError(t, err, "huml: comment of field a must be a single line")
+	})
+}
+
+func TestNegativeZero(t *testing.T) {
+	negZero := math.Copysign(0, -1)
+
+	// Negative zero is written as a float, so that its sign survives.
+	for _, plain := range []int{0, 24} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetPlainFloats(plain)
+		if err := enc.Encode(map[string]any{"f": negZero, "z": 0.0}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plain == 0 {
+			assert.Equal(t, "f: -0.0\nz: 0\n", buf.String())
+		} else {
+			assert.Equal(t, "f: -0.0\nz: 0.0\n", buf.String())
+		}
+
+		var result map[string]any
+		if err := Unmarshal(buf.Bytes(), &result); err != nil {
+			t.Fatalf("unexpected error unmarshalling: %v", err)
+		}
+		assert.True(t, math.Signbit(result["f"].(float64)))
+
+		var typed struct {
+			F float64 `huml:"f"`
+			Z float64 `huml:"z"`
+		}
+		if err := Unmarshal(buf.Bytes(), &typed); err != nil {
+			t.Fatalf("unexpected error unmarshalling: %v", err)
+		}
+		assert.True(t, math.Signbit(typed.F))
+		assert.False(t, math.Signbit(typed.Z))
+	}
+
+	// An integer -0 is just zero.
+	var result map[string]any
+	if err := Unmarshal([]byte("i: -0\nf: -0.0"), &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, int64(0), result["i"])
+	assert.True(t, math.Signbit(result["f"].(float64)))
+}
+
+func TestEncodeSortTag(t *testing.T) {
+	type Config struct {
+		Tags   []string   `huml:"tags,sort"`
+		Ports  [3]int     `huml:"ports,sort"`
+		Ratios *[]float64 `huml:"ratios,sort"`
+		Order  []int      `huml:"order"`
+		None   []uint8    `huml:"none,sort,omitempty"`
+	}
+
+	ratios := []float64{2.5, math.NaN(), -1}
+	cfg := Config{
+		Tags:   []string{"b", "c", "a", "b"},
+		Ports:  [3]int{443, 80, 8080},
+		Ratios: &ratios,
+		Order:  []int{3, 1, 2},
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, `tags::
+  - "a"
+  - "b"
+  - "b"
+  - "c"
+ports::
+  - 80
+  - 443
+  - 8080
+ratios::
+  - nan
+  - -1
+  - 2.5
+order::
+  - 3
+  - 1
+  - 2
+`, buf.String())
+
+	// The value being encoded is left alone.
+	assert.Equal(t, []string{"b", "c", "a", "b"}, cfg.Tags)
+	assert.Equal(t, [3]int{443, 80, 8080}, cfg.Ports)
+
+	t.Run("unsortable", func(t *testing.T) {
+		type Bad struct {
+			Items []any `huml:"items,sort"`
+		}
+		_, err := Marshal(Bad{Items: []any{1, "a"}})
+		assert.EqualError(t, err, "huml: field items tagged sort has unsortable elements of type interface {}")
+	})
+}
+
+func TestEncodeNumericKeys(t *testing.T) {
+	f := func(key, expLine string) {
+		t.Helper()
+		t.Run(key, func(t *testing.T) {
+			t.Helper()
+			in := map[string]any{key: int64(1)}
+			marshalled, err := Marshal(in)
+			if err != nil {
+				t.Fatalf("unexpected error marshalling: %v", err)
+			}
+			assert.Equal(t, "%HUML v0.2.0\n"+expLine+"\n", string(marshalled))
+
+			var result map[string]any
+			if err := Unmarshal(marshalled, &result); err != nil {
+				t.Fatalf("unexpected error unmarshalling: %v\n%s", err, marshalled)
+			}
+			assert.Equal(t, in, result)
+		})
+	}
+
+	// Keys that look like numbers are always quoted.
+	f("123", `"123": 1`)
+	f("-1", `"-1": 1`)
+	f("1.5", `"1.5": 1`)
+	f("0x1f", `"0x1f": 1`)
+	f("1_000", `"1_000": 1`)
+
+	// Keys starting with a letter are bare, even if they read as a literal.
+	f("e5", `e5: 1`)
+	f("true", `true: 1`)
+	f("nan", `nan: 1`)
+}
+
+func TestQuoteValue(t *testing.T) {
+	f := func(name, in string, indent int, expected string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			assert.Equal(t, expected, QuoteValue(in, indent))
+
+			// The value parses back as a string under a key at indent.
+			doc := "a::\n" + strings.Repeat(" ", indent) + "key: " + QuoteValue(in, indent)
+			if indent == 0 {
+				doc = "key: " + QuoteValue(in, indent)
+			}
+			var result map[string]any
+			if err := Unmarshal([]byte(doc), &result); err != nil {
+				t.Fatalf("unexpected error unmarshalling: %v\n%s", err, doc)
+			}
+			if indent > 0 {
+				result = result["a"].(map[string]any)
+			}
+			assert.Equal(t, strings.TrimSuffix(in, "\n"), result["key"])
+		})
+	}
+
+	f("plain", "hello", 0, `"hello"`)
+	f("literal", "true", 0, `"true"`)
+	f("escapes", "say \"hi\"\t\\", 0, `"say \"hi\"\t\\"`)
+	f("control", "a\x01b", 0, `"a\u0001b"`)
+	f("multiline", "one\ntwo", 0, "\"\"\"\n  one\n  two\n\"\"\"")
+	f("multiline_indent", "one\n  two", 2, "\"\"\"\n    one\n      two\n  \"\"\"")
+	f("multiline_trailing_newline", "one\ntwo\n", 0, "\"\"\"\n  one\n  two\n\"\"\"")
+	f("multiline_fence", "one\n\"\"\"\ntwo", 0, "\"\"\"\"\n  one\n  \"\"\"\n  two\n\"\"\"\"")
+}
+
+func TestQuoteKey(t *testing.T) {
+	f := func(in, expected string) {
+		t.Helper()
+		t.Run(in, func(t *testing.T) {
+			t.Helper()
+			assert.Equal(t, expected, QuoteKey(in))
+
+			var result map[string]any
+			doc := QuoteKey(in) + ": 1"
+			if err := Unmarshal([]byte(doc), &result); err != nil {
+				t.Fatalf("unexpected error unmarshalling: %v\n%s", err, doc)
+			}
+			assert.Equal(t, map[string]any{in: int64(1)}, result)
+		})
+	}
+
+	f("name", "name")
+	f("snake_case-key2", "snake_case-key2")
+	f("123", `"123"`)
+	f("with space", `"with space"`)
+	f("a.b", `"a.b"`)
+	f(`q"uote`, `"q\"uote"`)
+	f("", `""`)
+}
+
+func TestQuote(t *testing.T) {
+	f := func(name string, in any, expected, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			out, err := Quote(in)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, out)
+		})
+	}
+
+	f("string", `say "hi"`, `"say \"hi\""`, "")
+	f("int", 42, "42", "")
+	f("float", 1.5, "1.5", "")
+	f("bool", true, "true", "")
+	f("nil", nil, "null", "")
+	f("list", []int{1, 2, 3}, "1, 2, 3", "")
+	f("dict", map[string]any{"a": 1, "b c": "x"}, `a: 1, "b c": "x"`, "")
+	f("empty_list", []int{}, "[]", "")
+	f("empty_dict", map[string]int{}, "{}", "")
+	f("multiline", "one\ntwo", "", "huml: cannot quote a multi-line string on a single line")
+	f("nested", []any{[]int{1}}, "", "huml: cannot quote []interface {} with vectors or multi-line strings on a single line")
+
+	// Rendered into a template, the values parse back.
+	tmpl := template.Must(template.New("config").Funcs(template.FuncMap{"huml": Quote}).Parse(
+		"name: {{ huml .Name }}\nport: {{ huml .Port }}\ntags:: {{ huml .Tags }}\n"))
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, map[string]any{
+		"Name": "my \"app\"\t1",
+		"Port": 8080,
+		"Tags": []string{"a", "b"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "name: \"my \\\"app\\\"\\t1\"\nport: 8080\ntags:: \"a\", \"b\"\n", buf.String())
+
+	var result map[string]any
+	assert.NoError(t, Unmarshal(buf.Bytes(), &result))
+	assert.Equal(t, map[string]any{"name": "my \"app\"\t1", "port": int64(8080), "tags": []any{"a", "b"}}, result)
+}
+
+func TestEncoderSetBaseIndent(t *testing.T) {
+	in := map[string]any{
+		"name": "app",
+		"server": map[string]any{
+			"ports": []any{int64(80), int64(443)},
+			"notes": "one\n  two",
+		},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetBaseIndent(4)
+	if err := enc.Encode(in); err != nil {
+		t.Fatalf("unexpected error encoding: %v", err)
+	}
+	expected := `    name: "app"
+    server::
+      notes: """
+        one
+          two
+      """
+      ports::
+        - 80
+        - 443
+`
+	assert.Equal(t, expected, buf.String())
+
+	// The output parses after removing the indentation.
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		lines = append(lines, strings.TrimPrefix(line, "    "))
+	}
+	var result map[string]any
+	if err := Unmarshal([]byte(strings.Join(lines, "\n")), &result); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v", err)
+	}
+	assert.Equal(t, in, result)
+
+	// It can be spliced in as the value of a key indented by two spaces.
+	doc := "outer::\n  inner::\n" + buf.String()
+	var spliced map[string]any
+	if err := Unmarshal([]byte(doc), &spliced); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v\n%s", err, doc)
+	}
+	assert.Equal(t, map[string]any{"outer": map[string]any{"inner": in}}, spliced)
+
+	// Scalars are indented too, and 0 restores the default.
+	buf.Reset()
+	enc.Encode("x")
+	enc.SetBaseIndent(0)
+	enc.Encode("y")
+	assert.Equal(t, "    \"x\"\n\"y\"\n", buf.String())
+}
+
+// testJSONOnly implements json.Marshaler but has no HUML-specific encoding.
+type testJSONOnly struct {
+	secret string
+}
+
+func (v testJSONOnly) MarshalJSON() ([]byte, error) {
+	return []byte(`{"value": "` + v.secret + `", "count": 3, "ratio": 0.5, "big": 1e3, "tags": ["a", null]}`), nil
+}
+
+// testJSONPtr implements json.Marshaler on its pointer type.
+type testJSONPtr struct{ n int }
+
+func (v *testJSONPtr) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprint(v.n)), nil
+}
+
+func TestEncoderSetJSONFallback(t *testing.T) {
+	type Config struct {
+		Name  string       `huml:"name"`
+		Extra testJSONOnly `huml:"extra"`
+		Ptr   *testJSONPtr `huml:"ptr"`
+		Val   testJSONPtr  `huml:"val"`
+		Nil   *testJSONPtr `huml:"nil"`
+	}
+	in := &Config{Name: "app", Extra: testJSONOnly{"s"}, Ptr: &testJSONPtr{1}, Val: testJSONPtr{2}}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetJSONFallback(true)
+	if err := enc.Encode(in); err != nil {
+		t.Fatalf("unexpected error encoding: %v", err)
+	}
+	expected := `name: "app"
+extra::
+  big: 1000
+  count: 3
+  ratio: 0.5
+  tags::
+    - "a"
+    - null
+  value: "s"
+ptr: 1
+val: 2
+nil: null
+`
+	assert.Equal(t, expected, buf.String())
+
+	// Without the fallback, the unexported fields are not written.
+	buf.Reset()
+	if err := NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatalf("unexpected error encoding: %v", err)
+	}
+	assert.NotContains(t, buf.String(), "value")
+
+	// Errors from MarshalJSON are returned.
+	enc = NewEncoder(&buf)
+	enc.SetJSONFallback(true)
+	err := enc.Encode(map[string]any{"a": json.RawMessage(`{`)})
+	assert.ErrorContains(t, err, "via JSON: unexpected EOF")
+}
+
+func TestEncodeReflectValue(t *testing.T) {
+	type Config struct {
+		Value reflect.Value `huml:"value"`
+		Zero  reflect.Value `huml:"zero"`
+	}
+	out, err := Marshal(Config{Value: reflect.ValueOf([]int{1, 2})})
+	assert.NoError(t, err)
+	assert.Equal(t, "%HUML v0.2.0\nvalue::\n  - 1\n  - 2\nzero: null\n", string(out))
+}
+
+func TestEncodeRootNull(t *testing.T) {
+	var nilPtr *int
+	var nilMap map[string]any
+	for _, v := range []any{nil, nilPtr, nilMap} {
+		out, err := Marshal(v)
+		assert.NoError(t, err)
+		assert.Equal(t, "%HUML v0.2.0\nnull\n", string(out))
+
+		var result any = "previous"
+		assert.NoError(t, Unmarshal(out, &result))
+		assert.Nil(t, result)
+
+		result = "previous"
+		assert.NoError(t, NewDecoder(bytes.NewReader(out)).Decode(&result))
+		assert.Nil(t, result)
+	}
+}
+
+func TestEncoderSetUnicodeKeys(t *testing.T) {
+	in := map[string]any{"café": int64(1), "名前": "x", "a b": int64(2), "1é": int64(3), "é-2": int64(4)}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetUnicodeKeys(true)
+	if err := enc.Encode(in); err != nil {
+		t.Fatalf("unexpected error encoding: %v", err)
+	}
+	assert.Equal(t, "\"1é\": 3\n\"a b\": 2\ncafé: 1\né-2: 4\n名前: \"x\"\n", buf.String())
+
+	var result map[string]any
+	assert.NoError(t, Unmarshal(buf.Bytes(), &result, AllowUnicodeKeys()))
+	assert.Equal(t, in, result)
+
+	// By default, such keys are quoted.
+	out, err := Marshal(map[string]any{"café": int64(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, "%HUML v0.2.0\n\"café\": 1\n", string(out))
+}
+
+func TestCanonicalize(t *testing.T) {
+	f := func(name, in, want string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			out, err := Canonicalize([]byte(in))
+			assert.NoError(t, err)
+			assert.Equal(t, want, string(out))
+
+			// Canonical output is a fixed point.
+			again, err := Canonicalize(out)
+			assert.NoError(t, err)
+			assert.Equal(t, want, string(again))
+		})
+	}
+
+	want := "flags::\n  - \"a\"\n  - \"b\"\nname: \"app\"\nport: 8080\n"
+	f("no_directive", "name: \"app\"\nport: 8080\nflags:: \"a\", \"b\"\n", want)
+	f("old_version", "%HUML v0.1.0\nport: 8080\nname: \"app\"\nflags:: \"a\", \"b\"\n", "%HUML v0.1.0\n"+want)
+	f("header", "%HUML v0.1.0\n# Settings\nport: 8080\n# Name\nname: \"app\"\nflags:: \"a\", \"b\"\n", "%HUML v0.1.0\n# Settings\n\n"+want)
+	f("current_version", "%HUML v0.2.0 # header\n"+want, "%HUML v0.2.0\n"+want)
+	f("no_version", "%HUML\n"+want, "%HUML\n"+want)
+
+	// Comments at the end of dict entry lines are kept with their entries.
+	f("line_comments", `# Server settings
+
+server::
+  port: 8080 # HTTP
+  # The host name.
+  host: "localhost"   # local only
+  tags:: "a", "b" # not kept
+  tls::
+    enabled: true # on
+    cert: """ # not kept
+      x
+      y
+    """
+items::
+  - ::
+    name: "x" # first
+  - 2 # not kept
+name: "app"
+`, `# Server settings
+
+items::
+  - ::
+    name: "x" # first
+  - 2
+name: "app"
+server::
+  host: "localhost" # local only
+  port: 8080 # HTTP
+  tags::
+    - "a"
+    - "b"
+  tls::
+    cert: """
+      x
+      y
+    """
+    enabled: true # on
+`)
+
+	_, err := Canonicalize(nil)
+	assert.EqualError(t, err, "empty document is undefined")
+	_, err = Canonicalize([]byte("a: 1\na: 2\n"))
+	assert.Error(t, err)
+}
+
+func TestDocumentHeader(t *testing.T) {
+	f := func(name, doc string, expected []string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			dec := NewDecoder(strings.NewReader(doc))
+			var result any
+			assert.NoError(t, dec.Decode(&result))
+			assert.Equal(t, expected, dec.Header())
+		})
+	}
+
+	license := []string{"Copyright 2024 Example Corp.", "", "Licensed under the MIT License."}
+	f("header", "# Copyright 2024 Example Corp.\n#\n# Licensed under the MIT License.\n\nname: \"app\"", license)
+	f("after_directive", "%HUML v0.2.0\n\n# Copyright 2024 Example Corp.\n#\n# Licensed under the MIT License.\nname: \"app\"", license)
+	f("first_block_only", "# one\n\n# two\nname: \"app\"", []string{"one"})
+	f("none", "name: \"app\"\n# later\nport: 1", nil)
+	f("directive_comment", "%HUML v0.2.0 # not a header\nname: \"app\"", nil)
+
+	// The header round-trips through an Encoder.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetHeader(license)
+	assert.NoError(t, enc.Encode(map[string]any{"name": "app"}))
+	assert.Equal(t, "# Copyright 2024 Example Corp.\n#\n# Licensed under the MIT License.\n\nname: \"app\"\n", buf.String())
+
+	dec := NewDecoder(&buf)
+	var result map[string]any
+	assert.NoError(t, dec.Decode(&result))
+	assert.Equal(t, license, dec.Header())
+	assert.Equal(t, map[string]any{"name": "app"}, result)
+
+	enc.SetHeader([]string{"two\nlines"})
+	assert.EqualError(t, enc.Encode(1), `huml: header line "two\nlines" must be a single line`)
+}
+
+func TestEncoderSetDottedKeys(t *testing.T) {
+	type Inner struct {
+		Port int `huml:"port"`
+	}
+	type Config struct {
+		Server struct {
+			HTTP Inner `huml:"http"`
+		} `huml:"server"`
+		Log map[string]any `huml:"log"`
+	}
+
+	in := map[string]any{
+		"a":   map[string]any{"b": map[string]any{"c": int64(1)}},
+		"x.y": map[string]any{"z": int64(2)},
+		"p":   map[string]any{"q.r": int64(3)},
+		"m":   map[string]any{"n": map[string]any{"o": int64(4), "s": int64(5)}},
+		"e":   map[string]any{"f": map[string]any{}},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetDottedKeys(true)
+	assert.NoError(t, enc.Encode(in))
+	assert.Equal(t, `a.b.c: 1
+e.f:: {}
+m.n::
+  o: 4
+  s: 5
+p::
+  "q.r": 3
+"x.y"::
+  z: 2
+`, buf.String())
+
+	var result map[string]any
+	assert.NoError(t, Unmarshal(buf.Bytes(), &result, ExpandDottedKeys()))
+	assert.Equal(t, in, result)
+
+	var cfg Config
+	cfg.Server.HTTP.Port = 80
+	cfg.Log = map[string]any{"level": "info"}
+	buf.Reset()
+	assert.NoError(t, enc.Encode(cfg))
+	assert.Equal(t, "server.http.port: 80\nlog.level: \"info\"\n", buf.String())
+
+	var decoded Config
+	assert.NoError(t, Unmarshal(buf.Bytes(), &decoded, ExpandDottedKeys()))
+	assert.Equal(t, cfg, decoded)
+}
+
+func TestMarshalLike(t *testing.T) {
+	reference := `%HUML v0.1.0
+name: "app"
+ports:: 80, 443
+hosts::
+  - "a"
+  - "b"
+limits:: cpu: 1, mem: "1G"
+servers::
+  - :: host: "a", port: 1
+  - ::
+    host: "b"
+    port: 2
+nested::
+  deep:: 1, 2
+`
+	in := map[string]any{
+		"name":    "app2",
+		"ports":   []int{80, 443, 8080},
+		"hosts":   []string{"a", "b", "c"},
+		"limits":  map[string]any{"cpu": 2, "mem": "2G"},
+		"servers": []map[string]any{{"host": "a", "port": 1}, {"host": "b", "port": 3}, {"host": "c", "port": 4}},
+		"nested":  map[string]any{"deep": []any{[]int{1}}},
+		"added":   []int{1, 2},
+	}
+
+	out, err := MarshalLike(in, []byte(reference))
+	assert.NoError(t, err)
+	// New keys and a vector that can no longer be inline take the default
+	// block form.
+	assert.Equal(t, `%HUML v0.1.0
+added::
+  - 1
+  - 2
+hosts::
+  - "a"
+  - "b"
+  - "c"
+limits:: cpu: 2, mem: "2G"
+name: "app2"
+nested::
+  deep::
+    - ::
+      - 1
+ports:: 80, 443, 8080
+servers::
+  - :: host: "a", port: 1
+  - ::
+    host: "b"
+    port: 3
+  - ::
+    host: "c"
+    port: 4
+`, string(out))
+
+	// A reference without a directive gives a document without one.
+	out, err = MarshalLike(map[string]any{"a": []int{1}}, []byte("a:: 2, 3\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a:: 1\n", string(out))
+
+	_, err = MarshalLike(in, []byte("a: 1\na: 2\n"))
+	assert.EqualError(t, err, "huml: invalid reference: line 2: duplicate key 'a' in dict")
+}
+
+func TestEncodeEmptyAndSpaceKeys(t *testing.T) {
+	f := func(name string, in map[string]any, compact bool, want string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetCompact(compact)
+			assert.NoError(t, enc.Encode(in))
+			assert.Equal(t, want, buf.String())
+
+			var result map[string]any
+			assert.NoError(t, Unmarshal(buf.Bytes(), &result))
+			assert.Equal(t, in, result)
+		})
+	}
+
+	f("empty", map[string]any{"": int64(1)}, false, "\"\": 1\n")
+	f("space", map[string]any{" ": int64(2)}, false, "\" \": 2\n")
+	f("spaces", map[string]any{"  ": int64(3), "": int64(1)}, false, "\"\": 1\n\"  \": 3\n")
+	f("nested", map[string]any{"": map[string]any{" ": "x"}}, false, "\"\"::\n  \" \": \"x\"\n")
+	f("inline", map[string]any{"d": map[string]any{"": int64(1), " ": int64(2)}}, true, "d:: \"\": 1, \" \": 2\n")
+}
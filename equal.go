@@ -0,0 +1,46 @@
+package huml
+
+import (
+	"math"
+	"reflect"
+)
+
+// EqualValues reports whether a and b are deeply equal values as returned by
+// Unmarshal into an any, treating NaN as equal to NaN. reflect.DeepEqual
+// considers NaN unequal to itself, so a document containing nan would never
+// compare equal to itself after a round trip.
+//
+// Maps of type map[string]any, slices of type []any and float64 values are
+// compared as described, and any other values with reflect.DeepEqual.
+func EqualValues(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) || (a == nil) != (b == nil) {
+			return false
+		}
+		for k, av := range a {
+			bv, ok := b[k]
+			if !ok || !EqualValues(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) || (a == nil) != (b == nil) {
+			return false
+		}
+		for i := range a {
+			if !EqualValues(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case float64:
+		b, ok := b.(float64)
+		return ok && (a == b || math.IsNaN(a) && math.IsNaN(b))
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
@@ -0,0 +1,125 @@
+package huml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// A SyntaxError is a syntax error at a known position in a HUML document,
+// such as a misplaced space or a bad indent, for tools that point at the
+// offending spot. Use errors.As to retrieve it from an error returned by the
+// package.
+type SyntaxError struct {
+	Line   int    // Line number (1-based).
+	Column int    // Byte offset of the error in the line (0-based).
+	Msg    string // Description of the error.
+}
+
+// Error returns the message in the same form as other decoding errors,
+// prefixed by the line number only.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// errorContext is the number of lines shown before and after the offending
+// line by FormatError.
+const errorContext = 2
+
+// FormatError renders err, an error returned when decoding data, for
+// display in a terminal. If err holds a SyntaxError, the message is followed
+// by the lines of data around the error, numbered, and a caret under the
+// offending column:
+//
+//	line 3: bad indent 4, expected 2
+//	1 | name: "app"
+//	2 | server::
+//	3 |     port: 8080
+//	  |     ^
+//	4 | debug: true
+//
+// Other errors are rendered as their message alone. The data must be the
+// document as it was decoded; with BaseIndent, columns are counted after the
+// base indentation, so the caret is off by that many spaces.
+func FormatError(data []byte, err error) string {
+	var se *SyntaxError
+	if !errors.As(err, &se) {
+		return err.Error()
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	first := max(se.Line-errorContext, 1)
+	last := min(se.Line+errorContext, len(lines))
+	width := len(fmt.Sprint(last))
+
+	var b strings.Builder
+	b.WriteString(err.Error())
+	for n := first; n <= last; n++ {
+		line := lines[n-1]
+		fmt.Fprintf(&b, "\n%*d |", width, n)
+		if len(line) > 0 {
+			b.WriteByte(' ')
+			b.Write(line)
+		}
+		if n == se.Line {
+			col := utf8.RuneCount(line[:min(max(se.Column, 0), len(line))])
+			fmt.Fprintf(&b, "\n%*s | %s^", width, "", strings.Repeat(" ", col))
+		}
+	}
+	return b.String()
+}
+
+// syntaxErrorf returns a SyntaxError at the position of the token tk.
+func syntaxErrorf(tk Token, format string, args ...any) error {
+	return &SyntaxError{Line: tk.Line, Column: tk.Column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// A TypeError reports a HUML value that cannot be stored in the Go value it
+// is decoded into, such as a string for an int field or a number that
+// overflows it.
+type TypeError struct {
+	Value any          // HUML value, such as a string or an int64.
+	Type  reflect.Type // Type of the Go value.
+	Field string       // Dotted path of keys and indices to the value, or "" for the root.
+
+	err error
+}
+
+// Error returns the description of the mismatch.
+func (e *TypeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error of the type's UnmarshalText method, if any.
+func (e *TypeError) Unwrap() error {
+	return errors.Unwrap(e.err)
+}
+
+// A MissingFieldError reports a union dict that lacks its discriminator
+// field, as given to UnmarshalUnion or RegisterUnion.
+type MissingFieldError struct {
+	Field string // Name of the discriminator field.
+}
+
+// Error returns the description of the missing field.
+func (e *MissingFieldError) Error() string {
+	return fmt.Sprintf("discriminator field %q is missing", e.Field)
+}
+
+// An UnknownFieldError reports a root key outside those given to
+// AllowedRootKeys.
+type UnknownFieldError struct {
+	Line  int    // Line number of the key (1-based).
+	Field string // The key.
+}
+
+// Error returns the description of the unknown field.
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("line %d: unknown root key '%s'", e.Line, e.Field)
+}
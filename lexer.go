@@ -5,25 +5,52 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // lexer tokenizes HUML input from an io.Reader.
 type lexer struct {
 	r *bufio.Reader
 
-	line           []byte  // Current line being processed.
-	lineBuf        []byte  // Reusable buffer for reading lines.
-	lineNum        int     // Current line number (1-based).
-	pos            int     // Position within current line.
-	eof            bool    // True if EOF reached.
-	err            error   // First error encountered.
-	tokens         []Token // Token buffer for lookahead.
-	tokPos         int     // Current position in token buffer.
-	atLineStart    bool    // True if at start of line (for indent tracking).
-	curIndent      int     // Indentation of current line.
-	hadSpaceBefore bool    // True if space was skipped before last scanned token.
-	inMultilineStr bool    // True if currently parsing multiline string content.
-	strBuf         []byte  // Reusable buffer for building strings.
+	line           []byte   // Current line being processed.
+	lineBuf        []byte   // Reusable buffer for reading lines.
+	lineNum        int      // Current line number (1-based).
+	pos            int      // Position within current line.
+	eof            bool     // True if EOF reached.
+	err            error    // First error encountered.
+	tokens         []Token  // Token buffer for lookahead.
+	tokPos         int      // Current position in token buffer.
+	atLineStart    bool     // True if at start of line (for indent tracking).
+	curIndent      int      // Indentation of current line.
+	hadSpaceBefore bool     // True if space was skipped before last scanned token.
+	inMultilineStr bool     // True if currently parsing multiline string content.
+	strBuf         []byte   // Reusable buffer for building strings.
+	indentMultiple int      // Required multiple for content indentation (0 disables).
+	trimTrailing   bool     // Strip trailing spaces instead of rejecting them.
+	strictBlanks   bool     // Reject leading, trailing and consecutive blank lines.
+	blankRun       int      // Number of blank lines since the last non-blank one.
+	seenContent    bool     // True once a non-blank line has been read.
+	bareStrings    bool     // Read unquoted words that aren't keywords as strings.
+	unicodeKeys    bool     // Allow Unicode letters in bare keys.
+	continuations  bool     // Join a quoted string ending a line in '\' with the next line.
+	dottedKeys     bool     // Allow dots between the words of bare keys.
+	leadingDot     bool     // Allow floats that start with a decimal point, such as .5.
+	lenientEscapes bool     // Keep unknown escapes such as \x as they are written.
+	baseIndent     int      // Indentation of the root level, removed from every line.
+	maxLines       int      // Maximum number of lines; 0 means unlimited.
+	hasDirective   bool     // True if the document starts with a %HUML directive.
+	version        string   // Version given by the %HUML directive, if any.
+	header         []string // Comment lines at the start of the document.
+	headerDone     bool     // True once the header can't grow any more.
+
+	// Comment lines are collected so that the parser can attach them to
+	// the content that follows.
+	pendingComments []string // Comment lines seen since the last content line.
+	lineComment     string   // Comment block directly preceding the current line.
+	trailingComment string   // Comment at the end of the line last consumed.
 }
 
 // Pre-defined keyword byte slices to avoid allocations during lexing.
@@ -43,6 +70,9 @@ func newLexer(r io.Reader) *lexer {
 		atLineStart: true,
 		lineBuf:     make([]byte, 0, 256),
 		strBuf:      make([]byte, 0, 64),
+
+		// The spec mandates two-space indentation.
+		indentMultiple: 2,
 	}
 }
 
@@ -99,9 +129,9 @@ func (l *lexer) scan() (Token, error) {
 			return Token{Type: TokenError, Value: err.Error()}, err
 		}
 
-		l.atLineStart = true
-		l.curIndent = l.countIndent()
-		l.pos = l.curIndent
+		if err := l.beginLine(); err != nil {
+			return Token{Type: TokenError}, err
+		}
 	}
 
 	// Skip blank lines and comment-only lines.
@@ -111,6 +141,10 @@ func (l *lexer) scan() (Token, error) {
 			if err := l.validateComment(); err != nil {
 				return Token{Type: TokenError}, err
 			}
+			l.pendingComments = append(l.pendingComments, commentText(l.line[l.pos:]))
+			if !l.headerDone {
+				l.header = append(l.header, commentText(l.line[l.pos:]))
+			}
 
 			// Read next line.
 			if l.eof {
@@ -125,9 +159,9 @@ func (l *lexer) scan() (Token, error) {
 				return Token{Type: TokenError}, err
 			}
 
-			l.atLineStart = true
-			l.curIndent = l.countIndent()
-			l.pos = l.curIndent
+			if err := l.beginLine(); err != nil {
+				return Token{Type: TokenError}, err
+			}
 			continue
 		}
 		break
@@ -151,10 +185,19 @@ func (l *lexer) readLine() error {
 	l.lineBuf = l.lineBuf[:0]
 
 	for {
-		b, err := l.r.ReadByte()
+		// ReadSlice returns the line straight from the reader's buffer. A
+		// line longer than the buffer arrives in several chunks.
+		chunk, err := l.r.ReadSlice('\n')
+		l.lineBuf = append(l.lineBuf, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
 		if err != nil {
 			if err == io.EOF {
 				if len(l.lineBuf) == 0 {
+					if l.strictBlanks && l.blankRun > 0 {
+						return l.errorf("blank lines are not allowed at the end of the document")
+					}
 					return io.EOF
 				}
 				// EOF with data - process as final line.
@@ -163,25 +206,101 @@ func (l *lexer) readLine() error {
 			}
 			return err
 		}
-		if b == '\n' {
-			break
-		}
-		l.lineBuf = append(l.lineBuf, b)
+
+		// Drop the newline.
+		l.lineBuf = l.lineBuf[:len(l.lineBuf)-1]
+		break
 	}
 
 	l.lineNum++
 	l.line = l.lineBuf
 	l.pos = 0
+	if l.maxLines > 0 && l.lineNum > l.maxLines {
+		return l.errorAt(0, "document exceeds %d lines", l.maxLines)
+	}
 
 	// Validate: check for trailing spaces on the line.
 	// Skip this check when inside multiline strings (trailing spaces are content there).
 	if !l.inMultilineStr && len(l.line) > 0 && l.line[len(l.line)-1] == ' ' {
-		return l.errorf("trailing spaces are not allowed")
+		if !l.trimTrailing {
+			return l.errorAt(len(bytes.TrimRight(l.line, " ")), "trailing spaces are not allowed")
+		}
+		l.line = bytes.TrimRight(l.line, " ")
+	}
+
+	if l.baseIndent > 0 {
+		return l.removeBaseIndent()
 	}
+	return nil
+}
 
+// removeBaseIndent removes the base indentation from the current line,
+// which must have at least that many leading spaces unless it is blank.
+func (l *lexer) removeBaseIndent() error {
+	n := 0
+	for n < l.baseIndent && n < len(l.line) && l.line[n] == ' ' {
+		n++
+	}
+	if n < l.baseIndent && n < len(l.line) {
+		return l.errorAt(n, "line is indented less than the base indentation of %d", l.baseIndent)
+	}
+	l.line = l.line[n:]
 	return nil
 }
 
+// beginLine resets the position state for a freshly read line and
+// validates its indentation.
+func (l *lexer) beginLine() error {
+	l.atLineStart = true
+	l.curIndent = l.countIndent()
+	l.pos = l.curIndent
+
+	// A blank line detaches the comments above it from what follows.
+	if l.pos >= len(l.line) {
+		l.pendingComments = l.pendingComments[:0]
+		if len(l.header) > 0 {
+			l.headerDone = true
+		}
+		if l.strictBlanks {
+			if !l.seenContent {
+				return l.errorf("blank lines are not allowed at the start of the document")
+			}
+			if l.blankRun > 0 {
+				return l.errorf("consecutive blank lines are not allowed")
+			}
+		}
+		l.blankRun++
+		return nil
+	}
+	l.blankRun = 0
+	l.seenContent = true
+
+	// Comment lines are not subject to indentation rules.
+	if l.line[l.pos] == '#' {
+		return nil
+	}
+
+	if l.indentMultiple > 0 && l.curIndent%l.indentMultiple != 0 {
+		spaces := "spaces"
+		if l.curIndent == 1 {
+			spaces = "space"
+		}
+		return l.errorf("indentation of %d %s is not a multiple of %d", l.curIndent, spaces, l.indentMultiple)
+	}
+
+	return nil
+}
+
+// commentText returns the text of a comment, without the leading '#' and
+// the single space that follows it.
+func commentText(b []byte) string {
+	b = b[1:]
+	if len(b) > 0 && b[0] == ' ' {
+		b = b[1:]
+	}
+	return string(b)
+}
+
 // countIndent counts leading spaces in the current line.
 func (l *lexer) countIndent() int {
 	indent := 0
@@ -200,12 +319,12 @@ func (l *lexer) validateComment() error {
 
 	// Check for space after #.
 	if l.pos+1 < len(l.line) && l.line[l.pos+1] != ' ' && l.line[l.pos+1] != '\n' {
-		return l.errorf("comment hash '#' must be followed by a space")
+		return l.errorAt(l.pos+1, "comment hash '#' must be followed by a space")
 	}
 
 	// Check for trailing spaces in comment.
 	if len(l.line) > 0 && l.line[len(l.line)-1] == ' ' {
-		return l.errorf("trailing spaces are not allowed")
+		return l.errorAt(len(bytes.TrimRight(l.line, " ")), "trailing spaces are not allowed")
 	}
 
 	return nil
@@ -233,10 +352,18 @@ func (l *lexer) scanToken() (Token, error) {
 	startCol = l.pos
 	c := l.line[l.pos]
 
+	// The first token of a line takes over the comments directly above it.
+	if l.atLineStart {
+		l.atLineStart = false
+		l.lineComment = strings.Join(l.pendingComments, "\n")
+		l.pendingComments = l.pendingComments[:0]
+	}
+
 	// Check for version directive at start of document.
 	if l.lineNum == 1 && l.pos == 0 && l.peekString("%HUML") {
 		return l.scanVersion()
 	}
+	l.headerDone = true
 
 	// List item marker: "- " at start of content.
 	if c == '-' && l.pos == l.curIndent {
@@ -288,7 +415,7 @@ func (l *lexer) scanToken() (Token, error) {
 	}
 
 	// Bare key or keyword.
-	if isAlpha(c) {
+	if l.wordCharLen(l.pos, true) > 0 {
 		return l.scanKeyOrKeyword()
 	}
 
@@ -325,11 +452,18 @@ func (l *lexer) scanToken() (Token, error) {
 	}
 
 	// Number or special float.
-	if isDigit(c) || c == '+' || c == '-' {
+	if isDigit(c) || c == '+' || c == '-' || l.isLeadingDot(l.pos) {
 		return l.scanNumber()
 	}
 
-	return Token{Type: TokenError}, l.errorf("unexpected character '%c'", c)
+	r, _ := utf8.DecodeRune(l.line[l.pos:])
+	return Token{Type: TokenError}, l.errorf("unexpected character '%c'", r)
+}
+
+// isLeadingDot reports whether the current line has a decimal point followed
+// by a digit at pos, which starts a float such as .5 if leadingDot is set.
+func (l *lexer) isLeadingDot(pos int) bool {
+	return l.leadingDot && pos+1 < len(l.line) && l.line[pos] == '.' && isDigit(l.line[pos+1])
 }
 
 // scanVersion scans the %HUML version directive.
@@ -337,12 +471,15 @@ func (l *lexer) scanVersion() (Token, error) {
 	l.pos += len("%HUML")
 
 	// Skip optional space and version.
+	l.hasDirective = true
 	if l.pos < len(l.line) && l.line[l.pos] == ' ' {
 		l.pos++
-		// Skip version string.
+		// Read version string.
+		start := l.pos
 		for l.pos < len(l.line) && l.line[l.pos] != ' ' && l.line[l.pos] != '#' {
 			l.pos++
 		}
+		l.version = string(l.line[start:l.pos])
 	}
 
 	// Validate rest of line.
@@ -366,7 +503,7 @@ func (l *lexer) validateRemaining() error {
 	if l.pos >= len(l.line) {
 		// End of line - check for trailing spaces.
 		if l.pos > spaceStart {
-			return l.errorf("trailing spaces are not allowed")
+			return l.errorAt(spaceStart, "trailing spaces are not allowed")
 		}
 		return nil
 	}
@@ -381,7 +518,7 @@ func (l *lexer) validateRemaining() error {
 
 // scanKeyOrString scans a quoted string, determining if it's a key or value.
 func (l *lexer) scanKeyOrString() (Token, error) {
-	startCol := l.pos
+	startLine, startCol := l.lineNum, l.pos
 	str, err := l.scanQuotedString()
 	if err != nil {
 		return Token{Type: TokenError}, err
@@ -397,7 +534,7 @@ func (l *lexer) scanKeyOrString() (Token, error) {
 		return Token{
 			Type:   TokenQuotedKey,
 			Value:  str,
-			Line:   l.lineNum,
+			Line:   startLine,
 			Column: startCol,
 			Indent: l.curIndent,
 		}, nil
@@ -413,7 +550,8 @@ func (l *lexer) scanKeyOrString() (Token, error) {
 	}, nil
 }
 
-// scanQuotedString scans a double-quoted string with escapes.
+// scanQuotedString scans a double-quoted string with escapes. With line
+// continuations enabled, the string may span several lines.
 func (l *lexer) scanQuotedString() (string, error) {
 	l.pos++ // Consume opening quote.
 
@@ -446,7 +584,18 @@ func (l *lexer) scanQuotedString() (string, error) {
 		if c == '\\' {
 			l.pos++
 			if l.pos >= len(l.line) {
-				return "", l.errorf("incomplete escape sequence")
+				if !l.continuations {
+					return "", l.errorf("incomplete escape sequence")
+				}
+				// A line continuation: the string goes on at the start of
+				// the next line, without a newline.
+				if err := l.readLine(); err != nil {
+					if err == io.EOF {
+						return "", l.errorf("unclosed string")
+					}
+					return "", err
+				}
+				continue
 			}
 
 			switch esc := l.line[l.pos]; esc {
@@ -464,8 +613,17 @@ func (l *lexer) scanQuotedString() (string, error) {
 				l.strBuf = append(l.strBuf, '\t')
 			case 'v':
 				l.strBuf = append(l.strBuf, '\v')
+			case 'u':
+				r, err := l.scanUnicodeEscape()
+				if err != nil {
+					return "", err
+				}
+				l.strBuf = utf8.AppendRune(l.strBuf, r)
 			default:
-				return "", l.errorf("invalid escape character '\\%c'", esc)
+				if !l.lenientEscapes {
+					return "", l.errorf("invalid escape character '\\%c'", esc)
+				}
+				l.strBuf = append(l.strBuf, '\\', esc)
 			}
 		} else {
 			l.strBuf = append(l.strBuf, c)
@@ -476,16 +634,113 @@ func (l *lexer) scanQuotedString() (string, error) {
 	return "", l.errorf("unclosed string")
 }
 
+// scanUnicodeEscape scans the four hex digits of a \uXXXX escape, with l.pos
+// on the 'u'. A UTF-16 surrogate pair written as two escapes is combined into
+// a single rune. l.pos is left on the last consumed hex digit.
+func (l *lexer) scanUnicodeEscape() (rune, error) {
+	r, err := l.scanHex4(l.pos + 1)
+	if err != nil {
+		return 0, err
+	}
+	l.pos += 4
+
+	if utf16.IsSurrogate(r) {
+		if l.pos+2 < len(l.line) && l.line[l.pos+1] == '\\' && l.line[l.pos+2] == 'u' {
+			if r2, err := l.scanHex4(l.pos + 3); err == nil {
+				if dec := utf16.DecodeRune(r, r2); dec != utf8.RuneError {
+					l.pos += 6
+					return dec, nil
+				}
+			}
+		}
+		return utf8.RuneError, nil
+	}
+
+	return r, nil
+}
+
+// scanHex4 parses four hex digits starting at l.line[start].
+func (l *lexer) scanHex4(start int) (rune, error) {
+	if start+4 > len(l.line) {
+		return 0, l.errorf("incomplete unicode escape sequence")
+	}
+
+	var r rune
+	for _, c := range l.line[start : start+4] {
+		var d byte
+		switch {
+		case c >= '0' && c <= '9':
+			d = c - '0'
+		case c >= 'a' && c <= 'f':
+			d = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			return 0, l.errorf("invalid unicode escape sequence '\\u%s'", l.line[start:start+4])
+		}
+		r = r<<4 | rune(d)
+	}
+
+	return r, nil
+}
+
+// invalidKeyChar reports the character at pos, the end of a bare word, if
+// the word and the characters that follow it up to a colon look like a key.
+// Text containing a comma, quote or comment is taken to be something else.
+func (l *lexer) invalidKeyChar(pos int) (rune, bool) {
+	colon := bytes.IndexByte(l.line[pos:], ':')
+	if colon <= 0 {
+		return 0, false
+	}
+	rest := l.line[pos : pos+colon]
+	if len(bytes.TrimLeft(rest, " ")) == 0 || bytes.ContainsAny(rest, ",\"#") {
+		return 0, false
+	}
+	r, _ := utf8.DecodeRune(rest)
+	return r, true
+}
+
+// wordCharLen returns the length in bytes of the character at pos if it can
+// be part of a bare key or keyword, and 0 otherwise. A word starts with a
+// letter, followed by letters, digits, underscores and hyphens. Letters are
+// ASCII, unless unicodeKeys is set, in which case any Unicode letter is
+// accepted, and combining marks after the first character.
+func (l *lexer) wordCharLen(pos int, first bool) int {
+	c := l.line[pos]
+	if c < utf8.RuneSelf {
+		if isAlpha(c) || !first && (isDigit(c) || c == '_' || c == '-') {
+			return 1
+		}
+		return 0
+	}
+	if !l.unicodeKeys {
+		return 0
+	}
+	r, n := utf8.DecodeRune(l.line[pos:])
+	if unicode.IsLetter(r) || !first && unicode.IsMark(r) {
+		return n
+	}
+	return 0
+}
+
 // scanKeyOrKeyword scans a bare identifier.
 func (l *lexer) scanKeyOrKeyword() (Token, error) {
 	startCol := l.pos
 	start := l.pos
 
-	for l.pos < len(l.line) && (isAlphaNum(l.line[l.pos]) || l.line[l.pos] == '_' || l.line[l.pos] == '-') {
-		l.pos++
+	for l.pos < len(l.line) {
+		n := l.wordCharLen(l.pos, false)
+		if n == 0 && l.dottedKeys && l.line[l.pos] == '.' && l.pos+1 < len(l.line) && l.wordCharLen(l.pos+1, true) > 0 {
+			n = 1 // A dot between words of a dotted key.
+		}
+		if n == 0 {
+			break
+		}
+		l.pos += n
 	}
 
 	wb := l.line[start:l.pos]
+	end := l.pos
 
 	// Skip spaces after word.
 	for l.pos < len(l.line) && l.line[l.pos] == ' ' {
@@ -503,6 +758,12 @@ func (l *lexer) scanKeyOrKeyword() (Token, error) {
 		}, nil
 	}
 
+	// A word followed by other characters and then a colon is most likely a
+	// key with characters that need quoting, such as "a.b: 1".
+	if c, ok := l.invalidKeyChar(end); ok {
+		return Token{Type: TokenError}, l.errorf("invalid character '%c' in key; quote the key if it contains special characters", c)
+	}
+
 	// Check for keywords using pre-defined byte slices (no allocation).
 	var (
 		tkType TokenType
@@ -521,7 +782,12 @@ func (l *lexer) scanKeyOrKeyword() (Token, error) {
 	case bytes.Equal(wb, kwInf):
 		tkType, tkVal = TokenInf, "+"
 	default:
-		return Token{Type: TokenError}, l.errorf("unquoted string '%s' is not allowed", string(wb))
+		if !l.bareStrings {
+			return Token{Type: TokenError}, l.errorf("unquoted string '%s' is not allowed", string(wb))
+		}
+		// Spaces after the word are left for the caller to validate.
+		l.pos = end
+		tkType, tkVal = TokenString, string(wb)
 	}
 
 	return Token{
@@ -559,7 +825,12 @@ func (l *lexer) scanNumber() (Token, error) {
 			}, nil
 		}
 
-		if l.pos >= len(l.line) || !isDigit(l.line[l.pos]) {
+		// NaN has a sign bit, but HUML has a single nan without a sign.
+		if l.peekString("nan") && (l.pos+3 == len(l.line) || l.wordCharLen(l.pos+3, false) == 0) {
+			return Token{Type: TokenError}, l.errorAt(startCol, "signed nan is not permitted; use 'nan'")
+		}
+
+		if l.pos >= len(l.line) || !isDigit(l.line[l.pos]) && !l.isLeadingDot(l.pos) {
 			return Token{Type: TokenError}, l.errorf("invalid char after '%c'", sign)
 		}
 	}
@@ -585,7 +856,7 @@ func (l *lexer) scanNumber() (Token, error) {
 		} else if c == '.' {
 			isFloat = true
 			l.pos++
-		} else if c == 'e' || c == 'E' {
+		} else if (c == 'e' || c == 'E') && l.isExponent() {
 			isFloat = true
 			l.pos++
 			if l.pos < len(l.line) && (l.line[l.pos] == '+' || l.line[l.pos] == '-') {
@@ -596,8 +867,16 @@ func (l *lexer) scanNumber() (Token, error) {
 		}
 	}
 
+	if err := l.checkNumberSuffix(start); err != nil {
+		return Token{Type: TokenError}, err
+	}
+
 	numStr := string(l.line[start:l.pos])
 	if isFloat {
+		// Underscores may only separate digits, in the mantissa or the exponent.
+		if !validUnderscores(l.line[start:l.pos]) {
+			return Token{Type: TokenError}, l.errorf("invalid underscore in float '%s', underscores must be between digits", numStr)
+		}
 		return Token{
 			Type:   TokenFloat,
 			Value:  numStr,
@@ -616,6 +895,49 @@ func (l *lexer) scanNumber() (Token, error) {
 	}, nil
 }
 
+// validUnderscores reports whether every underscore in the number b is
+// directly surrounded by digits.
+func validUnderscores(b []byte) bool {
+	for i, c := range b {
+		if c != '_' {
+			continue
+		}
+		if i == 0 || i == len(b)-1 || !isDigit(b[i-1]) || !isDigit(b[i+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isExponent reports whether the 'e' or 'E' at the current position starts
+// an exponent, with a digit or underscore after it or after its sign, rather
+// than a suffix such as in `5em`.
+func (l *lexer) isExponent() bool {
+	i := l.pos + 1
+	if i < len(l.line) && (l.line[i] == '+' || l.line[i] == '-') {
+		i++
+	}
+	return i < len(l.line) && (isDigit(l.line[i]) || l.line[i] == '_')
+}
+
+// checkNumberSuffix reports letters directly after the number that started
+// at start, as in `100ms` or `10GB`, which are likely meant as durations or
+// sizes rather than as the number followed by junk.
+func (l *lexer) checkNumberSuffix(start int) error {
+	if l.pos >= len(l.line) || !isAlpha(l.line[l.pos]) {
+		return nil
+	}
+
+	end := l.pos
+	for end < len(l.line) && (isAlphaNum(l.line[end]) || l.line[end] == '_') {
+		end++
+	}
+	return l.errorf(
+		"unexpected '%s' after number '%s', values with units such as durations must be quoted strings",
+		l.line[l.pos:end], l.line[start:l.pos],
+	)
+}
+
 // scanBaseNumber scans a number with a base prefix (0x, 0o, 0b).
 func (l *lexer) scanBaseNumber(start, startCol int, isValidDigit func(byte) bool) (Token, error) {
 	l.pos += 2
@@ -629,6 +951,26 @@ func (l *lexer) scanBaseNumber(start, startCol int, isValidDigit func(byte) bool
 		return Token{Type: TokenError}, l.errorf("invalid number literal, requires digits after prefix")
 	}
 
+	// A fraction or exponent would make this a hex, octal or binary float,
+	// which HUML does not have. Name it rather than stopping at the '.'.
+	if l.pos < len(l.line) {
+		switch l.line[l.pos] {
+		case '.', 'e', 'E', 'p', 'P':
+			end := l.pos
+			for end < len(l.line) && (isAlphaNum(l.line[end]) || l.line[end] == '.' || l.line[end] == '_') {
+				end++
+			}
+			return Token{Type: TokenError}, l.errorf(
+				"invalid number '%s', hexadecimal, octal and binary floats are not supported",
+				l.line[start:end],
+			)
+		}
+	}
+
+	if err := l.checkNumberSuffix(start); err != nil {
+		return Token{Type: TokenError}, err
+	}
+
 	return Token{
 		Type:   TokenInt,
 		Value:  string(l.line[start:l.pos]),
@@ -638,6 +980,15 @@ func (l *lexer) scanBaseNumber(start, startCol int, isValidDigit func(byte) bool
 	}, nil
 }
 
+// countQuotes returns the number of consecutive '"' at the current position.
+func (l *lexer) countQuotes() int {
+	n := 0
+	for l.pos+n < len(l.line) && l.line[l.pos+n] == '"' {
+		n++
+	}
+	return n
+}
+
 // scanMultilineString scans a multiline string starting with """.
 // Per the v0.2.0 spec, the content block must be indented by one level (2 spaces)
 // relative to the key. These initial 2 spaces on each line are stripped.
@@ -645,9 +996,14 @@ func (l *lexer) scanBaseNumber(start, startCol int, isValidDigit func(byte) bool
 func (l *lexer) scanMultilineString(keyIndent int) (Token, error) {
 	startLine := l.lineNum
 	startCol := l.pos
-	l.pos += 3 // Consume """
 
-	// Rest of line after """ must be empty or comment.
+	// The opening fence is three or more quotes, and the string is closed
+	// by the first line starting with at least as many. A longer fence lets
+	// the content contain lines starting with """.
+	fence := l.countQuotes()
+	l.pos += fence
+
+	// Rest of line after the fence must be empty or comment.
 	if err := l.validateRemaining(); err != nil {
 		return Token{Type: TokenError}, err
 	}
@@ -665,10 +1021,13 @@ func (l *lexer) scanMultilineString(keyIndent int) (Token, error) {
 		if err := l.readLine(); err != nil {
 			if err == io.EOF {
 				l.eof = true
-				return Token{Type: TokenError}, fmt.Errorf(
-					"line %d: unclosed multiline string",
-					startLine,
-				)
+				// The current line is the end of the input, so point at
+				// the opening delimiter to make it easy to find.
+				return Token{Type: TokenError}, &SyntaxError{
+					Line:   startLine,
+					Column: startCol,
+					Msg:    "multiline string is never closed",
+				}
 			}
 
 			return Token{Type: TokenError}, err
@@ -677,16 +1036,25 @@ func (l *lexer) scanMultilineString(keyIndent int) (Token, error) {
 		lineIndent := l.countIndent()
 		l.pos = lineIndent
 
-		if l.peekString(`"""`) {
+		if l.countQuotes() >= fence {
+			// The closing delimiter line is not content, so it may be
+			// trimmed like any other line.
+			if l.trimTrailing {
+				l.line = bytes.TrimRight(l.line, " ")
+			}
 			if lineIndent != keyIndent {
 				return Token{Type: TokenError}, l.errorf(
 					"multiline closing delimiter must be at same indentation as the key (%d spaces)",
 					keyIndent,
 				)
 			}
-			l.pos += 3
+			l.pos += fence
 
 			if err := l.validateRemaining(); err != nil {
+				// Malformed comments and trailing spaces keep their own errors.
+				if l.pos >= len(l.line) || l.line[l.pos] == '#' {
+					return Token{Type: TokenError}, err
+				}
 				return Token{Type: TokenError}, l.errorf(
 					"invalid content after multiline string closing delimiter",
 				)
@@ -729,7 +1097,7 @@ func (l *lexer) consumeLine() error {
 
 	if l.pos >= len(l.line) {
 		if l.pos > spaceStart {
-			return l.errorf("trailing spaces are not allowed")
+			return l.errorAt(spaceStart, "trailing spaces are not allowed")
 		}
 		l.line = nil
 		return nil
@@ -739,6 +1107,7 @@ func (l *lexer) consumeLine() error {
 		if err := l.validateComment(); err != nil {
 			return err
 		}
+		l.trailingComment = commentText(l.line[l.pos:])
 		l.line = nil
 		return nil
 	}
@@ -761,9 +1130,14 @@ func (l *lexer) peekString(s string) bool {
 	return true
 }
 
-// errorf creates an error with line number.
+// errorf returns a SyntaxError at the current position of the lexer.
 func (l *lexer) errorf(format string, args ...any) error {
-	return fmt.Errorf("line %d: "+format, append([]any{l.lineNum}, args...)...)
+	return l.errorAt(min(l.pos, len(l.line)), format, args...)
+}
+
+// errorAt returns a SyntaxError at the given column of the current line.
+func (l *lexer) errorAt(col int, format string, args ...any) error {
+	return &SyntaxError{Line: l.lineNum, Column: col, Msg: fmt.Sprintf(format, args...)}
 }
 
 // currentIndent returns the indentation of the current line.
@@ -773,6 +1147,11 @@ func (l *lexer) currentIndent() int {
 
 // atEndOfLine returns true if at end of logical content on line.
 func (l *lexer) atEndOfLine() bool {
+	// A token buffered by peek is content that has not been consumed yet.
+	if l.tokPos < len(l.tokens) && l.tokens[l.tokPos].Line == l.lineNum && l.tokens[l.tokPos].Type != TokenEOF {
+		return false
+	}
+
 	// Skip spaces.
 	pos := l.pos
 	for pos < len(l.line) && l.line[pos] == ' ' {
@@ -784,11 +1163,11 @@ func (l *lexer) atEndOfLine() bool {
 // skipRequiredSpace consumes exactly one required space.
 func (l *lexer) skipRequiredSpace(context string) error {
 	if l.pos >= len(l.line) || l.line[l.pos] != ' ' {
-		return l.errorf("expected single space %s", context)
+		return l.errorAt(l.pos, "expected single space %s", context)
 	}
 	l.pos++
 	if l.pos < len(l.line) && l.line[l.pos] == ' ' {
-		return l.errorf("expected single space %s, found multiple", context)
+		return l.errorAt(l.pos, "expected single space %s, found multiple", context)
 	}
 	return nil
 }
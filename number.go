@@ -0,0 +1,71 @@
+package huml
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Number is a HUML number kept as the text it was written as, such as 1.50,
+// 100.0 or 1e3, so that it can be written back without being normalized as a
+// float64 would be, to 1.5, 100 and 1000.
+//
+// When decoding, a Number receives the text of a float as written, without
+// underscores, and an integer in decimal.
+// When encoding, a Number is written as is, and must hold a valid HUML
+// number, including nan, inf and -inf.
+type Number string
+
+var numberType = reflect.TypeFor[Number]()
+
+// String returns the text of the number.
+func (n Number) String() string {
+	return string(n)
+}
+
+// Float64 returns the number as a float64.
+func (n Number) Float64() (float64, error) {
+	switch n {
+	case "nan":
+		return math.NaN(), nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	switch v, _ := parseNumberLiteral(string(n)); v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("huml: invalid number %q", n)
+}
+
+// Int64 returns the number as an int64, read as a HUML integer, so that 010
+// is 10. It fails for a number that is not an integer.
+func (n Number) Int64() (int64, error) {
+	if v, ok := parseNumberLiteral(string(n)); ok {
+		if i, ok := v.(int64); ok {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("huml: %q is not an integer", n)
+}
+
+// valid reports whether n is a single HUML number.
+func (n Number) valid() bool {
+	if n == "" || strings.ContainsAny(string(n), "\r\n#") {
+		return false
+	}
+	var v any
+	if err := Unmarshal([]byte(n), &v); err != nil {
+		return false
+	}
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package huml
 import (
 	"fmt"
 	"math"
+	"reflect"
 	"strconv"
 	"strings"
 )
@@ -10,27 +11,79 @@ import (
 // streamParser parses tokens into HUML values.
 type streamParser struct {
 	lexer *lexer
+
+	// comments maps multi-line dicts, by map pointer, to the comment block
+	// directly preceding their first entry.
+	comments map[uintptr]string
+
+	depth    int // Number of vectors currently open below the root.
+	maxDepth int // Maximum nesting depth; 0 means unlimited.
+
+	allowedRootKeys map[string]struct{} // Permitted root dict keys; nil allows any.
+	emptyVector     EmptyVector         // Meaning of a '::' with no content.
+	noInline        bool                // Reject inline vectors other than [] and {}.
+
+	elements    int // Number of dict keys and list items read so far.
+	maxElements int // Maximum number of elements; 0 means unlimited.
+
+	// dottedDicts holds the dicts created by expanding dotted keys, by map
+	// pointer, which other dotted keys may add to.
+	dottedDicts map[uintptr]struct{}
+
+	// inlineVectors records the non-empty vectors written inline, by map or
+	// slice pointer, if it is not nil.
+	inlineVectors map[uintptr]struct{}
+
+	// quotedKeys records the dict keys written in quotes, by map pointer and
+	// key, if it is not nil.
+	quotedKeys map[valueRef]struct{}
+
+	// literals holds the text of floats as written, without underscores, by
+	// container and key, for destinations that decode from text and would
+	// lose digits or trailing zeros through a float64.
+	literals map[valueRef]string
+	literal  string // Text of the last float parsed.
+
+	// lineComments holds the comments at the end of the lines of multi-line
+	// dict entries with scalar values, by map pointer and key.
+	lineComments map[valueRef]string
+	lineComment  string // Comment of the entry being stored, if any.
+}
+
+// valueRef locates a value within the parsed document: the entry key of a
+// dict, or the index of a list item, by map or slice pointer. The root value
+// is the zero valueRef.
+type valueRef struct {
+	container uintptr
+	key       string
 }
 
+// defaultMaxDepth is the nesting limit of a new parser. It is far deeper than
+// any real document, but keeps pathological inputs from growing the stack
+// without bound.
+const defaultMaxDepth = 1000
+
 // newStreamParser creates a new parser from a lexer.
 func newStreamParser(l *lexer) *streamParser {
-	return &streamParser{lexer: l}
+	return &streamParser{lexer: l, maxDepth: defaultMaxDepth}
 }
 
 // parse parses the entire document and returns the result.
 func (p *streamParser) parse() (any, error) {
+	p.dottedDicts = nil
+
 	tk, err := p.lexer.peek()
 	if err != nil {
 		return nil, err
 	}
 
 	if tk.Type == TokenEOF {
-		return nil, fmt.Errorf("empty document is undefined")
+		return nil, p.emptyDocumentError()
 	}
 
 	// Root element must not be indented.
 	if tk.Indent != 0 {
-		return nil, fmt.Errorf("line %d: root element must not be indented", tk.Line)
+		return nil, syntaxErrorf(tk, "root element must not be indented")
 	}
 
 	// Determine root type and parse.
@@ -64,12 +117,19 @@ func (p *streamParser) parse() (any, error) {
 		return p.assertRootEnd(map[string]any{}, "root dict")
 
 	case typeMultilineList:
-		return p.parseMultilineList(0)
+		result, err = p.parseMultilineList(0)
+		if err != nil {
+			return result, err
+		}
+		return p.assertRootEnd(result, "root list")
 
 	case typeMultilineDict:
 		return p.parseMultilineDict(0)
 
 	case typeInlineList:
+		if err := p.checkInline("list", tk); err != nil {
+			return nil, err
+		}
 		result, err = p.parseInlineList()
 		if err != nil {
 			return nil, err
@@ -81,7 +141,10 @@ func (p *streamParser) parse() (any, error) {
 		return p.assertRootEnd(result, "root inline list")
 
 	case typeInlineDict:
-		result, err = p.parseInlineDict()
+		if err := p.checkInline("dict", tk); err != nil {
+			return nil, err
+		}
+		result, err = p.parseInlineDict(true)
 		if err != nil {
 			return nil, err
 		}
@@ -96,6 +159,36 @@ func (p *streamParser) parse() (any, error) {
 	}
 }
 
+// checkInline returns an error if inline vectors are disallowed. kind is
+// "list" or "dict".
+func (p *streamParser) checkInline(kind string, tk Token) error {
+	if !p.noInline {
+		return nil
+	}
+	return syntaxErrorf(tk, "inline %s is not allowed, use the multi-line form", kind)
+}
+
+// countElement counts a dict key or list item, returning an error if the
+// document has more than maxElements of them.
+func (p *streamParser) countElement() error {
+	p.elements++
+	if p.maxElements > 0 && p.elements > p.maxElements {
+		return p.lexer.errorf("maximum number of elements of %d exceeded", p.maxElements)
+	}
+	return nil
+}
+
+// checkRootKey returns an error if key is not permitted in the root dict.
+func (p *streamParser) checkRootKey(key string, line int) error {
+	if p.allowedRootKeys == nil {
+		return nil
+	}
+	if _, ok := p.allowedRootKeys[key]; !ok {
+		return &UnknownFieldError{Line: line, Field: key}
+	}
+	return nil
+}
+
 // parseRootScalar parses a scalar value at root level.
 func (p *streamParser) parseRootScalar() (any, error) {
 	tk, err := p.lexer.peek()
@@ -118,6 +211,9 @@ func (p *streamParser) parseRootScalar() (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	if lit := p.takeLiteral(val); lit != "" {
+		p.recordLiteral(0, "", lit)
+	}
 
 	if err := p.lexer.consumeLine(); err != nil {
 		return nil, err
@@ -197,9 +293,21 @@ func (p *streamParser) hasVectorIndicatorAfterKey() bool {
 // hasCommaOnLine checks if there's a comma on the current line.
 func (p *streamParser) hasCommaOnLine() bool {
 	// Scan through the current line looking for comma (read-only, no state changes).
-	for i := p.lexer.pos; i < len(p.lexer.line); i++ {
-		if p.lexer.line[i] == ',' {
+	// Commas within quoted strings or a trailing comment don't count.
+	line := p.lexer.line
+	for i := p.lexer.pos; i < len(line); i++ {
+		switch line[i] {
+		case ',':
 			return true
+		case '#':
+			return false
+		case '"':
+			// Skip to the closing quote, past any escaped characters.
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
 		}
 	}
 	return false
@@ -221,19 +329,20 @@ func (p *streamParser) assertRootEnd(val any, description string) (any, error) {
 		return nil, err
 	}
 	if tk.Type != TokenEOF {
-		return nil, fmt.Errorf("line %d: unexpected content after %s", tk.Line, description)
+		return nil, syntaxErrorf(tk, "unexpected content after %s", description)
 	}
 	return val, nil
 }
 
 // parseMultilineDict parses a multi-line dict at a given indentation level.
+// On error, it also returns the entries parsed so far.
 func (p *streamParser) parseMultilineDict(indent int) (any, error) {
 	out := make(map[string]any, 8) // Pre-allocate for common case.
 
 	for {
 		tk, err := p.lexer.peek()
 		if err != nil {
-			return nil, err
+			return out, err
 		}
 
 		// End conditions.
@@ -246,26 +355,40 @@ func (p *streamParser) parseMultilineDict(indent int) (any, error) {
 
 		// Validate indentation.
 		if tk.Indent != indent {
-			return nil, fmt.Errorf("line %d: bad indent %d, expected %d", tk.Line, tk.Indent, indent)
+			return out, syntaxErrorf(tk, "bad indent %d, expected %d", tk.Indent, indent)
 		}
 
 		// Expect a key.
 		if tk.Type != TokenKey && tk.Type != TokenQuotedKey {
-			return nil, fmt.Errorf("line %d: invalid character, expected key", tk.Line)
+			return out, syntaxErrorf(tk, "invalid character, expected key")
+		}
+
+		// The comment block above the first entry documents the dict.
+		if len(out) == 0 && p.lexer.lineComment != "" && tk.Line == p.lexer.lineNum {
+			p.recordComment(out, p.lexer.lineComment)
 		}
 
 		// Consume key.
 		keyTk, _ := p.lexer.next()
 		key := keyTk.Value
 
-		if _, exists := out[key]; exists {
-			return nil, fmt.Errorf("line %d: duplicate key '%s' in dict", keyTk.Line, key)
+		// A dotted key is checked as it is stored.
+		if _, exists := out[key]; exists && !p.isDottedKey(keyTk) {
+			return out, syntaxErrorf(keyTk, "duplicate key '%s' in dict", key)
+		}
+		if indent == 0 {
+			if err := p.checkRootKey(p.rootKey(keyTk), keyTk.Line); err != nil {
+				return out, err
+			}
+		}
+		if err := p.countElement(); err != nil {
+			return out, err
 		}
 
 		// Expect indicator.
 		indTk, err := p.lexer.next()
 		if err != nil {
-			return nil, err
+			return out, err
 		}
 
 		var val any
@@ -273,83 +396,257 @@ func (p *streamParser) parseMultilineDict(indent int) (any, error) {
 		case TokenScalarInd:
 			// Check for required space after :.
 			if err := p.lexer.skipRequiredSpace("after ':'"); err != nil {
-				return nil, err
+				return out, err
 			}
 
-			// Parse scalar value.
+			// Parse scalar value, and the comment that ends its line.
+			p.lexer.trailingComment = ""
 			val, err = p.parseScalarValue(indent)
-			if err != nil {
-				return nil, err
-			}
+			p.lineComment = p.lexer.trailingComment
 		case TokenVectorInd:
 			// Vector value.
 			val, err = p.parseVector(indent + 2)
-			if err != nil {
-				return nil, err
-			}
 		default:
-			return nil, fmt.Errorf("line %d: expected ':' or '::' after key", indTk.Line)
+			return out, syntaxErrorf(indTk, "expected ':' or '::' after key")
+		}
+		if err != nil {
+			if val != nil {
+				p.setEntry(out, keyTk, val)
+			}
+			return out, err
 		}
 
-		out[key] = val
+		if err := p.setEntry(out, keyTk, val); err != nil {
+			return out, err
+		}
 	}
 
 	return out, nil
 }
 
+// rootKey returns the key of the root dict that keyTk sets, which for a
+// dotted key is its first part.
+func (p *streamParser) rootKey(keyTk Token) string {
+	if p.isDottedKey(keyTk) {
+		key, _, _ := strings.Cut(keyTk.Value, ".")
+		return key
+	}
+	return keyTk.Value
+}
+
+// isDottedKey reports whether keyTk is a bare key with dots to be expanded.
+func (p *streamParser) isDottedKey(keyTk Token) bool {
+	return p.lexer.dottedKeys && keyTk.Type == TokenKey && strings.Contains(keyTk.Value, ".")
+}
+
+// setEntry stores val under the key of keyTk in the dict out. A dotted key
+// such as a.b.c is stored as nested dicts, which are shared by the dotted keys
+// with the same prefix, but can't be added to a dict written out in full.
+func (p *streamParser) setEntry(out map[string]any, keyTk Token, val any) error {
+	if !p.isDottedKey(keyTk) {
+		out[keyTk.Value] = val
+		p.recordEntry(out, keyTk.Value, val)
+		if keyTk.Type == TokenQuotedKey && p.quotedKeys != nil {
+			p.quotedKeys[valueRef{reflect.ValueOf(out).Pointer(), keyTk.Value}] = struct{}{}
+		}
+		return nil
+	}
+
+	parts := strings.Split(keyTk.Value, ".")
+	m := out
+	for _, part := range parts[:len(parts)-1] {
+		existing, ok := m[part]
+		if !ok {
+			next := make(map[string]any)
+			if p.dottedDicts == nil {
+				p.dottedDicts = make(map[uintptr]struct{})
+			}
+			p.dottedDicts[reflect.ValueOf(next).Pointer()] = struct{}{}
+			m[part] = next
+			m = next
+			continue
+		}
+		next, ok := existing.(map[string]any)
+		if !ok {
+			return syntaxErrorf(keyTk, "duplicate key '%s' in dict", part)
+		}
+		if _, ok := p.dottedDicts[reflect.ValueOf(next).Pointer()]; !ok {
+			return syntaxErrorf(keyTk, "duplicate key '%s' in dict", part)
+		}
+		m = next
+	}
+
+	last := parts[len(parts)-1]
+	if _, exists := m[last]; exists {
+		return syntaxErrorf(keyTk, "duplicate key '%s' in dict", keyTk.Value)
+	}
+	m[last] = val
+	p.recordEntry(m, last, val)
+	return nil
+}
+
+// recordEntry records the literal text of the value val stored under key in
+// the dict m, and the comment at the end of its line, if any.
+func (p *streamParser) recordEntry(m map[string]any, key string, val any) {
+	lit, comment := p.takeLiteral(val), p.lineComment
+	if lit == "" && comment == "" {
+		return
+	}
+	p.lineComment = ""
+	ptr := reflect.ValueOf(m).Pointer()
+	if lit != "" {
+		p.recordLiteral(ptr, key, lit)
+	}
+	if comment != "" {
+		if p.lineComments == nil {
+			p.lineComments = make(map[valueRef]string)
+		}
+		p.lineComments[valueRef{ptr, key}] = comment
+	}
+}
+
+// recordComment attaches a comment to the dict m.
+func (p *streamParser) recordComment(m map[string]any, comment string) {
+	if p.comments == nil {
+		p.comments = make(map[uintptr]string)
+	}
+	p.comments[reflect.ValueOf(m).Pointer()] = comment
+}
+
 // parseMultilineList parses a multi-line list at a given indentation level.
+// On error, it also returns the items parsed so far.
 func (p *streamParser) parseMultilineList(indent int) (any, error) {
 	out := make([]any, 0, 8) // Pre-allocate for common case.
+	var lits map[int]string
 
 	for {
-		tk, err := p.lexer.peek()
+		val, ok, err := p.parseListItem(indent)
 		if err != nil {
-			return nil, err
-		}
-
-		// End conditions.
-		if tk.Type == TokenEOF {
-			break
+			if val != nil {
+				out = append(out, val)
+			}
+			return out, err
 		}
-		if tk.Indent < indent {
+		if !ok {
 			break
 		}
 
-		// Validate indentation.
-		if tk.Indent != indent {
-			return nil, fmt.Errorf("line %d: bad indent %d, expected %d", tk.Line, tk.Indent, indent)
+		if lit := p.takeLiteral(val); lit != "" {
+			if lits == nil {
+				lits = make(map[int]string)
+			}
+			lits[len(out)] = lit
 		}
+		out = append(out, val)
+	}
 
-		// Expect list item marker.
-		if tk.Type != TokenListItem {
-			break
-		}
+	p.recordListLiterals(out, lits)
+	return out, nil
+}
 
-		// Consume list item marker.
-		p.lexer.next()
+// parseListItem parses the next item of a multi-line list at a given
+// indentation level. It returns false if the list has ended.
+func (p *streamParser) parseListItem(indent int) (any, bool, error) {
+	tk, err := p.lexer.peek()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// End conditions.
+	if tk.Type == TokenEOF || tk.Indent < indent {
+		return nil, false, nil
+	}
 
-		// Check for nested vector.
-		nextTk, err := p.lexer.peek()
+	// Validate indentation.
+	if tk.Indent != indent {
+		return nil, false, syntaxErrorf(tk, "bad indent %d, expected %d", tk.Indent, indent)
+	}
+
+	// Expect list item marker.
+	if tk.Type != TokenListItem {
+		return nil, false, nil
+	}
+
+	// Consume list item marker.
+	p.lexer.next()
+	if err := p.countElement(); err != nil {
+		return nil, false, err
+	}
+
+	// Check for nested vector.
+	nextTk, err := p.lexer.peek()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var val any
+	if nextTk.Type == TokenVectorInd {
+		p.lexer.next() // Consume ::
+		// After "- ::", content is at indent + 2 (one level deeper than list item).
+		val, err = p.parseVector(indent + 2)
+	} else {
+		val, err = p.parseListItemValue(indent)
+	}
+	return val, err == nil, err
+}
+
+// emptyDocumentError returns the error for a document without content. The
+// spec leaves an empty document undefined, and a document holding just a
+// %HUML directive is no different, but gets its own message.
+func (p *streamParser) emptyDocumentError() error {
+	if p.lexer.hasDirective {
+		return fmt.Errorf("document contains only a version directive and no content")
+	}
+	return fmt.Errorf("empty document is undefined")
+}
+
+// parseEach parses a document whose root is a list, calling fn with each
+// item. The items of a multi-line list are parsed one at a time, so that only
+// the current one is held in memory. An error from fn stops parsing and is
+// returned.
+func (p *streamParser) parseEach(fn func(any) error) error {
+	tk, err := p.lexer.peek()
+	if err != nil {
+		return err
+	}
+	rootType, err := p.inferRootType()
+	if err != nil {
+		return err
+	}
+
+	// Other lists are confined to a line and are parsed whole.
+	if rootType != typeMultilineList || tk.Indent != 0 {
+		val, err := p.parse()
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		var val any
-		if nextTk.Type == TokenVectorInd {
-			p.lexer.next() // Consume ::
-			// After "- ::", content is at indent + 2 (one level deeper than list item).
-			val, err = p.parseVector(indent + 2)
-		} else {
-			val, err = p.parseListItemValue(indent)
+		items, ok := val.([]any)
+		if !ok {
+			return fmt.Errorf("root value is a %T, not a list", val)
 		}
-		if err != nil {
-			return nil, err
+		for _, item := range items {
+			if err := fn(item); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
 
-		out = append(out, val)
+	for {
+		val, ok, err := p.parseListItem(0)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if err := fn(val); err != nil {
+			return err
+		}
 	}
 
-	return out, nil
+	_, err = p.assertRootEnd(nil, "root list")
+	return err
 }
 
 // parseListItemValue parses a value after "- ".
@@ -383,6 +680,12 @@ func (p *streamParser) parseListItemValue(indent int) (any, error) {
 
 // parseVector parses a vector after the :: indicator.
 func (p *streamParser) parseVector(indent int) (any, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		return nil, p.lexer.errorf("maximum nesting depth of %d exceeded", p.maxDepth)
+	}
+
 	// Check if inline (space follows) or multiline (newline/comment follows).
 	if p.lexer.atEndOfLine() {
 		// Multiline vector.
@@ -396,7 +699,13 @@ func (p *streamParser) parseVector(indent int) (any, error) {
 		}
 
 		if tk.Type == TokenEOF || tk.Indent < indent {
-			return nil, fmt.Errorf("line %d: ambiguous empty vector after '::'. Use [] or {}.", tk.Line)
+			switch p.emptyVector {
+			case EmptyVectorDict:
+				return map[string]any{}, nil
+			case EmptyVectorList:
+				return []any{}, nil
+			}
+			return nil, syntaxErrorf(tk, "ambiguous empty vector after '::'. Use [] or {}.")
 		}
 
 		if tk.Type == TokenListItem {
@@ -411,7 +720,13 @@ func (p *streamParser) parseVector(indent int) (any, error) {
 		return nil, err
 	}
 
-	return p.parseInlineVectorValue()
+	val, err := p.parseInlineVectorValue()
+	if err == nil && p.inlineVectors != nil {
+		if v := reflect.ValueOf(val); (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.Len() > 0 {
+			p.inlineVectors[v.Pointer()] = struct{}{}
+		}
+	}
+	return val, err
 }
 
 // parseInlineVectorValue parses an inline vector ([], {}, or comma-separated values).
@@ -431,8 +746,14 @@ func (p *streamParser) parseInlineVectorValue() (any, error) {
 		p.lexer.next()
 		val = map[string]any{}
 	case TokenKey, TokenQuotedKey:
-		val, err = p.parseInlineDict()
+		if err := p.checkInline("dict", tk); err != nil {
+			return nil, err
+		}
+		val, err = p.parseInlineDict(false)
 	default:
+		if err := p.checkInline("list", tk); err != nil {
+			return nil, err
+		}
 		val, err = p.parseInlineList()
 	}
 
@@ -445,8 +766,9 @@ func (p *streamParser) parseInlineVectorValue() (any, error) {
 	return val, nil
 }
 
-// parseInlineDict parses an inline dict (key: val, key: val).
-func (p *streamParser) parseInlineDict() (map[string]any, error) {
+// parseInlineDict parses an inline dict (key: val, key: val). Its keys are
+// checked against the allowed root keys if root is set.
+func (p *streamParser) parseInlineDict(root bool) (map[string]any, error) {
 	out := make(map[string]any, 4) // Pre-allocate for common case.
 	isFirst := true
 
@@ -472,7 +794,7 @@ func (p *streamParser) parseInlineDict() (map[string]any, error) {
 			}
 			// Check for space before comma.
 			if tk.SpaceBefore {
-				return nil, p.lexer.errorf("no spaces allowed before comma")
+				return nil, p.spaceBeforeCommaError(tk)
 			}
 			p.lexer.next() // Consume comma.
 
@@ -490,14 +812,22 @@ func (p *streamParser) parseInlineDict() (map[string]any, error) {
 
 		// Expect key.
 		if tk.Type != TokenKey && tk.Type != TokenQuotedKey {
-			return nil, fmt.Errorf("line %d: expected key in inline dict", tk.Line)
+			return nil, syntaxErrorf(tk, "expected key in inline dict")
 		}
 
 		keyTk, _ := p.lexer.next()
 		key := keyTk.Value
 
-		if _, exists := out[key]; exists {
-			return nil, fmt.Errorf("line %d: duplicate key '%s' in dict", keyTk.Line, key)
+		if _, exists := out[key]; exists && !p.isDottedKey(keyTk) {
+			return nil, syntaxErrorf(keyTk, "duplicate key '%s' in dict", key)
+		}
+		if root {
+			if err := p.checkRootKey(p.rootKey(keyTk), keyTk.Line); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.countElement(); err != nil {
+			return nil, err
 		}
 
 		// Expect scalar indicator.
@@ -506,7 +836,7 @@ func (p *streamParser) parseInlineDict() (map[string]any, error) {
 			return nil, err
 		}
 		if indTk.Type != TokenScalarInd {
-			return nil, fmt.Errorf("line %d: expected ':' in inline dict", indTk.Line)
+			return nil, syntaxErrorf(indTk, "expected ':' in inline dict")
 		}
 
 		// Skip required space.
@@ -520,7 +850,9 @@ func (p *streamParser) parseInlineDict() (map[string]any, error) {
 			return nil, err
 		}
 
-		out[key] = val
+		if err := p.setEntry(out, keyTk, val); err != nil {
+			return nil, err
+		}
 	}
 
 	return out, nil
@@ -529,6 +861,7 @@ func (p *streamParser) parseInlineDict() (map[string]any, error) {
 // parseInlineList parses an inline list (val, val, val).
 func (p *streamParser) parseInlineList() ([]any, error) {
 	out := make([]any, 0, 8) // Pre-allocate for common case.
+	var lits map[int]string
 	isFirst := true
 
 	for {
@@ -553,7 +886,7 @@ func (p *streamParser) parseInlineList() ([]any, error) {
 			}
 			// Check for space before comma.
 			if tk.SpaceBefore {
-				return nil, p.lexer.errorf("no spaces allowed before comma")
+				return nil, p.spaceBeforeCommaError(tk)
 			}
 			p.lexer.next() // Consume comma.
 
@@ -563,6 +896,9 @@ func (p *streamParser) parseInlineList() ([]any, error) {
 			}
 		}
 		isFirst = false
+		if err := p.countElement(); err != nil {
+			return nil, err
+		}
 
 		// Parse value.
 		val, err := p.parseInlineValue()
@@ -570,12 +906,29 @@ func (p *streamParser) parseInlineList() ([]any, error) {
 			return nil, err
 		}
 
+		if lit := p.takeLiteral(val); lit != "" {
+			if lits == nil {
+				lits = make(map[int]string)
+			}
+			lits[len(out)] = lit
+		}
 		out = append(out, val)
 	}
 
+	p.recordListLiterals(out, lits)
 	return out, nil
 }
 
+// spaceBeforeCommaError returns the error for spaces before the comma tk,
+// located at the first of them.
+func (p *streamParser) spaceBeforeCommaError(tk Token) error {
+	col := tk.Column
+	for col > 0 && col <= len(p.lexer.line) && p.lexer.line[col-1] == ' ' {
+		col--
+	}
+	return p.lexer.errorAt(col, "no spaces allowed before comma")
+}
+
 // parseInlineValue parses a single value in an inline context.
 func (p *streamParser) parseInlineValue() (any, error) {
 	tk, err := p.lexer.next()
@@ -617,6 +970,7 @@ func (p *streamParser) parseScalarValue(keyIndent int) (any, error) {
 
 // tokenToValue converts a token to its Go value.
 func (p *streamParser) tokenToValue(tok Token) (any, error) {
+	p.literal = ""
 	switch tok.Type {
 	case TokenString:
 		return tok.Value, nil
@@ -625,6 +979,7 @@ func (p *streamParser) tokenToValue(tok Token) (any, error) {
 		return p.parseIntValue(tok.Value)
 
 	case TokenFloat:
+		p.literal = strings.ReplaceAll(tok.Value, "_", "")
 		return p.parseFloatValue(tok.Value)
 
 	case TokenBool:
@@ -649,7 +1004,7 @@ func (p *streamParser) tokenToValue(tok Token) (any, error) {
 		return nil, fmt.Errorf("%s", tok.Value)
 
 	default:
-		return nil, fmt.Errorf("line %d: unexpected token %s when parsing value", tok.Line, tok.String())
+		return nil, syntaxErrorf(tok, "unexpected token %s when parsing value", tok.String())
 	}
 }
 
@@ -712,6 +1067,37 @@ func (p *streamParser) parseIntValue(s string) (int64, error) {
 	return sign * val, nil
 }
 
+// takeLiteral returns the recorded text of val, if val is the float parsed
+// last, and clears it.
+func (p *streamParser) takeLiteral(val any) string {
+	if _, ok := val.(float64); !ok {
+		return ""
+	}
+	lit := p.literal
+	p.literal = ""
+	return lit
+}
+
+// recordLiteral records the text lit of the value under key in container,
+// a map or slice pointer, or of the root value for a zero container.
+func (p *streamParser) recordLiteral(container uintptr, key, lit string) {
+	if p.literals == nil {
+		p.literals = make(map[valueRef]string)
+	}
+	p.literals[valueRef{container, key}] = lit
+}
+
+// recordListLiterals records the texts of the items of list, by index.
+func (p *streamParser) recordListLiterals(list []any, lits map[int]string) {
+	if len(list) == 0 {
+		return
+	}
+	ptr := reflect.ValueOf(list).Pointer()
+	for i, lit := range lits {
+		p.recordLiteral(ptr, strconv.Itoa(i), lit)
+	}
+}
+
 // parseFloatValue parses a float value from string, skipping underscores.
 func (p *streamParser) parseFloatValue(s string) (float64, error) {
 	if strings.Contains(s, "_") {
@@ -0,0 +1,31 @@
+package huml
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// RawMessage is a raw encoded HUML value. It can be used to delay the
+// decoding of part of a document, for example a section whose shape is only
+// known to a plugin, by decoding the section later with Unmarshal.
+//
+// When decoding, a RawMessage receives the HUML encoding of the value it
+// replaces, as produced by an Encoder, rather than the exact source text. It
+// decodes to the same value, but comments are dropped, keys are sorted and
+// quoted only where needed, and floats are written in their shortest form,
+// such as 1.5 for 1.50.
+//
+// When encoding, a RawMessage is parsed and written out as the value it
+// holds. A nil or empty RawMessage is encoded as null.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeFor[RawMessage]()
+
+// encodeRaw encodes a parsed value into a RawMessage.
+func encodeRaw(src any) (RawMessage, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(src); err != nil {
+		return nil, err
+	}
+	return RawMessage(buf.Bytes()), nil
+}
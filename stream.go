@@ -0,0 +1,437 @@
+package huml
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// EventKind identifies the kind of an Event.
+type EventKind int
+
+const (
+	EventDictStart EventKind = iota + 1 // Start of a dict.
+	EventDictEnd                        // End of the innermost open dict.
+	EventListStart                      // Start of a list.
+	EventListEnd                        // End of the innermost open list.
+	EventKey                            // A dict key, followed by its value.
+	EventValue                          // A scalar value.
+)
+
+// Event is a structural element of a HUML document, as returned by
+// Decoder.Token.
+type Event struct {
+	Kind   EventKind
+	Key    string // The key, for EventKey.
+	Quoted bool   // Whether the key was written in quotes, for EventKey.
+	Value  any    // The scalar, for EventValue, with the types used by Unmarshal.
+}
+
+// Token returns the next structural element of the document, reading no
+// more of the input than needed. This makes it possible to process a very
+// large document, such as a long list of records, without holding all of it
+// in memory. At the end of the document, Token returns io.EOF.
+//
+// A document produces the events of its root value. A scalar produces a single
+// EventValue. A dict produces EventDictStart, then EventKey followed by the
+// events of the value for each entry, then EventDictEnd. A list produces
+// EventListStart, the events of each item, then EventListEnd. For example,
+//
+//	name: "app"
+//	ports:: 80, 443
+//
+// produces DictStart, Key("name"), Value("app"), Key("ports"), ListStart,
+// Value(80), Value(443), ListEnd, DictEnd.
+//
+// Multi-line dicts are returned in source order. Inline dicts are read whole,
+// as they are confined to a single line, and their entries are returned
+// sorted by key. The Quoted field of an EventKey tells a key written in
+// quotes, such as "a_b", from the same key written bare, which Decode does
+// not.
+//
+// Token and Decode must not be mixed on the same Decoder.
+func (dec *Decoder) Token() (Event, error) {
+	if dec.events == nil {
+		dec.events = &eventReader{p: dec.parser}
+		dec.parser.quotedKeys = make(map[valueRef]struct{})
+	}
+	return dec.events.next()
+}
+
+// ValidateStream reads a HUML document from r and returns the first error in
+// it, or nil if it is valid. Like Token, it reads the document incrementally
+// and doesn't build its values, so that it can check documents far larger
+// than memory, such as multi-gigabyte dumps. If onProgress is not nil, it is
+// called with the total number of bytes read from r so far, each time more
+// are read. Options such as MaxDepth apply as they would to a Decoder.
+func ValidateStream(r io.Reader, onProgress func(bytesRead int64), opts ...Option) error {
+	if onProgress != nil {
+		r = &progressReader{r: r, fn: onProgress}
+	}
+
+	dec := NewDecoder(r, opts...)
+	for {
+		if _, err := dec.Token(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// progressReader reports the number of bytes read through it.
+type progressReader struct {
+	r     io.Reader
+	fn    func(int64)
+	total int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.total += int64(n)
+		p.fn(p.total)
+	}
+	return n, err
+}
+
+// eventReader turns the token stream of a parser into Events. Multi-line
+// vectors are tracked on a stack, as their end is only known from the
+// indentation of the following line.
+type eventReader struct {
+	p       *streamParser
+	started bool
+	stack   []eventFrame
+	queue   []Event // Events produced ahead of time, such as a whole inline vector.
+	err     error
+}
+
+// eventFrame is an open multi-line vector.
+type eventFrame struct {
+	list   bool
+	indent int
+	keys   map[string]struct{} // Keys seen so far, for dicts.
+
+	// With ExpandDottedKeys, the dotted keys seen so far and their prefixes,
+	// joined by dots, which are checked as setEntry checks the dicts they
+	// expand to.
+	dotted   map[string]struct{}
+	prefixes map[string]struct{}
+}
+
+// addKey records the key of keyTk in the dict f, and returns an error if it
+// repeats or conflicts with a key seen before.
+func (f *eventFrame) addKey(p *streamParser, keyTk Token) error {
+	key := keyTk.Value
+	if !p.isDottedKey(keyTk) {
+		_, isPrefix := f.prefixes[key]
+		if _, exists := f.keys[key]; exists || (isPrefix && !strings.Contains(key, ".")) {
+			return syntaxErrorf(keyTk, "duplicate key '%s' in dict", key)
+		}
+		f.keys[key] = struct{}{}
+		return nil
+	}
+
+	if f.dotted == nil {
+		f.dotted = make(map[string]struct{})
+		f.prefixes = make(map[string]struct{})
+	}
+	parts := strings.Split(key, ".")
+	for i := 1; i < len(parts); i++ {
+		prefix := strings.Join(parts[:i], ".")
+		seen := f.dotted
+		if i == 1 {
+			seen = f.keys
+		}
+		if _, exists := seen[prefix]; exists {
+			return syntaxErrorf(keyTk, "duplicate key '%s' in dict", parts[i-1])
+		}
+	}
+	_, isPrefix := f.prefixes[key]
+	if _, exists := f.dotted[key]; exists || isPrefix {
+		return syntaxErrorf(keyTk, "duplicate key '%s' in dict", key)
+	}
+	for i := 1; i < len(parts); i++ {
+		f.prefixes[strings.Join(parts[:i], ".")] = struct{}{}
+	}
+	f.dotted[key] = struct{}{}
+	return nil
+}
+
+// next returns the next Event. Errors are sticky.
+func (r *eventReader) next() (Event, error) {
+	if r.err != nil {
+		return Event{}, r.err
+	}
+
+	ev, err := r.scan()
+	if err != nil {
+		r.err = err
+		return Event{}, err
+	}
+	return ev, nil
+}
+
+// scan produces the next Event.
+func (r *eventReader) scan() (Event, error) {
+	if len(r.queue) > 0 {
+		ev := r.queue[0]
+		r.queue = r.queue[1:]
+		return ev, nil
+	}
+
+	if !r.started {
+		r.started = true
+		return r.scanRoot()
+	}
+	if len(r.stack) == 0 {
+		// A root list ends at the first line that isn't one of its items.
+		if _, err := r.p.assertRootEnd(nil, "root list"); err != nil {
+			return Event{}, err
+		}
+		return Event{}, io.EOF
+	}
+
+	top := &r.stack[len(r.stack)-1]
+	if top.list {
+		return r.scanListItem(top)
+	}
+	return r.scanDictEntry(top)
+}
+
+// scanRoot starts the document. Only multi-line vectors are streamed; any
+// other root value is confined to a line or two and is parsed whole.
+func (r *eventReader) scanRoot() (Event, error) {
+	p := r.p
+	tk, err := p.lexer.peek()
+	if err != nil {
+		return Event{}, err
+	}
+	if tk.Type == TokenEOF {
+		return Event{}, p.emptyDocumentError()
+	}
+	if tk.Indent != 0 {
+		return Event{}, syntaxErrorf(tk, "root element must not be indented")
+	}
+
+	rootType, err := p.inferRootType()
+	if err != nil {
+		return Event{}, err
+	}
+
+	switch rootType {
+	case typeMultilineDict:
+		return r.open(false, 0), nil
+	case typeMultilineList:
+		return r.open(true, 0), nil
+	}
+
+	val, err := p.parse()
+	if err != nil {
+		return Event{}, err
+	}
+	r.queue = r.appendValueEvents(r.queue, val)
+	return r.scan()
+}
+
+// open pushes a multi-line vector and returns its start event.
+func (r *eventReader) open(list bool, indent int) Event {
+	if list {
+		r.stack = append(r.stack, eventFrame{list: true, indent: indent})
+		return Event{Kind: EventListStart}
+	}
+	r.stack = append(r.stack, eventFrame{indent: indent, keys: make(map[string]struct{})})
+	return Event{Kind: EventDictStart}
+}
+
+// close pops the innermost vector and returns its end event.
+func (r *eventReader) close() Event {
+	top := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	if top.list {
+		return Event{Kind: EventListEnd}
+	}
+	return Event{Kind: EventDictEnd}
+}
+
+// scanDictEntry returns the key of the next entry of the dict f, queueing the
+// events of its value, or the end of the dict. It mirrors parseMultilineDict.
+func (r *eventReader) scanDictEntry(f *eventFrame) (Event, error) {
+	p := r.p
+	tk, err := p.lexer.peek()
+	if err != nil {
+		return Event{}, err
+	}
+
+	if tk.Type == TokenEOF || tk.Indent < f.indent {
+		return r.close(), nil
+	}
+	if tk.Indent != f.indent {
+		return Event{}, syntaxErrorf(tk, "bad indent %d, expected %d", tk.Indent, f.indent)
+	}
+	if tk.Type != TokenKey && tk.Type != TokenQuotedKey {
+		return Event{}, syntaxErrorf(tk, "invalid character, expected key")
+	}
+
+	keyTk, _ := p.lexer.next()
+	key := keyTk.Value
+	if err := f.addKey(p, keyTk); err != nil {
+		return Event{}, err
+	}
+	indent := f.indent
+	if indent == 0 {
+		if err := p.checkRootKey(p.rootKey(keyTk), keyTk.Line); err != nil {
+			return Event{}, err
+		}
+	}
+	if err := p.countElement(); err != nil {
+		return Event{}, err
+	}
+
+	indTk, err := p.lexer.next()
+	if err != nil {
+		return Event{}, err
+	}
+
+	switch indTk.Type {
+	case TokenScalarInd:
+		if err := p.lexer.skipRequiredSpace("after ':'"); err != nil {
+			return Event{}, err
+		}
+		val, err := p.parseScalarValue(indent)
+		if err != nil {
+			return Event{}, err
+		}
+		r.queue = r.appendValueEvents(r.queue, val)
+	case TokenVectorInd:
+		if err := r.scanVector(indent + 2); err != nil {
+			return Event{}, err
+		}
+	default:
+		return Event{}, syntaxErrorf(indTk, "expected ':' or '::' after key")
+	}
+
+	return Event{Kind: EventKey, Key: key, Quoted: keyTk.Type == TokenQuotedKey}, nil
+}
+
+// scanListItem returns the first event of the next item of the list f, or
+// the end of the list. It mirrors parseMultilineList.
+func (r *eventReader) scanListItem(f *eventFrame) (Event, error) {
+	p := r.p
+	tk, err := p.lexer.peek()
+	if err != nil {
+		return Event{}, err
+	}
+
+	if tk.Type == TokenEOF || tk.Indent < f.indent {
+		return r.close(), nil
+	}
+	if tk.Indent != f.indent {
+		return Event{}, syntaxErrorf(tk, "bad indent %d, expected %d", tk.Indent, f.indent)
+	}
+	if tk.Type != TokenListItem {
+		return r.close(), nil
+	}
+	indent := f.indent
+
+	p.lexer.next() // Consume list item marker.
+	if err := p.countElement(); err != nil {
+		return Event{}, err
+	}
+	nextTk, err := p.lexer.peek()
+	if err != nil {
+		return Event{}, err
+	}
+
+	if nextTk.Type == TokenVectorInd {
+		p.lexer.next()
+		if err := r.scanVector(indent + 2); err != nil {
+			return Event{}, err
+		}
+	} else {
+		val, err := p.parseListItemValue(indent)
+		if err != nil {
+			return Event{}, err
+		}
+		r.queue = r.appendValueEvents(r.queue, val)
+	}
+
+	return r.scan()
+}
+
+// scanVector handles a vector after the :: indicator. A multi-line vector is
+// opened on the stack, while an inline one is parsed whole. Either way, its
+// events are queued. It mirrors parseVector.
+func (r *eventReader) scanVector(indent int) error {
+	p := r.p
+	if p.maxDepth > 0 && len(r.stack) > p.maxDepth {
+		return p.lexer.errorf("maximum nesting depth of %d exceeded", p.maxDepth)
+	}
+
+	if !p.lexer.atEndOfLine() {
+		if err := p.lexer.skipRequiredSpace("after '::'"); err != nil {
+			return err
+		}
+		val, err := p.parseInlineVectorValue()
+		if err != nil {
+			return err
+		}
+		r.queue = r.appendValueEvents(r.queue, val)
+		return nil
+	}
+
+	if err := p.lexer.consumeLine(); err != nil {
+		return err
+	}
+	tk, err := p.lexer.peek()
+	if err != nil {
+		return err
+	}
+	if tk.Type == TokenEOF || tk.Indent < indent {
+		switch p.emptyVector {
+		case EmptyVectorDict:
+			r.queue = append(r.queue, Event{Kind: EventDictStart}, Event{Kind: EventDictEnd})
+			return nil
+		case EmptyVectorList:
+			r.queue = append(r.queue, Event{Kind: EventListStart}, Event{Kind: EventListEnd})
+			return nil
+		}
+		return syntaxErrorf(tk, "ambiguous empty vector after '::'. Use [] or {}.")
+	}
+
+	r.queue = append(r.queue, r.open(tk.Type == TokenListItem, indent))
+	return nil
+}
+
+// appendValueEvents appends the events of a parsed value to evs.
+func (r *eventReader) appendValueEvents(evs []Event, val any) []Event {
+	switch v := val.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		evs = append(evs, Event{Kind: EventDictStart})
+		ptr := reflect.ValueOf(v).Pointer()
+		for _, k := range keys {
+			ref := valueRef{ptr, k}
+			_, quoted := r.p.quotedKeys[ref]
+			delete(r.p.quotedKeys, ref)
+			evs = append(evs, Event{Kind: EventKey, Key: k, Quoted: quoted})
+			evs = r.appendValueEvents(evs, v[k])
+		}
+		return append(evs, Event{Kind: EventDictEnd})
+	case []any:
+		evs = append(evs, Event{Kind: EventListStart})
+		for _, item := range v {
+			evs = r.appendValueEvents(evs, item)
+		}
+		return append(evs, Event{Kind: EventListEnd})
+	default:
+		return append(evs, Event{Kind: EventValue, Value: val})
+	}
+}
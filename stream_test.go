@@ -0,0 +1,247 @@
+package huml
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Shorthands for the expected events in tests.
+var (
+	evDictStart = Event{Kind: EventDictStart}
+	evDictEnd   = Event{Kind: EventDictEnd}
+	evListStart = Event{Kind: EventListStart}
+	evListEnd   = Event{Kind: EventListEnd}
+)
+
+func evKey(k string) Event { return Event{Kind: EventKey, Key: k} }
+func evVal(v any) Event    { return Event{Kind: EventValue, Value: v} }
+
+func TestDecoderToken(t *testing.T) {
+	f := func(name, doc string, exp []Event, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			dec := NewDecoder(strings.NewReader(doc))
+
+			var (
+				got []Event
+				err error
+			)
+			for {
+				var ev Event
+				ev, err = dec.Token()
+				if err != nil {
+					break
+				}
+				got = append(got, ev)
+			}
+
+			assert.Equal(t, exp, got)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+
+	f("scalar", `"hello"`, []Event{evVal("hello")}, "")
+	f("inline_list", "1, 2", []Event{evListStart, evVal(int64(1)), evVal(int64(2)), evListEnd}, "")
+	f("empty_dict", "{}", []Event{evDictStart, evDictEnd}, "")
+
+	f("nested", `name: "app"
+ports:: 80, 443
+limits:: cpu: 2, mem: "1G"
+servers::
+  - ::
+    host: "a"
+    tags::
+      - "x"
+      - """
+        multi
+        line
+      """
+  - :: host: "b"
+  - :: []
+  - null
+empty:: {}
+last: true
+`, []Event{
+		evDictStart,
+		evKey("name"), evVal("app"),
+		evKey("ports"), evListStart, evVal(int64(80)), evVal(int64(443)), evListEnd,
+		evKey("limits"), evDictStart, evKey("cpu"), evVal(int64(2)), evKey("mem"), evVal("1G"), evDictEnd,
+		evKey("servers"), evListStart,
+		evDictStart,
+		evKey("host"), evVal("a"),
+		evKey("tags"), evListStart, evVal("x"), evVal("multi\nline"), evListEnd,
+		evDictEnd,
+		evDictStart, evKey("host"), evVal("b"), evDictEnd,
+		evListStart, evListEnd,
+		evVal(nil),
+		evListEnd,
+		evKey("empty"), evDictStart, evDictEnd,
+		evKey("last"), evVal(true),
+		evDictEnd,
+	}, "")
+
+	f("root_list", "- 1\n- ::\n  - 2\n", []Event{
+		evListStart, evVal(int64(1)), evListStart, evVal(int64(2)), evListEnd, evListEnd,
+	}, "")
+
+	// Events before an error are still returned.
+	f("duplicate_key", "a: 1\nb::\n  c: 2\n  c: 3\n", []Event{
+		evDictStart, evKey("a"), evVal(int64(1)), evKey("b"), evDictStart, evKey("c"), evVal(int64(2)),
+	}, "line 4: duplicate key 'c' in dict")
+	f("bad_indent", "a::\n  b: 1\n   c: 2\n", []Event{
+		evDictStart, evKey("a"), evDictStart, evKey("b"), evVal(int64(1)),
+	}, "line 3: indentation of 3 spaces is not a multiple of 2")
+	f("empty", "", nil, "empty document is undefined")
+	f("directive_only", "%HUML v0.2.0\n", nil, "document contains only a version directive and no content")
+}
+
+func TestDecoderTokenQuotedKeys(t *testing.T) {
+	doc := `a_b: 1
+"a_b2": 2
+nested::
+  "x": 1
+  y:: "z": 2, w: 3
+list::
+  - :: "k": true
+"a.b": 4
+`
+	dec := NewDecoder(strings.NewReader(doc))
+	quoted := map[string]bool{}
+	for {
+		ev, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		if ev.Kind == EventKey {
+			quoted[ev.Key] = ev.Quoted
+		}
+	}
+	assert.Equal(t, map[string]bool{
+		"a_b": false, "a_b2": true, "nested": false, "x": true, "y": false,
+		"z": true, "w": false, "list": false, "k": true, "a.b": true,
+	}, quoted)
+
+	// A root inline dict keeps the distinction too.
+	dec = NewDecoder(strings.NewReader(`"a": 1, b: 2`))
+	var keys []Event
+	for {
+		ev, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if ev.Kind == EventKey {
+			keys = append(keys, ev)
+		}
+	}
+	assert.Equal(t, []Event{{Kind: EventKey, Key: "a", Quoted: true}, evKey("b")}, keys)
+}
+
+// TestDecoderTokenMatchesDecode tests that the events of a document describe
+// the same value as Decode returns.
+func TestDecoderTokenMatchesDecode(t *testing.T) {
+	doc := `a:: 1, 2
+b::
+  c: "x"
+  d::
+    - ::
+      e: 1.5
+    - ::
+      - true
+f:: {}
+`
+	var exp any
+	if err := Unmarshal([]byte(doc), &exp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dec := NewDecoder(strings.NewReader(doc))
+
+	// next returns the next event, failing the test on errors.
+	next := func() Event {
+		ev, err := dec.Token()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return ev
+	}
+
+	// build reconstructs the value that starts with ev.
+	var build func(ev Event) any
+	build = func(ev Event) any {
+		switch ev.Kind {
+		case EventDictStart:
+			out := map[string]any{}
+			for ev := next(); ev.Kind != EventDictEnd; ev = next() {
+				out[ev.Key] = build(next())
+			}
+			return out
+		case EventListStart:
+			out := []any{}
+			for ev := next(); ev.Kind != EventListEnd; ev = next() {
+				out = append(out, build(ev))
+			}
+			return out
+		default:
+			return ev.Value
+		}
+	}
+
+	assert.Equal(t, exp, build(next()))
+	_, err := dec.Token()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestValidateStream(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("records::\n")
+	for i := range 50000 {
+		fmt.Fprintf(&b, "  - ::\n    id: %d\n    name: \"record %d\"\n    tags:: \"a\", \"b\"\n", i, i)
+	}
+	doc := b.String()
+
+	var calls int
+	var last int64
+	err := ValidateStream(strings.NewReader(doc), func(n int64) {
+		assert.Greater(t, n, last)
+		last = n
+		calls++
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(doc)), last)
+	assert.Greater(t, calls, 1)
+
+	f := func(name, doc string, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			err := ValidateStream(strings.NewReader(doc), nil)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	f("scalar", "1", "")
+	f("empty", "", "empty document is undefined")
+	f("duplicate_key", "a: 1\nb: 2\na: 3", "line 3: duplicate key 'a' in dict")
+	f("bad_value", "a::\n  - 1\n  - x", "line 3: unquoted string 'x' is not allowed")
+	f("error_late", doc+"oops: yes\n", "line 200002: unquoted string 'yes' is not allowed")
+
+	// Options apply.
+	err = ValidateStream(strings.NewReader("a::\n  b::\n    c: 1"), nil, MaxDepth(1))
+	assert.EqualError(t, err, "line 2: maximum nesting depth of 1 exceeded")
+}
@@ -1,9 +1,19 @@
 package huml
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -472,3 +482,1430 @@ another_custom: 42`
 		assert.Equal(t, "", result.Skipped)  // Should remain zero since it was skipped
 	})
 }
+
+type embeddedBase struct {
+	ID   int    `huml:"id"`
+	Name string `huml:"name"`
+}
+
+type embeddedLevel int
+
+func TestStructEmbedded(t *testing.T) {
+	t.Run("embedded_struct_flattened", func(t *testing.T) {
+		type TestStruct struct {
+			embeddedBase
+			Extra string `huml:"extra"`
+		}
+
+		original := TestStruct{embeddedBase: embeddedBase{ID: 7, Name: "base"}, Extra: "more"}
+		marshalled, err := Marshal(original)
+		if err != nil {
+			t.Fatalf("unexpected error marshalling: %v", err)
+		}
+		assert.Equal(t, "%HUML v0.2.0\nid: 7\nname: \"base\"\nextra: \"more\"\n", string(marshalled))
+
+		var result TestStruct
+		if err := Unmarshal(marshalled, &result); err != nil {
+			t.Fatalf("unexpected error unmarshalling: %v", err)
+		}
+		assert.Equal(t, original, result)
+	})
+
+	t.Run("embedded_struct_pointer", func(t *testing.T) {
+		type Base struct {
+			ID int `huml:"id"`
+		}
+		type TestStruct struct {
+			*Base
+			Extra string `huml:"extra"`
+		}
+
+		// A nil embedded pointer contributes no fields.
+		marshalled, err := Marshal(TestStruct{Extra: "more"})
+		if err != nil {
+			t.Fatalf("unexpected error marshalling: %v", err)
+		}
+		assert.Equal(t, "%HUML v0.2.0\nextra: \"more\"\n", string(marshalled))
+
+		// Decoding allocates the embedded pointer on demand.
+		var result TestStruct
+		if err := Unmarshal([]byte("id: 3\nextra: \"more\""), &result); err != nil {
+			t.Fatalf("unexpected error unmarshalling: %v", err)
+		}
+		if assert.NotNil(t, result.Base) {
+			assert.Equal(t, 3, result.ID)
+		}
+	})
+
+	t.Run("embedded_scalar_keyed_by_type_name", func(t *testing.T) {
+		type Level int
+		type TestStruct struct {
+			Level
+			Name string `huml:"name"`
+		}
+
+		original := TestStruct{Level: 3, Name: "x"}
+		marshalled, err := Marshal(original)
+		if err != nil {
+			t.Fatalf("unexpected error marshalling: %v", err)
+		}
+		assert.Equal(t, "%HUML v0.2.0\nLevel: 3\nname: \"x\"\n", string(marshalled))
+
+		var result TestStruct
+		if err := Unmarshal(marshalled, &result); err != nil {
+			t.Fatalf("unexpected error unmarshalling: %v", err)
+		}
+		assert.Equal(t, original, result)
+	})
+
+	t.Run("embedded_unexported_scalar_ignored", func(t *testing.T) {
+		type TestStruct struct {
+			embeddedLevel
+			Name string `huml:"name"`
+		}
+
+		marshalled, err := Marshal(TestStruct{embeddedLevel: 2, Name: "x"})
+		if err != nil {
+			t.Fatalf("unexpected error marshalling: %v", err)
+		}
+		assert.Equal(t, "%HUML v0.2.0\nname: \"x\"\n", string(marshalled))
+	})
+
+	t.Run("outer_field_wins", func(t *testing.T) {
+		type TestStruct struct {
+			embeddedBase
+			Name string `huml:"name"`
+		}
+
+		var result TestStruct
+		if err := Unmarshal([]byte("id: 1\nname: \"outer\""), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, 1, result.ID)
+		assert.Equal(t, "outer", result.Name)
+		assert.Equal(t, "", result.embeddedBase.Name)
+	})
+}
+
+func TestRawMessage(t *testing.T) {
+	type Config struct {
+		Name    string                `huml:"name"`
+		Plugins map[string]RawMessage `huml:"plugins"`
+	}
+	type CachePlugin struct {
+		Size int    `huml:"size"`
+		Mode string `huml:"mode"`
+	}
+	type AuthPlugin struct {
+		Providers []string `huml:"providers"`
+	}
+
+	doc := `name: "app"
+plugins::
+  cache::
+    size: 128
+    mode: "lru"
+  auth::
+    providers:: "github", "google"
+`
+
+	var cfg Config
+	if err := Unmarshal([]byte(doc), &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "app", cfg.Name)
+	assert.Len(t, cfg.Plugins, 2)
+
+	var cache CachePlugin
+	if err := Unmarshal(cfg.Plugins["cache"], &cache); err != nil {
+		t.Fatalf("unexpected error decoding cache plugin: %v", err)
+	}
+	assert.Equal(t, CachePlugin{Size: 128, Mode: "lru"}, cache)
+
+	var auth AuthPlugin
+	if err := Unmarshal(cfg.Plugins["auth"], &auth); err != nil {
+		t.Fatalf("unexpected error decoding auth plugin: %v", err)
+	}
+	assert.Equal(t, AuthPlugin{Providers: []string{"github", "google"}}, auth)
+
+	// Marshalling writes the raw values back as regular vectors.
+	marshalled, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+	assert.Equal(t, `%HUML v0.2.0
+name: "app"
+plugins::
+  auth::
+    providers::
+      - "github"
+      - "google"
+  cache::
+    mode: "lru"
+    size: 128
+`, string(marshalled))
+}
+
+func TestStructCommentField(t *testing.T) {
+	type Server struct {
+		Doc  string `huml:",comment"`
+		Port int    `huml:"port"`
+	}
+	type Config struct {
+		Doc    string `huml:",comment"`
+		Name   string `huml:"name"`
+		Server Server `huml:"server"`
+		Extra  Server `huml:"extra"`
+	}
+
+	doc := `# Application settings.
+# Edit with care.
+name: "app"
+server::
+  # The HTTP server.
+  port: 80
+# Detached comment.
+
+extra::
+  port: 81
+`
+
+	var cfg Config
+	if err := Unmarshal([]byte(doc), &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "Application settings.\nEdit with care.", cfg.Doc)
+	assert.Equal(t, "app", cfg.Name)
+	assert.Equal(t, Server{Doc: "The HTTP server.", Port: 80}, cfg.Server)
+	// A blank line detaches a comment from the content below it.
+	assert.Equal(t, Server{Port: 81}, cfg.Extra)
+
+	// Comment fields are not written when marshalling.
+	marshalled, err := Marshal(cfg.Server)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+	assert.Equal(t, "%HUML v0.2.0\nport: 80\n", string(marshalled))
+}
+
+func TestStructMapValues(t *testing.T) {
+	type Service struct {
+		Port int    `huml:"port"`
+		Host string `huml:"host"`
+	}
+
+	doc := `services::
+  web::
+    port: 80
+    host: "example.com"
+  api::
+    port: 8080
+    host: "localhost"
+`
+
+	t.Run("struct_values", func(t *testing.T) {
+		var result struct {
+			Services map[string]Service `huml:"services"`
+		}
+		if err := Unmarshal([]byte(doc), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, map[string]Service{
+			"web": {Port: 80, Host: "example.com"},
+			"api": {Port: 8080, Host: "localhost"},
+		}, result.Services)
+	})
+
+	t.Run("struct_pointer_values", func(t *testing.T) {
+		var result struct {
+			Services map[string]*Service `huml:"services"`
+		}
+		if err := Unmarshal([]byte(doc), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if assert.Len(t, result.Services, 2) && assert.NotNil(t, result.Services["web"]) {
+			assert.Equal(t, Service{Port: 80, Host: "example.com"}, *result.Services["web"])
+			assert.Equal(t, Service{Port: 8080, Host: "localhost"}, *result.Services["api"])
+		}
+	})
+
+	t.Run("nested_maps", func(t *testing.T) {
+		var result map[string]map[string]int
+		if err := Unmarshal([]byte("web::\n  port: 80\n  workers: 4\napi:: port: 8080"), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, map[string]map[string]int{
+			"web": {"port": 80, "workers": 4},
+			"api": {"port": 8080},
+		}, result)
+	})
+
+	t.Run("null_pointer_value", func(t *testing.T) {
+		var result map[string]*Service
+		if err := Unmarshal([]byte("web: null\napi:: port: 1"), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Contains(t, result, "web")
+		assert.Nil(t, result["web"])
+		assert.Equal(t, &Service{Port: 1}, result["api"])
+	})
+}
+
+func TestUnmarshalUnion(t *testing.T) {
+	type Click struct {
+		Kind string `huml:"kind"`
+		X    int    `huml:"x"`
+		Y    int    `huml:"y"`
+	}
+	type Scroll struct {
+		Kind  string  `huml:"kind"`
+		Delta float64 `huml:"delta"`
+	}
+
+	variants := map[string]func() any{
+		"click":  func() any { return &Click{} },
+		"scroll": func() any { return &Scroll{} },
+	}
+
+	f := func(name, doc string, exp any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			v, err := UnmarshalUnion([]byte(doc), "kind", variants)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, v)
+		})
+	}
+
+	f("click", "kind: \"click\"\nx: 10\ny: 20", &Click{Kind: "click", X: 10, Y: 20}, "")
+	f("scroll", "delta: -1.5\nkind: \"scroll\"", &Scroll{Kind: "scroll", Delta: -1.5}, "")
+	f("unknown", "kind: \"hover\"", nil, `unknown kind "hover"`)
+	f("missing", "x: 1", nil, `discriminator field "kind" is missing`)
+	f("not_string", "kind: 1", nil, `discriminator field "kind" must be a string, not int64`)
+	f("not_dict", "- 1\n- 2", nil, "cannot unmarshal []interface {} into map")
+}
+
+// testShape is implemented by the variants decoded in TestDecoderRegisterUnion.
+type testShape interface {
+	Area() float64
+}
+
+type testCircle struct {
+	Kind   string  `huml:"kind"`
+	Radius float64 `huml:"radius"`
+}
+
+func (c *testCircle) Area() float64 { return 3 * c.Radius * c.Radius }
+
+type testRect struct {
+	Kind string  `huml:"kind"`
+	W    float64 `huml:"w"`
+	H    float64 `huml:"h"`
+}
+
+func (r testRect) Area() float64 { return r.W * r.H }
+
+func TestDecoderRegisterUnion(t *testing.T) {
+	type Drawing struct {
+		Shapes []testShape          `huml:"shapes"`
+		Main   testShape            `huml:"main"`
+		Named  map[string]testShape `huml:"named"`
+	}
+
+	variants := map[string]func() any{
+		"circle": func() any { return &testCircle{} },
+		"rect":   func() any { return &testRect{} },
+	}
+
+	f := func(name, doc string, register bool, exp Drawing, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			dec := NewDecoder(strings.NewReader(doc))
+			if register {
+				dec.RegisterUnion(reflect.TypeFor[testShape](), "kind", variants)
+			}
+			var result Drawing
+			err := dec.Decode(&result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, exp, result)
+		})
+	}
+
+	doc := `shapes::
+  - :: kind: "circle", radius: 1.0
+  - :: kind: "rect", w: 2.0, h: 3.0
+main::
+  kind: "rect"
+  w: 1.0
+  h: 1.0
+named::
+  c:: kind: "circle", radius: 2.0
+`
+	f("polymorphic", doc, true, Drawing{
+		Shapes: []testShape{&testCircle{Kind: "circle", Radius: 1}, &testRect{Kind: "rect", W: 2, H: 3}},
+		Main:   &testRect{Kind: "rect", W: 1, H: 1},
+		Named:  map[string]testShape{"c": &testCircle{Kind: "circle", Radius: 2}},
+	}, "")
+	f("null", "main: null", true, Drawing{}, "")
+	f("unregistered", doc, false, Drawing{},
+		"error setting field shapes: error setting slice element 0: cannot unmarshal map[string]interface {} into interface huml.testShape without a registered union")
+	f("unknown", "shapes::\n  - :: kind: \"star\"", true, Drawing{},
+		`error setting field shapes: error setting slice element 0: unknown kind "star"`)
+	f("not_dict", "shapes:: 1, 2", true, Drawing{},
+		"error setting field shapes: error setting slice element 0: cannot unmarshal int64 into interface huml.testShape, expected a dict")
+}
+
+func TestBigRat(t *testing.T) {
+	type Rates struct {
+		Fee   big.Rat             `huml:"fee"`
+		Split *big.Rat            `huml:"split"`
+		Zero  big.Rat             `huml:"zero,omitempty"`
+		Named map[string]*big.Rat `huml:"named"`
+	}
+
+	f := func(name, doc string, expFee, expSplit *big.Rat, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result Rates
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert.Equal(t, expFee.RatString(), result.Fee.RatString())
+			assert.Equal(t, expSplit.RatString(), result.Split.RatString())
+		})
+	}
+
+	f("decimal", "fee: 0.25\nsplit: \"3/4\"", big.NewRat(1, 4), big.NewRat(3, 4), "")
+	f("exact_decimal", "fee: 0.1\nsplit: 2", big.NewRat(1, 10), big.NewRat(2, 1), "")
+	f("string_decimal", "fee: \"1.125\"\nsplit: 1e2", big.NewRat(9, 8), big.NewRat(100, 1), "")
+	f("invalid", "fee: \"abc\"", nil, nil, `error setting field fee: cannot unmarshal string "abc" into big.Rat`)
+	f("inf", "fee: inf", nil, nil, "error setting field fee: cannot unmarshal +Inf into big.Rat")
+	f("bool", "fee: true", nil, nil, "error setting field fee: cannot unmarshal bool into big.Rat")
+
+	// Rats are written as exact strings and decode back to the same value.
+	in := Rates{Split: big.NewRat(-3, 4), Named: map[string]*big.Rat{"n": big.NewRat(5, 1)}}
+	in.Fee.SetFrac64(1, 3)
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "fee: \"1/3\"\nsplit: \"-3/4\"\nnamed::\n  n: \"5\"\n", buf.String())
+
+	var out Rates
+	if err := Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v", err)
+	}
+	assert.Equal(t, "1/3", out.Fee.RatString())
+	assert.Equal(t, "-3/4", out.Split.RatString())
+	assert.Equal(t, "5", out.Named["n"].RatString())
+}
+
+// testSet and testPair are generic types for TestGenericTypes.
+type testSet[T comparable] map[T]struct{}
+
+type testPair[A, B any] struct {
+	First  A `huml:"first"`
+	Second B `huml:"second"`
+}
+
+func TestGenericTypes(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		var result testSet[string]
+		if err := Unmarshal([]byte("a:: {}\nb: null\n\"c d\":: {}"), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, testSet[string]{"a": {}, "b": {}, "c d": {}}, result)
+
+		marshalled, err := Marshal(result)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, "%HUML v0.2.0\na:: {}\nb:: {}\n\"c d\":: {}\n", string(marshalled))
+
+		// Values other than {} or null carry data that a set can't hold.
+		err = Unmarshal([]byte("a: true"), &result)
+		assert.EqualError(t, err, "error setting map value for key a: cannot unmarshal bool into struct")
+	})
+
+	t.Run("pair", func(t *testing.T) {
+		var result testPair[int, testSet[string]]
+		if err := Unmarshal([]byte("first: 1\nsecond:: x: null"), &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, testPair[int, testSet[string]]{First: 1, Second: testSet[string]{"x": {}}}, result)
+	})
+}
+
+func TestDecoderSetDefaulter(t *testing.T) {
+	type Server struct {
+		Host string `huml:"host"`
+		Port int    `huml:"port"`
+	}
+	type Config struct {
+		Name    string   `huml:"name"`
+		Region  string   `huml:"region"`
+		Timeout int      `huml:"timeout"`
+		Servers []Server `huml:"servers"`
+	}
+
+	var paths []string
+	defaulter := func(path string) (any, bool) {
+		paths = append(paths, path)
+		switch path {
+		case "region":
+			return "eu-west", true
+		case "servers.1.port":
+			return int64(8080), true
+		case "name":
+			return "unused", true
+		}
+		return nil, false
+	}
+
+	var result Config
+	doc := "name: \"app\"\nservers::\n  - :: host: \"a\", port: 1\n  - :: host: \"b\""
+	if err := Unmarshal([]byte(doc), &result, SetDefaulter(defaulter)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Present keys are never defaulted, and absent ones without a default
+	// are left zero.
+	assert.Equal(t, Config{
+		Name:    "app",
+		Region:  "eu-west",
+		Servers: []Server{{Host: "a", Port: 1}, {Host: "b", Port: 8080}},
+	}, result)
+	assert.Equal(t, []string{"region", "timeout", "servers.1.port"}, paths)
+
+	// Defaults are decoded like document values.
+	bad := func(string) (any, bool) { return "soon", true }
+	err := Unmarshal([]byte("name: \"app\""), &Server{}, SetDefaulter(bad))
+	assert.EqualError(t, err, "error setting field port: cannot unmarshal string into integer")
+}
+
+func TestNullStructFields(t *testing.T) {
+	type Nested struct {
+		Name string `huml:"name"`
+		Port int    `huml:"port"`
+	}
+	type Config struct {
+		Ptr   *Nested `huml:"ptr"`
+		Value Nested  `huml:"value"`
+	}
+
+	f := func(name, doc string, initial, expected Config) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			result := initial
+			err := Unmarshal([]byte(doc), &result)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("dict allocates pointer",
+		"ptr::\n  name: \"a\"\n  port: 1",
+		Config{},
+		Config{Ptr: &Nested{Name: "a", Port: 1}})
+	f("null leaves pointer nil",
+		"ptr: null",
+		Config{},
+		Config{})
+	f("null clears pointer",
+		"ptr: null",
+		Config{Ptr: &Nested{Name: "a"}},
+		Config{})
+	f("null zeroes struct",
+		"value: null",
+		Config{Value: Nested{Name: "a", Port: 1}},
+		Config{})
+	f("absent fields are kept",
+		"ptr: null",
+		Config{Value: Nested{Name: "a"}},
+		Config{Value: Nested{Name: "a"}})
+}
+
+func TestDecodePartial(t *testing.T) {
+	type DB struct {
+		Host string `huml:"host"`
+		Port int    `huml:"port"`
+	}
+	type Config struct {
+		Name   string         `huml:"name"`
+		Port   int            `huml:"port"`
+		Debug  bool           `huml:"debug"`
+		Tags   []string       `huml:"tags"`
+		DB     *DB            `huml:"db"`
+		Limits map[string]int `huml:"limits"`
+		Ports  map[int]string `huml:"ports"`
+	}
+
+	f := func(name, doc string, expected Config, expectedErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result Config
+			err := Unmarshal([]byte(doc), &result)
+			assert.EqualError(t, err, expectedErr)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("syntax error on third key",
+		"name: \"app\"\nport: 8080\ndebug: yes\ntags:: \"a\"",
+		Config{Name: "app", Port: 8080},
+		"line 3: unquoted string 'yes' is not allowed")
+	f("syntax error in nested dict",
+		"name: \"app\"\ndb::\n  host: \"localhost\"\n  port 5432",
+		Config{Name: "app", DB: &DB{Host: "localhost"}},
+		"line 4: unquoted string 'port' is not allowed")
+	f("syntax error in list",
+		"name: \"app\"\ntags::\n  - \"a\"\n  - b",
+		Config{Name: "app", Tags: []string{"a"}},
+		"line 4: unquoted string 'b' is not allowed")
+	f("type error on third key",
+		"name: \"app\"\nport: 8080\ndebug: \"yes\"\ntags:: \"a\"",
+		Config{Name: "app", Port: 8080, Tags: []string{"a"}},
+		"error setting field debug: cannot unmarshal string into bool")
+	f("type error in list",
+		"tags:: \"a\", 1, \"c\"",
+		Config{Tags: []string{"a", "", "c"}},
+		"error setting field tags: error setting slice element 1: cannot unmarshal int64 into string")
+	f("type error in nested dict",
+		"db::\n  host: 1\n  port: 5432",
+		Config{DB: &DB{Port: 5432}},
+		"error setting field db: error setting field host: cannot unmarshal int64 into string")
+	f("type error in map",
+		"limits:: a: 1, b: \"x\"",
+		Config{Limits: map[string]int{"a": 1, "b": 0}},
+		"error setting field limits: error setting map value for key b: cannot unmarshal string into integer")
+	f("bad map key",
+		"ports:: \"80\": \"http\", x: \"bad\"",
+		Config{Ports: map[int]string{80: "http"}},
+		"error setting field ports: error setting map key x: cannot unmarshal key \"x\" into int")
+}
+
+func TestDocumentField(t *testing.T) {
+	type Server struct {
+		Port int            `huml:"port"`
+		All  map[string]any `huml:",document"`
+	}
+	type Config struct {
+		Name   string         `huml:"name"`
+		Server Server         `huml:"server"`
+		Doc    map[string]any `huml:",document"`
+	}
+
+	doc := "name: \"app\"\nserver::\n  port: 8080\n  tls: true\nextra:: 1, 2\n"
+	var cfg Config
+	err := Unmarshal([]byte(doc), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "app", cfg.Name)
+	assert.Equal(t, 8080, cfg.Server.Port)
+	assert.Equal(t, map[string]any{
+		"name":   "app",
+		"server": map[string]any{"port": int64(8080), "tls": true},
+		"extra":  []any{int64(1), int64(2)},
+	}, cfg.Doc)
+	assert.Equal(t, map[string]any{"port": int64(8080), "tls": true}, cfg.Server.All)
+
+	// A RawMessage receives the encoded document.
+	type RawConfig struct {
+		Name string     `huml:"name"`
+		Raw  RawMessage `huml:",document"`
+	}
+	var raw RawConfig
+	err = Unmarshal([]byte("name: \"app\"\nport: 1\n"), &raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "app", raw.Name)
+	assert.Equal(t, "name: \"app\"\nport: 1\n", string(raw.Raw))
+
+	// Document fields are not written.
+	out, err := Marshal(cfg)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(out), "Doc")
+	assert.NotContains(t, string(out), "extra")
+
+	// Only one document field is allowed.
+	type Twice struct {
+		A map[string]any `huml:",document"`
+		B RawMessage     `huml:",document"`
+	}
+	err = Unmarshal([]byte("a: 1"), &Twice{})
+	assert.EqualError(t, err, "struct huml.Twice has more than one document field: A and B")
+}
+
+// testPluginID is a map key decoded from and encoded to text such as "auth/v2".
+type testPluginID struct {
+	Name    string
+	Version int
+}
+
+func (id *testPluginID) UnmarshalText(text []byte) error {
+	name, version, ok := strings.Cut(string(text), "/v")
+	if !ok {
+		return fmt.Errorf("invalid plugin id %q", text)
+	}
+	n, err := strconv.Atoi(version)
+	if err != nil {
+		return fmt.Errorf("invalid plugin id %q", text)
+	}
+	*id = testPluginID{name, n}
+	return nil
+}
+
+func (id testPluginID) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "%s/v%d", id.Name, id.Version), nil
+}
+
+func TestMapKeyTypes(t *testing.T) {
+	type Config struct {
+		Plugins map[testPluginID]RawMessage `huml:"plugins"`
+	}
+
+	doc := "plugins::\n  \"auth/v2\"::\n    issuer: \"x\"\n  \"cache/v1\"::\n    - 1\n    - 2\n"
+	var cfg Config
+	err := Unmarshal([]byte(doc), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, map[testPluginID]RawMessage{
+		{"auth", 2}:  RawMessage("issuer: \"x\"\n"),
+		{"cache", 1}: RawMessage("- 1\n- 2\n"),
+	}, cfg.Plugins)
+
+	// The raw values are decoded in a second phase.
+	var auth struct {
+		Issuer string `huml:"issuer"`
+	}
+	assert.NoError(t, Unmarshal(cfg.Plugins[testPluginID{"auth", 2}], &auth))
+	assert.Equal(t, "x", auth.Issuer)
+
+	// Keys are encoded as their text.
+	out, err := Marshal(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "%HUML v0.2.0\n"+doc, string(out))
+
+	// Invalid keys are reported.
+	err = Unmarshal([]byte("plugins::\n  auth: 1\n"), &cfg)
+	assert.EqualError(t, err, "error setting field plugins: error setting map key auth: invalid plugin id \"auth\"")
+
+	type Named string
+	f := func(name string, doc string, dst, expected any, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			err := Unmarshal([]byte(doc), dst)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, reflect.ValueOf(dst).Elem().Interface())
+
+			out, err := Marshal(expected)
+			assert.NoError(t, err)
+			assert.Equal(t, "%HUML v0.2.0\n"+doc, string(out))
+		})
+	}
+
+	f("named_string", "a: 1\nb: 2\n", &map[Named]int{}, map[Named]int{"a": 1, "b": 2}, "")
+	f("int", "\"-1\": \"x\"\n\"10\": \"y\"\n", &map[int]string{}, map[int]string{-1: "x", 10: "y"}, "")
+	f("uint8", "\"255\": true\n", &map[uint8]bool{}, map[uint8]bool{255: true}, "")
+	f("int_invalid", "a: 1\n", &map[int]int{}, nil, "error setting map key a: cannot unmarshal key \"a\" into int")
+	f("uint8_overflow", "\"256\": 1\n", &map[uint8]int{}, nil, "error setting map key 256: cannot unmarshal key \"256\" into uint8")
+	f("float", "a: 1\n", &map[float64]int{}, nil, "cannot unmarshal into map with float64 keys")
+}
+
+func TestUnitTag(t *testing.T) {
+	type Config struct {
+		Timeout  time.Duration  `huml:"timeout,unit=s"`
+		Interval time.Duration  `huml:"interval,unit=ms"`
+		Size     int64          `huml:"size,unit=MB"`
+		Cache    uint32         `huml:"cache,unit=KiB"`
+		Ratio    float64        `huml:"ratio,unit=GB"`
+		Retry    *time.Duration `huml:"retry,unit=m,omitempty"`
+	}
+
+	retry := 90 * time.Second
+	f := func(name, doc string, expected Config, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result Config
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("units",
+		"timeout: 30\ninterval: 250\nsize: 2\ncache: 4\nratio: 1.5\nretry: 1.5",
+		Config{Timeout: 30 * time.Second, Interval: 250 * time.Millisecond, Size: 2e6, Cache: 4096, Ratio: 1.5e9, Retry: &retry}, "")
+	f("fractional", "timeout: 1.1\nsize: 0.5", Config{Timeout: 1100 * time.Millisecond, Size: 5e5}, "")
+	f("negative", "timeout: -2", Config{Timeout: -2 * time.Second}, "")
+	f("too_precise", "size: 0.0000001", Config{}, "error setting field size: cannot unmarshal float 0.1 into integer type")
+	f("float_overflow", "size: 1e30", Config{}, "error setting field size: value 1e+36 overflows int64")
+	f("overflow", "cache: 4194304", Config{}, "error setting field cache: value 4294967296 overflows uint32")
+	f("int64_overflow", "timeout: 9223372036854775807", Config{}, "error setting field timeout: value 9.223372036854776e+27 overflows time.Duration")
+	f("string", "timeout: \"30s\"", Config{}, "error setting field timeout: cannot unmarshal string into integer")
+
+	// With Lenient, a string holding a number is read in the unit too.
+	var lenient Config
+	dec := NewDecoder(strings.NewReader("timeout: \"30\"\nsize: \"1.5\"\ncache: \"0x2\""))
+	dec.Lenient()
+	assert.NoError(t, dec.Decode(&lenient))
+	assert.Equal(t, Config{Timeout: 30 * time.Second, Size: 15e5, Cache: 2048}, lenient)
+
+	for doc, expected := range map[string]time.Duration{"timeout: \"010\"": 10 * time.Second, "timeout: \"1_5\"": 15 * time.Second} {
+		lenient = Config{}
+		assert.NoError(t, Unmarshal([]byte(doc), &lenient, Lenient()))
+		assert.Equal(t, expected, lenient.Timeout)
+	}
+	err := Unmarshal([]byte("timeout: \"Infinity\""), &lenient, Lenient())
+	assert.EqualError(t, err, "error setting field timeout: cannot unmarshal string \"Infinity\" into integer")
+
+	// Values are written in their unit.
+	cfg := Config{Timeout: 30 * time.Second, Interval: 1500 * time.Microsecond, Size: 2e6, Cache: 1024, Ratio: 2.5e9, Retry: &retry}
+	out, err := Marshal(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "%HUML v0.2.0\ntimeout: 30\ninterval: 1.5\nsize: 2\ncache: 1\nratio: 2.5\nretry: 1.5\n", string(out))
+
+	var result Config
+	assert.NoError(t, Unmarshal(out, &result))
+	assert.Equal(t, cfg, result)
+
+	// Unknown units and non-numbers are reported.
+	type Bad struct {
+		Timeout time.Duration `huml:"timeout,unit=days"`
+	}
+	err = Unmarshal([]byte("timeout: 1"), &Bad{})
+	assert.EqualError(t, err, "error setting field timeout: unknown unit \"days\"")
+	_, err = Marshal(Bad{})
+	assert.EqualError(t, err, "huml: field timeout: unknown unit \"days\"")
+
+	// Other fields are still set.
+	type Partial struct {
+		Timeout time.Duration `huml:"timeout,unit=days"`
+		Name    string        `huml:"name"`
+	}
+	var partial Partial
+	err = Unmarshal([]byte("timeout: 1\nname: \"x\""), &partial)
+	assert.EqualError(t, err, "error setting field timeout: unknown unit \"days\"")
+	assert.Equal(t, "x", partial.Name)
+
+	type NotNumber struct {
+		Name string `huml:"name,unit=s"`
+	}
+	_, err = Marshal(NotNumber{})
+	assert.EqualError(t, err, "huml: field name tagged unit must be a number, not string")
+}
+
+func TestTimeFormatTag(t *testing.T) {
+	type Record struct {
+		Born    time.Time  `huml:"born,timeformat=2006-01-02"`
+		Updated time.Time  `huml:"updated,timeformat=2006-01-02T15:04:05Z07:00"`
+		Created time.Time  `huml:"created"`
+		Expires *time.Time `huml:"expires,timeformat=02/01/2006"`
+	}
+
+	expires := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+	rec := Record{
+		Born:    time.Date(1990, 4, 5, 0, 0, 0, 0, time.UTC),
+		Updated: time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("", 2*60*60)),
+		Created: time.Date(2024, 5, 6, 7, 8, 9, 500, time.UTC),
+		Expires: &expires,
+	}
+	out, err := Marshal(rec)
+	assert.NoError(t, err)
+	assert.Equal(t, `%HUML v0.2.0
+born: "1990-04-05"
+updated: "2024-05-06T07:08:09+02:00"
+created: "2024-05-06T07:08:09.0000005Z"
+expires: "31/01/2030"
+`, string(out))
+
+	var result Record
+	assert.NoError(t, Unmarshal(out, &result))
+	assert.True(t, rec.Born.Equal(result.Born))
+	assert.True(t, rec.Updated.Equal(result.Updated))
+	assert.True(t, rec.Created.Equal(result.Created))
+	if assert.NotNil(t, result.Expires) {
+		assert.True(t, expires.Equal(*result.Expires))
+	}
+
+	// Null and a nil pointer stay null.
+	rec.Expires = nil
+	out, err = Marshal(rec)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "expires: null\n")
+	assert.NoError(t, Unmarshal([]byte("expires: null"), &result))
+	assert.Nil(t, result.Expires)
+
+	// A string in another layout is rejected.
+	err = Unmarshal([]byte(`born: "1990-04-05T00:00:00Z"`), &result)
+	assert.EqualError(t, err, `error setting field born: cannot parse "1990-04-05T00:00:00Z" with time format "2006-01-02"`)
+
+	type NotTime struct {
+		Name string `huml:"name,timeformat=2006"`
+	}
+	_, err = Marshal(NotTime{})
+	assert.EqualError(t, err, "huml: field name tagged timeformat must be a time.Time, not string")
+}
+
+func TestTextMarshalerValues(t *testing.T) {
+	type Config struct {
+		IPs     []net.IP  `huml:"ips,inline"`
+		Gateway net.IP    `huml:"gateway"`
+		Started time.Time `huml:"started"`
+		Backup  *net.IP   `huml:"backup"`
+	}
+
+	cfg := Config{
+		IPs:     []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("5.6.7.8")},
+		Gateway: net.ParseIP("::1"),
+		Started: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	}
+	out, err := Marshal(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, `%HUML v0.2.0
+ips:: "1.2.3.4", "5.6.7.8"
+gateway: "::1"
+started: "2024-05-06T07:08:09Z"
+backup: null
+`, string(out))
+
+	var result Config
+	assert.NoError(t, Unmarshal(out, &result))
+	assert.Equal(t, cfg.Started, result.Started)
+	assert.Nil(t, result.Backup)
+	if assert.Len(t, result.IPs, 2) {
+		assert.True(t, cfg.IPs[0].Equal(result.IPs[0]))
+		assert.True(t, cfg.IPs[1].Equal(result.IPs[1]))
+	}
+	assert.True(t, cfg.Gateway.Equal(result.Gateway))
+
+	// Pointers are allocated.
+	assert.NoError(t, Unmarshal([]byte(`backup: "10.0.0.1"`), &result))
+	if assert.NotNil(t, result.Backup) {
+		assert.Equal(t, "10.0.0.1", result.Backup.String())
+	}
+
+	// Errors from UnmarshalText are returned.
+	err = Unmarshal([]byte(`gateway: "nope"`), &result)
+	assert.EqualError(t, err, `error setting field gateway: cannot unmarshal string "nope" into net.IP: invalid IP address: nope`)
+}
+
+func TestNetipValues(t *testing.T) {
+	type Config struct {
+		Addr     netip.Addr            `huml:"addr"`
+		Zoned    netip.Addr            `huml:"zoned"`
+		Mapped   netip.Addr            `huml:"mapped"`
+		Prefix   netip.Prefix          `huml:"prefix"`
+		Listen   netip.AddrPort        `huml:"listen"`
+		Zero     netip.Addr            `huml:"zero"`
+		ZeroPort netip.AddrPort        `huml:"zero_port"`
+		Peers    []netip.AddrPort      `huml:"peers,inline"`
+		Weights  map[netip.Prefix]int  `huml:"weights"`
+		Gateway  *netip.Addr           `huml:"gateway"`
+		Backup   netip.Addr            `huml:"backup,omitempty"`
+		Routes   map[string]netip.Addr `huml:"routes,omitempty"`
+	}
+
+	gateway := netip.MustParseAddr("192.168.0.1")
+	cfg := Config{
+		Addr:    netip.MustParseAddr("2001:db8::1"),
+		Zoned:   netip.MustParseAddr("fe80::1%eth0"),
+		Mapped:  netip.MustParseAddr("::ffff:10.0.0.1"),
+		Prefix:  netip.MustParsePrefix("10.0.0.0/8"),
+		Listen:  netip.MustParseAddrPort("[fe80::1%eth0]:8080"),
+		Peers:   []netip.AddrPort{netip.MustParseAddrPort("10.0.0.2:53"), netip.MustParseAddrPort("[::1]:53")},
+		Weights: map[netip.Prefix]int{netip.MustParsePrefix("2001:db8::/32"): 2, netip.MustParsePrefix("10.0.0.0/8"): 1},
+		Gateway: &gateway,
+		Backup:  netip.MustParseAddr("10.0.0.9"),
+	}
+
+	out, err := Marshal(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, `%HUML v0.2.0
+addr: "2001:db8::1"
+zoned: "fe80::1%eth0"
+mapped: "::ffff:10.0.0.1"
+prefix: "10.0.0.0/8"
+listen: "[fe80::1%eth0]:8080"
+zero: ""
+zero_port: ""
+peers:: "10.0.0.2:53", "[::1]:53"
+weights::
+  "10.0.0.0/8": 1
+  "2001:db8::/32": 2
+gateway: "192.168.0.1"
+backup: "10.0.0.9"
+`, string(out))
+
+	var result Config
+	assert.NoError(t, Unmarshal(out, &result))
+	assert.Equal(t, cfg, result)
+	assert.Equal(t, "eth0", result.Zoned.Zone())
+	assert.True(t, result.Mapped.Is4In6())
+	assert.False(t, result.Zero.IsValid())
+
+	// A zero value is skipped by omitempty, and decodes from "".
+	cfg.Backup = netip.Addr{}
+	out, err = Marshal(cfg)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(out), "backup")
+
+	// Parse errors name the field.
+	err = Unmarshal([]byte(`addr: "10.0.0.256"`), &result)
+	assert.ErrorContains(t, err, `error setting field addr: cannot unmarshal string "10.0.0.256" into netip.Addr`)
+	err = Unmarshal([]byte(`listen: "fe80::1:8080"`), &result)
+	assert.ErrorContains(t, err, `error setting field listen: cannot unmarshal string "fe80::1:8080" into netip.AddrPort`)
+	err = Unmarshal([]byte(`prefix: "10.0.0.0/33"`), &result)
+	assert.ErrorContains(t, err, `error setting field prefix: cannot unmarshal string "10.0.0.0/33" into netip.Prefix`)
+}
+
+// testDecimal is a decimal type in the style of shopspring/decimal, which
+// keeps the text of a number, including its trailing zeros.
+type testDecimal struct {
+	text string
+}
+
+func (d *testDecimal) UnmarshalText(text []byte) error {
+	if _, err := strconv.ParseFloat(string(text), 64); err != nil {
+		return fmt.Errorf("invalid decimal %q", text)
+	}
+	d.text = string(text)
+	return nil
+}
+
+func TestTextUnmarshalerNumbers(t *testing.T) {
+	type Order struct {
+		Price    testDecimal            `huml:"price"`
+		Tax      *testDecimal           `huml:"tax"`
+		Lines    []testDecimal          `huml:"lines"`
+		Fees     map[string]testDecimal `huml:"fees"`
+		Quantity testDecimal            `huml:"quantity"`
+		Big      testDecimal            `huml:"big"`
+		Total    big.Float              `huml:"total"`
+	}
+
+	doc := `price: 10.10
+tax: 0.50
+lines:: 1.10, 2, 3.25
+fees::
+  card: 1.50
+  ship: 4.5
+quantity: 3
+big: 1_000.50
+total: 12.30
+`
+	var result Order
+	assert.NoError(t, Unmarshal([]byte(doc), &result))
+	assert.Equal(t, "10.10", result.Price.text)
+	if assert.NotNil(t, result.Tax) {
+		assert.Equal(t, "0.50", result.Tax.text)
+	}
+	assert.Equal(t, []testDecimal{{"1.10"}, {"2"}, {"3.25"}}, result.Lines)
+	assert.Equal(t, map[string]testDecimal{"card": {"1.50"}, "ship": {"4.5"}}, result.Fees)
+	assert.Equal(t, "3", result.Quantity.text)
+	assert.Equal(t, "1000.50", result.Big.text)
+	assert.Equal(t, "12.3", result.Total.Text('f', 1))
+
+	f := func(name, doc, expected, expErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result testDecimal
+			err := Unmarshal([]byte(doc), &result)
+			if expErr != "" {
+				assert.EqualError(t, err, expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result.text)
+		})
+	}
+
+	f("root", "2.500", "2.500", "")
+	f("exponent", "1.50e3", "1.50e3", "")
+	f("plus", "+1.5", "+1.5", "")
+	f("negative", "-0.10", "-0.10", "")
+	f("large", "12345678.9", "12345678.9", "")
+	f("larger_than_float", "100000000000000000000.5", "100000000000000000000.5", "")
+	f("small", "0.000001", "0.000001", "")
+	f("underscores", "0.000_001", "0.000001", "")
+	f("nan", "nan", "", "cannot unmarshal NaN into huml.testDecimal")
+	f("string", `"9.90"`, "9.90", "")
+	f("bad_string", `"x"`, "", `cannot unmarshal string "x" into huml.testDecimal: invalid decimal "x"`)
+
+	// Items of a multi-line list keep their text too.
+	var lines []testDecimal
+	assert.NoError(t, Unmarshal([]byte("- 1.0\n- 2.20\n- 3"), &lines))
+	assert.Equal(t, []testDecimal{{"1.0"}, {"2.20"}, {"3"}}, lines)
+
+	var fees map[string]testDecimal
+	assert.NoError(t, Unmarshal([]byte("card: 1.50, ship: 2.0"), &fees))
+	assert.Equal(t, map[string]testDecimal{"card": {"1.50"}, "ship": {"2.0"}}, fees)
+
+	// A number type with a text form still decodes from numbers directly.
+	var level testLevelText
+	assert.NoError(t, Unmarshal([]byte("2"), &level))
+	assert.Equal(t, testLevelText(2), level)
+}
+
+// testLevelText is a number type that also has a text form.
+type testLevelText int
+
+func (l *testLevelText) UnmarshalText(text []byte) error {
+	return fmt.Errorf("unexpected text %q", text)
+}
+
+func TestScalarField(t *testing.T) {
+	type Port struct {
+		V int `huml:",scalar"`
+	}
+	type Name struct {
+		Value string `huml:"value,scalar"`
+		Note  string `huml:"note,omitempty"`
+	}
+	type Config struct {
+		Port   Port   `huml:"port"`
+		Ports  []Port `huml:"ports,inline"`
+		Backup *Port  `huml:"backup"`
+		Name   Name   `huml:"name"`
+	}
+
+	cfg := Config{
+		Port:   Port{8080},
+		Ports:  []Port{{80}, {443}},
+		Backup: &Port{9090},
+		Name:   Name{Value: "app"},
+	}
+	out, err := Marshal(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, `%HUML v0.2.0
+port: 8080
+ports:: 80, 443
+backup: 9090
+name: "app"
+`, string(out))
+
+	var result Config
+	assert.NoError(t, Unmarshal(out, &result))
+	assert.Equal(t, cfg, result)
+
+	// A dict still sets the fields by name.
+	result = Config{}
+	assert.NoError(t, Unmarshal([]byte("name::\n  value: \"app\"\n  note: \"x\""), &result))
+	assert.Equal(t, Name{Value: "app", Note: "x"}, result.Name)
+
+	// Errors name the scalar field.
+	err = Unmarshal([]byte(`port: "http"`), &result)
+	assert.EqualError(t, err, "error setting field port: error setting field V: cannot unmarshal string into integer")
+
+	type Twice struct {
+		A int `huml:"a,scalar"`
+		B int `huml:"b,scalar"`
+	}
+	err = Unmarshal([]byte("1"), &Twice{})
+	assert.EqualError(t, err, "struct huml.Twice has more than one scalar field: a and b")
+	_, err = Marshal(Twice{})
+	assert.EqualError(t, err, "huml: struct huml.Twice has more than one scalar field: a and b")
+}
+
+type testLevel int
+
+const (
+	testLevelDebug testLevel = iota
+	testLevelInfo
+	testLevelError
+)
+
+func (l testLevel) String() string {
+	switch l {
+	case testLevelDebug:
+		return "debug"
+	case testLevelInfo:
+		return "info"
+	case testLevelError:
+		return "error"
+	}
+	return "level" + strconv.Itoa(int(l))
+}
+
+func TestEnumNames(t *testing.T) {
+	type Config struct {
+		Level    testLevel            `huml:"level"`
+		Levels   []testLevel          `huml:"levels,inline"`
+		Override *testLevel           `huml:"override"`
+		Targets  map[testLevel]string `huml:"targets"`
+	}
+
+	level := testLevelError
+	cfg := Config{
+		Level:    testLevelInfo,
+		Levels:   []testLevel{testLevelDebug, testLevelError},
+		Override: &level,
+		Targets:  map[testLevel]string{testLevelInfo: "stdout", testLevelError: "stderr"},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetEnumNames(true)
+	assert.NoError(t, enc.Encode(cfg))
+	assert.Equal(t, `level: "info"
+levels:: "debug", "error"
+override: "error"
+targets::
+  error: "stderr"
+  info: "stdout"
+`, buf.String())
+
+	enum := RegisterEnum(testLevelDebug, testLevelInfo, testLevelError)
+	var result Config
+	assert.NoError(t, Unmarshal(buf.Bytes(), &result, enum))
+	assert.Equal(t, cfg, result)
+
+	// Numbers still decode, and unknown names are rejected.
+	result = Config{}
+	assert.NoError(t, Unmarshal([]byte("level: 2\ntargets::\n  \"1\": \"x\""), &result, enum))
+	assert.Equal(t, Config{Level: testLevelError, Targets: map[testLevel]string{testLevelInfo: "x"}}, result)
+	err := Unmarshal([]byte(`level: "trace"`), &result, enum)
+	assert.EqualError(t, err, `error setting field level: unknown huml.testLevel "trace"`)
+
+	// By default, enums are written as numbers.
+	out, err := Marshal(cfg)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "level: 1\n")
+
+	assert.Panics(t, func() { RegisterEnum(testLevelInfo, time.Second)(NewDecoder(nil)) })
+}
+
+func TestErrorStrings(t *testing.T) {
+	type Result struct {
+		Job     string  `huml:"job"`
+		Err     error   `huml:"err"`
+		Wrapped error   `huml:"wrapped"`
+		Retry   error   `huml:"retry"`
+		History []error `huml:"history,inline"`
+		Addr    error   `huml:"addr"`
+	}
+
+	base := errors.New("connection refused")
+	res := Result{
+		Job:     "sync",
+		Err:     base,
+		Wrapped: fmt.Errorf("dial: %w", base),
+		History: []error{errors.New("timeout"), base},
+		Addr:    &net.AddrError{Err: "bad", Addr: "x"},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetErrorStrings(true)
+	assert.NoError(t, enc.Encode(res))
+	assert.Equal(t, `job: "sync"
+err: "connection refused"
+wrapped: "dial: connection refused"
+retry: null
+history:: "timeout", "connection refused"
+addr: "address x: bad"
+`, buf.String())
+
+	// By default, an error is written as the struct that implements it.
+	out, err := Marshal(struct {
+		Err error `huml:"err"`
+	}{base})
+	assert.NoError(t, err)
+	assert.Equal(t, "%HUML v0.2.0\nerr:: {}\n", string(out))
+}
+
+func TestAnyFieldNumberTypes(t *testing.T) {
+	type Config struct {
+		Value any            `huml:"value"`
+		List  []any          `huml:"list"`
+		Dict  map[string]any `huml:"dict"`
+		Deep  any            `huml:"deep"`
+	}
+
+	doc := `value: 1
+list:: 1, 1.0, 1e3, 0x10, -0
+dict::
+  int: 42
+  float: 42.5
+  whole: 42.0
+  nested:: 7, 7.5
+deep::
+  - ::
+    a::
+      - 1
+      - ::
+        - 2.0
+        - 3
+  - 9223372036854775807
+`
+	var cfg Config
+	assert.NoError(t, Unmarshal([]byte(doc), &cfg))
+	assert.Equal(t, Config{
+		Value: int64(1),
+		List:  []any{int64(1), float64(1), float64(1000), int64(16), int64(0)},
+		Dict: map[string]any{
+			"int":    int64(42),
+			"float":  42.5,
+			"whole":  float64(42),
+			"nested": []any{int64(7), 7.5},
+		},
+		Deep: []any{
+			map[string]any{"a": []any{int64(1), []any{float64(2), int64(3)}}},
+			int64(9223372036854775807),
+		},
+	}, cfg)
+
+	// The types survive a round trip. Whole floats need SetPlainFloats, which
+	// keeps their ".0"; by default 42.0 is written as 42.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetPlainFloats(32)
+	assert.NoError(t, enc.Encode(cfg))
+	var again Config
+	assert.NoError(t, Unmarshal(buf.Bytes(), &again))
+	assert.Equal(t, cfg, again, buf.String())
+}
+
+func TestFieldAliases(t *testing.T) {
+	type Config struct {
+		Listen  string `huml:"listen" humlalt:"bind,addr"`
+		Workers int    `huml:"workers,omitempty" humlalt:"threads"`
+	}
+
+	f := func(name, doc string, expected Config) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result Config
+			err := Unmarshal([]byte(doc), &result)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		})
+	}
+
+	f("primary", "listen: \":80\"\nworkers: 2", Config{Listen: ":80", Workers: 2})
+	f("alias", "bind: \":80\"\nthreads: 2", Config{Listen: ":80", Workers: 2})
+	f("second_alias", "addr: \":80\"", Config{Listen: ":80"})
+	f("primary_wins", "addr: \":1\"\nlisten: \":80\"\nbind: \":2\"", Config{Listen: ":80"})
+	f("alias_order", "addr: \":1\"\nbind: \":2\"", Config{Listen: ":2"})
+
+	// Only the primary name is written.
+	out, err := Marshal(Config{Listen: ":80", Workers: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "%HUML v0.2.0\nlisten: \":80\"\nworkers: 2\n", string(out))
+
+	// Errors name the field.
+	err = Unmarshal([]byte("threads: \"many\""), &Config{})
+	assert.EqualError(t, err, "error setting field workers: cannot unmarshal string into integer")
+}
+
+func TestAnonymousStructPointers(t *testing.T) {
+	var ptr *struct {
+		A     string `huml:"a"`
+		Inner *struct {
+			Deeper *struct {
+				C bool `huml:"c"`
+			} `huml:"deeper"`
+		} `huml:"inner"`
+	}
+	err := Unmarshal([]byte("a: \"x\"\ninner::\n  deeper::\n    c: true"), &ptr)
+	assert.NoError(t, err)
+	if assert.NotNil(t, ptr) && assert.NotNil(t, ptr.Inner) && assert.NotNil(t, ptr.Inner.Deeper) {
+		assert.Equal(t, "x", ptr.A)
+		assert.True(t, ptr.Inner.Deeper.C)
+	}
+
+	var items []*struct {
+		B int `huml:"b"`
+	}
+	err = Unmarshal([]byte("- ::\n  b: 1\n- null\n- :: b: 3"), &items)
+	assert.NoError(t, err)
+	if assert.Len(t, items, 3) {
+		assert.Equal(t, 1, items[0].B)
+		assert.Nil(t, items[1])
+		assert.Equal(t, 3, items[2].B)
+	}
+
+	var byName map[string]*struct {
+		B int `huml:"b"`
+	}
+	err = Unmarshal([]byte("x::\n  b: 1\ny:: {}"), &byName)
+	assert.NoError(t, err)
+	if assert.Len(t, byName, 2) {
+		assert.Equal(t, 1, byName["x"].B)
+		assert.Equal(t, 0, byName["y"].B)
+	}
+}
+
+func TestPositionalStruct(t *testing.T) {
+	type Row struct {
+		ID      int    `huml:"id,pos=0"`
+		Name    string `huml:"name,pos=1"`
+		Enabled bool   `huml:"enabled,pos=2"`
+	}
+
+	f := func(name, doc string, expected []Row, expectErr string) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			var result struct {
+				Rows []Row `huml:"rows"`
+			}
+			err := Unmarshal([]byte(doc), &result)
+			if expectErr != "" {
+				assert.EqualError(t, err, expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result.Rows)
+		})
+	}
+
+	f("list", "rows::\n  - :: 1, \"a\", true\n  - :: 2, \"b\", false", []Row{{1, "a", true}, {2, "b", false}}, "")
+	f("dict", "rows::\n  - ::\n    id: 1\n    name: \"a\"", []Row{{ID: 1, Name: "a"}}, "")
+	f("short", "rows::\n  - :: 1, \"a\"", nil, "error setting field rows: error setting slice element 0: cannot unmarshal list of 2 items into huml.Row with 3 positional fields")
+	f("long", "rows::\n  - :: 1, \"a\", true, 4", nil, "error setting field rows: error setting slice element 0: cannot unmarshal list of 4 items into huml.Row with 3 positional fields")
+	f("bad_item", "rows::\n  - :: \"x\", \"a\", true", nil, "error setting field rows: error setting slice element 0: error setting field id: cannot unmarshal string into integer")
+
+	// A single tuple.
+	var row Row
+	assert.NoError(t, Unmarshal([]byte(`7, "c", true`), &row))
+	assert.Equal(t, Row{7, "c", true}, row)
+
+	// Positions must be distinct and contiguous.
+	type Gap struct {
+		A int `huml:"a,pos=0"`
+		B int `huml:"b,pos=2"`
+	}
+	err := Unmarshal([]byte("1, 2"), &Gap{})
+	assert.EqualError(t, err, "struct huml.Gap positional fields must have distinct positions from 0 to 1")
+}
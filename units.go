@@ -0,0 +1,110 @@
+package huml
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// units maps the names accepted by the unit tag option to their size in the
+// base unit of the field: nanoseconds for durations, as in time.Duration, and
+// bytes for sizes. Sizes come in decimal (KB = 1000 bytes) and binary
+// (KiB = 1024 bytes) forms.
+var units = map[string]int64{
+	"ns": 1,
+	"us": 1e3,
+	"µs": 1e3,
+	"ms": 1e6,
+	"s":  1e9,
+	"m":  60e9,
+	"h":  3600e9,
+
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// unitScale returns the size of the unit of the field f.
+func unitScale(f structField) (int64, error) {
+	scale, ok := units[f.unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", f.unit)
+	}
+	return scale, nil
+}
+
+// fromUnit converts a number in the unit of the field f to the base unit.
+// With lenient set, a string holding a number is converted as that number,
+// as the field would otherwise coerce it without the unit. Other values are
+// returned as is, for the caller to reject.
+func fromUnit(f structField, src any, lenient bool) (any, error) {
+	scale, err := unitScale(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := src.(string); ok && lenient {
+		if n, ok := parseNumberLiteral(s); ok {
+			src = n
+		}
+	}
+
+	switch v := src.(type) {
+	case int64:
+		if v > math.MaxInt64/scale || v < math.MinInt64/scale {
+			// Out of range as an int64, which the field will report.
+			return float64(v) * float64(scale), nil
+		}
+		return v * scale, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return v, nil
+		}
+		// Scale the decimal written in the document exactly, so that 1.1
+		// seconds is 1100000000 nanoseconds rather than a float close to it.
+		r, _ := new(big.Rat).SetString(strconv.FormatFloat(v, 'g', -1, 64))
+		r.Mul(r, big.NewRat(scale, 1))
+		if r.IsInt() && r.Num().IsInt64() {
+			return r.Num().Int64(), nil
+		}
+		f, _ := r.Float64()
+		return f, nil
+	}
+	return src, nil
+}
+
+// toUnit converts the number v in the base unit to the unit of the field f.
+// The result is an integer if v is a whole number of units, and a float
+// otherwise.
+func toUnit(f structField, v reflect.Value) (reflect.Value, error) {
+	scale, err := unitScale(f)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("huml: field %s: %w", f.name, err)
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if n%scale == 0 {
+			return reflect.ValueOf(n / scale), nil
+		}
+		return reflect.ValueOf(float64(n) / float64(scale)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n%uint64(scale) == 0 {
+			return reflect.ValueOf(n / uint64(scale)), nil
+		}
+		return reflect.ValueOf(float64(n) / float64(scale)), nil
+	case reflect.Float32, reflect.Float64:
+		return reflect.ValueOf(v.Float() / float64(scale)), nil
+	}
+	return reflect.Value{}, fmt.Errorf("huml: field %s tagged unit must be a number, not %s", f.name, v.Type())
+}